@@ -0,0 +1,117 @@
+package api
+
+import (
+	"net/http"
+
+	"main.go/model"
+	"main.go/service"
+)
+
+// AuthHandler handles HTTP requests for authentication
+type AuthHandler struct {
+	authService *service.AuthService
+}
+
+// NewAuthHandler creates a new AuthHandler
+func NewAuthHandler(authService *service.AuthService) *AuthHandler {
+	return &AuthHandler{
+		authService: authService,
+	}
+}
+
+// registerRequest is the body of POST /auth/register
+type registerRequest struct {
+	FullName string `json:"fullname"`
+	UserName string `json:"username"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// loginRequest is the body of POST /auth/login
+type loginRequest struct {
+	UserName string `json:"username"`
+	Password string `json:"password"`
+}
+
+// refreshRequest is the body of POST /auth/refresh and POST /auth/logout
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Register handles the POST /auth/register endpoint
+func (h *AuthHandler) Register(w http.ResponseWriter, req *http.Request) {
+	var body registerRequest
+	err := decodeJSON(req, &body)
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	newUser := model.User{
+		FullName: body.FullName,
+		UserName: body.UserName,
+		Email:    body.Email,
+	}
+
+	user, err := h.authService.Register(req.Context(), newUser, body.Password)
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	writeResponseStatus(w, http.StatusCreated, user)
+}
+
+// Login handles the POST /auth/login endpoint
+func (h *AuthHandler) Login(w http.ResponseWriter, req *http.Request) {
+	var body loginRequest
+	err := decodeJSON(req, &body)
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	tokens, err := h.authService.Login(req.Context(), body.UserName, body.Password)
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	writeResponse(w, tokens)
+}
+
+// Refresh handles the POST /auth/refresh endpoint
+func (h *AuthHandler) Refresh(w http.ResponseWriter, req *http.Request) {
+	var body refreshRequest
+	err := decodeJSON(req, &body)
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	tokens, err := h.authService.Refresh(req.Context(), body.RefreshToken)
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	writeResponse(w, tokens)
+}
+
+// Logout handles the POST /auth/logout endpoint
+func (h *AuthHandler) Logout(w http.ResponseWriter, req *http.Request) {
+	var body refreshRequest
+	err := decodeJSON(req, &body)
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	err = h.authService.Logout(req.Context(), body.RefreshToken)
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
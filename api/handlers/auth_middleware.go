@@ -0,0 +1,68 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"main.go/auth"
+	"main.go/authz"
+	"main.go/errs"
+	"main.go/service"
+)
+
+// AuthMiddleware authenticates requests carrying a bearer access token
+type AuthMiddleware struct {
+	authService *service.AuthService
+}
+
+// NewAuthMiddleware creates a new AuthMiddleware
+func NewAuthMiddleware(authService *service.AuthService) *AuthMiddleware {
+	return &AuthMiddleware{
+		authService: authService,
+	}
+}
+
+// Authenticate puts the user of a valid bearer token into the request context.
+// Requests without an Authorization header pass through anonymously; requests
+// with an invalid token are rejected.
+func (m *AuthMiddleware) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		header := req.Header.Get("Authorization")
+		if header == "" {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		token := strings.TrimPrefix(header, "Bearer ")
+		if token == header {
+			writeError(w, req, errs.Unauthorized(errs.CodeInvalidToken, "the Authorization header must use the Bearer scheme"))
+			return
+		}
+
+		user, err := m.authService.Authenticate(req.Context(), token)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			writeError(w, req, err)
+			return
+		}
+
+		next.ServeHTTP(w, req.WithContext(auth.WithUser(req.Context(), user)))
+	})
+}
+
+// RequirePermission rejects requests whose caller is not granted the permission.
+// Routes open to everybody use an empty permission.
+func RequirePermission(authorizer *authz.Authorizer, permission string, next http.HandlerFunc) http.HandlerFunc {
+	if permission == "" {
+		return next
+	}
+
+	return func(w http.ResponseWriter, req *http.Request) {
+		if err := authorizer.Check(req.Context(), permission); err != nil {
+			writeError(w, req, err)
+			return
+		}
+
+		next(w, req)
+	}
+}
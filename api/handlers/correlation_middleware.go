@@ -0,0 +1,44 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"main.go/messaging"
+)
+
+// CorrelationIDHeader is the HTTP header carrying the correlation ID of a request
+const CorrelationIDHeader = "X-Correlation-ID"
+
+// Correlate puts the correlation ID and the W3C trace context of the request
+// into its context, so the messages published while handling it carry them.
+// The correlation ID is taken from X-Correlation-ID or X-Request-ID, or
+// generated when the request has neither, and returned in X-Correlation-ID.
+func Correlate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get(CorrelationIDHeader)
+		if id == "" {
+			id = req.Header.Get("X-Request-ID")
+		}
+		if id == "" {
+			id = newCorrelationID()
+		}
+
+		header := messaging.Header{
+			messaging.CorrelationIDHeader: id,
+			messaging.TraceParentHeader:   req.Header.Get("traceparent"),
+			messaging.TraceStateHeader:    req.Header.Get("tracestate"),
+		}
+
+		w.Header().Set(CorrelationIDHeader, id)
+		next.ServeHTTP(w, req.WithContext(messaging.ContextWithHeader(req.Context(), header)))
+	})
+}
+
+// newCorrelationID returns a random 128-bit ID in hex
+func newCorrelationID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"main.go/errs"
+)
+
+// etag returns the entity tag of a resource version
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// ifMatch returns the version the If-Match header requires, or zero when the
+// header is missing or "*" and any version will do. Tags that cannot belong
+// to any version fail the precondition right away.
+func ifMatch(req *http.Request) (int64, error) {
+	header := strings.TrimSpace(req.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return 0, nil
+	}
+
+	if strings.Contains(header, ",") {
+		return 0, errs.Validation(errs.CodeInvalidETag, "If-Match must hold a single entity tag", errs.FieldError{
+			Field:   "If-Match",
+			Message: "must be a single entity tag or *",
+		})
+	}
+
+	// Weak tags never match under the strong comparison If-Match requires
+	version, err := strconv.ParseInt(strings.Trim(header, `"`), 10, 64)
+	if err != nil || version <= 0 || !strings.HasPrefix(header, `"`) {
+		return 0, errs.PreconditionFailed(errs.CodeVersionMismatch, "If-Match does not match the current version")
+	}
+
+	return version, nil
+}
+
+// notModified sets the ETag header of the version and answers 304 Not
+// Modified when the If-None-Match header shows the client has it already
+func notModified(w http.ResponseWriter, req *http.Request, version int64) bool {
+	current := etag(version)
+	w.Header().Set("ETag", current)
+
+	header := req.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == current {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"main.go/errs"
+	"main.go/service"
+)
+
+// EventHandler serves the schema registry of the published events
+type EventHandler struct{}
+
+// NewEventHandler creates a new EventHandler
+func NewEventHandler() *EventHandler {
+	return &EventHandler{}
+}
+
+// GetEventSchemas handles the GET /events/schemas endpoint
+func (h *EventHandler) GetEventSchemas(w http.ResponseWriter, req *http.Request) {
+	writeResponse(w, service.EventSchemas())
+}
+
+// GetEventSchema handles the GET /events/schemas/{type} endpoint
+func (h *EventHandler) GetEventSchema(w http.ResponseWriter, req *http.Request) {
+	eventType := mux.Vars(req)["type"]
+
+	schema, ok := service.LookupEventSchema(eventType)
+	if !ok {
+		writeError(w, req, errs.NotFound(errs.CodeEventTypeNotFound, fmt.Sprintf("no schema for event type %q", eventType)))
+		return
+	}
+
+	writeResponse(w, schema)
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"main.go/errs"
+	"main.go/model"
+)
+
+// parsePageRequest reads the limit, cursor and sort query parameters
+func parsePageRequest(req *http.Request, sortFields []string) (model.PageRequest, error) {
+	query := req.URL.Query()
+
+	page := model.PageRequest{
+		Cursor: query.Get("cursor"),
+		Sort:   query.Get("sort"),
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return model.PageRequest{}, errs.Validation(errs.CodeInvalidQuery, fmt.Sprintf("invalid limit %q", limit), errs.FieldError{
+				Field:   "limit",
+				Message: "must be a positive integer",
+			})
+		}
+		page.Limit = n
+	}
+
+	if err := page.Validate(sortFields); err != nil {
+		return model.PageRequest{}, err
+	}
+
+	return page, nil
+}
+
+// parseSearchRequest reads the q, limit, from, cursor and sort query
+// parameters. Sort takes a comma separated list of fields, like
+// "-created_at,title".
+func parseSearchRequest(req *http.Request, sortFields []string) (model.SearchRequest, error) {
+	query := req.URL.Query()
+
+	request := model.SearchRequest{
+		Query:  query.Get("q"),
+		Cursor: query.Get("cursor"),
+	}
+
+	if sort := query.Get("sort"); sort != "" {
+		request.Sort = strings.Split(sort, ",")
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return model.SearchRequest{}, errs.Validation(errs.CodeInvalidQuery, fmt.Sprintf("invalid limit %q", limit), errs.FieldError{
+				Field:   "limit",
+				Message: "must be a positive integer",
+			})
+		}
+		request.Limit = n
+	}
+
+	if from := query.Get("from"); from != "" {
+		n, err := strconv.Atoi(from)
+		if err != nil || n < 0 {
+			return model.SearchRequest{}, errs.Validation(errs.CodeInvalidQuery, fmt.Sprintf("invalid from %q", from), errs.FieldError{
+				Field:   "from",
+				Message: "must be a non-negative integer",
+			})
+		}
+		request.From = n
+	}
+
+	if err := request.Validate(sortFields); err != nil {
+		return model.SearchRequest{}, err
+	}
+
+	return request, nil
+}
+
+// parseSuggestRequest reads the q and limit query parameters
+func parseSuggestRequest(req *http.Request) (model.SuggestRequest, error) {
+	query := req.URL.Query()
+
+	request := model.SuggestRequest{Prefix: query.Get("q")}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return model.SuggestRequest{}, errs.Validation(errs.CodeInvalidQuery, fmt.Sprintf("invalid limit %q", limit), errs.FieldError{
+				Field:   "limit",
+				Message: "must be a positive integer",
+			})
+		}
+		request.Limit = n
+	}
+
+	if err := request.Validate(); err != nil {
+		return model.SuggestRequest{}, err
+	}
+
+	return request, nil
+}
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"io"
+	"mime"
+	"net/http"
+
+	"main.go/errs"
+	"main.go/patch"
+)
+
+// maxPatchSize limits the size of patch documents
+const maxPatchSize = 1 << 20
+
+// readPatch reads the patch document of a PATCH request. Plain JSON bodies
+// are taken as merge patches, which is what PATCH accepted before.
+func readPatch(w http.ResponseWriter, req *http.Request) (patch.Patch, error) {
+	mediaType := patch.MergePatchType
+	if contentType := req.Header.Get("Content-Type"); contentType != "" {
+		parsed, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return patch.Patch{}, errs.UnsupportedMediaType(errs.CodeUnsupportedMediaType, "invalid Content-Type "+contentType)
+		}
+		if parsed != "application/json" {
+			mediaType = parsed
+		}
+	}
+
+	if mediaType != patch.MergePatchType && mediaType != patch.JSONPatchType {
+		w.Header().Set("Accept-Patch", patch.MergePatchType+", "+patch.JSONPatchType)
+		return patch.Patch{}, errs.UnsupportedMediaType(errs.CodeUnsupportedMediaType,
+			"PATCH accepts "+patch.MergePatchType+" and "+patch.JSONPatchType)
+	}
+
+	document, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxPatchSize))
+	if err != nil {
+		return patch.Patch{}, errs.Validation(errs.CodeInvalidRequestBody, "the patch document cannot be read: "+err.Error())
+	}
+
+	return patch.Patch{MediaType: mediaType, Document: document}, nil
+}
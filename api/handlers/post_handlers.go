@@ -1,11 +1,15 @@
 package api
 
 import (
-	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"main.go/errs"
 	"main.go/model"
 	"main.go/service"
 )
@@ -26,9 +30,56 @@ func NewPostHandler(postService service.PostService, messaging *service.Messagin
 
 // GetPosts handles the GET /posts endpoint
 func (h *PostHandler) GetPosts(w http.ResponseWriter, req *http.Request) {
-	posts, err := h.postService.GetPosts()
+	page, err := parsePageRequest(req, model.PostSortFields)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, req, err)
+		return
+	}
+
+	query := req.URL.Query()
+	filter := model.PostFilter{
+		Title:         query.Get("title"),
+		TitleContains: query.Get("title_contains"),
+		BodyContains:  query.Get("body_contains"),
+	}
+
+	if authorID := query.Get("author_id"); authorID != "" {
+		filter.AuthorID, err = primitive.ObjectIDFromHex(authorID)
+		if err != nil {
+			writeError(w, req, errs.Validation(errs.CodeInvalidQuery, "invalid author_id", errs.FieldError{
+				Field:   "author_id",
+				Message: "must be a 24 character hexadecimal ObjectID",
+			}))
+			return
+		}
+	}
+
+	posts, err := h.postService.GetPosts(req.Context(), filter, page)
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	writeResponse(w, posts)
+}
+
+// GetUserPosts handles the GET /users/{id}/posts endpoint
+func (h *PostHandler) GetUserPosts(w http.ResponseWriter, req *http.Request) {
+	authorID, err := model.ParseID(mux.Vars(req)["id"])
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	page, err := parsePageRequest(req, model.PostSortFields)
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	posts, err := h.postService.GetPosts(req.Context(), model.PostFilter{AuthorID: authorID}, page)
+	if err != nil {
+		writeError(w, req, err)
 		return
 	}
 
@@ -38,15 +89,15 @@ func (h *PostHandler) GetPosts(w http.ResponseWriter, req *http.Request) {
 // AddPost handles the POST /posts endpoint
 func (h *PostHandler) AddPost(w http.ResponseWriter, req *http.Request) {
 	var newPost model.Post
-	err := json.NewDecoder(req.Body).Decode(&newPost)
+	err := decodeJSON(req, &newPost)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, req, err)
 		return
 	}
 
-	post, err := h.postService.AddPost(newPost)
+	post, err := h.postService.AddPost(req.Context(), newPost)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, req, err)
 		return
 	}
 
@@ -58,9 +109,13 @@ func (h *PostHandler) GetPost(w http.ResponseWriter, req *http.Request) {
 	idParam := mux.Vars(req)["id"]
 	fmt.Println("handler: Fetching post with ID:", idParam)
 
-	post, err := h.postService.GetPostByID(idParam)
+	post, err := h.postService.GetPostByID(req.Context(), idParam)
 	if err != nil {
-		http.Error(w, "No data found with specified ID", http.StatusNotFound)
+		writeError(w, req, err)
+		return
+	}
+
+	if notModified(w, req, post.Version) {
 		return
 	}
 
@@ -72,38 +127,52 @@ func (h *PostHandler) UpdatePost(w http.ResponseWriter, req *http.Request) {
 	idParam := mux.Vars(req)["id"]
 
 	var updatedPost model.Post
-	err := json.NewDecoder(req.Body).Decode(&updatedPost)
+	err := decodeJSON(req, &updatedPost)
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	// The version comes from If-Match, never from the body
+	updatedPost.Version, err = ifMatch(req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, req, err)
 		return
 	}
 
-	err = h.postService.UpdatePost(idParam, updatedPost)
+	post, err := h.postService.UpdatePost(req.Context(), idParam, updatedPost)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, req, err)
 		return
 	}
 
-	writeResponse(w, updatedPost)
+	w.Header().Set("ETag", etag(post.Version))
+	writeResponse(w, post)
 }
 
 // PatchPost handles the PATCH /posts/{id} endpoint
 func (h *PostHandler) PatchPost(w http.ResponseWriter, req *http.Request) {
 	idParam := mux.Vars(req)["id"]
 
-	var patchedPost model.Post
-	err := json.NewDecoder(req.Body).Decode(&patchedPost)
+	p, err := readPatch(w, req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, req, err)
 		return
 	}
 
-	post, err := h.postService.PatchPost(idParam, patchedPost)
+	version, err := ifMatch(req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, req, err)
 		return
 	}
 
+	post, err := h.postService.PatchPost(req.Context(), idParam, version, p)
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(post.Version))
 	writeResponse(w, post)
 }
 
@@ -111,25 +180,168 @@ func (h *PostHandler) PatchPost(w http.ResponseWriter, req *http.Request) {
 func (h *PostHandler) DeletePost(w http.ResponseWriter, req *http.Request) {
 	idParam := mux.Vars(req)["id"]
 
-	err := h.postService.DeletePost(idParam)
+	version, err := ifMatch(req)
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	err = h.postService.DeletePost(req.Context(), idParam, version)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, req, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 }
 
-// SearchPost handles the GET /search/posts/{query} endpoint
+// GetDeletedPosts handles the GET /trash/posts endpoint
+func (h *PostHandler) GetDeletedPosts(w http.ResponseWriter, req *http.Request) {
+	page, err := parsePageRequest(req, model.PostSortFields)
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	posts, err := h.postService.GetDeletedPosts(req.Context(), model.PostFilter{}, page)
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	writeResponse(w, posts)
+}
+
+// RestorePost handles the POST /posts/{id}/restore endpoint
+func (h *PostHandler) RestorePost(w http.ResponseWriter, req *http.Request) {
+	idParam := mux.Vars(req)["id"]
+
+	post, err := h.postService.RestorePost(req.Context(), idParam)
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(post.Version))
+	writeResponse(w, post)
+}
+
+// SearchPost handles the GET /posts/search endpoint
 func (h *PostHandler) SearchPost(w http.ResponseWriter, req *http.Request) {
-	queryParam := mux.Vars(req)["query"]
+	request, err := parseSearchRequest(req, model.PostSearchSortFields)
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	filter, err := parsePostSearchFilter(req)
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	results, err := h.postService.SearchPost(req.Context(), request, filter)
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	writeResponse(w, results)
+}
+
+// parsePostSearchFilter reads the author_id, tag, month and created query
+// parameters the facets of a post search drill down with. Tag may be repeated
+// to require several tags; month is given as YYYY-MM.
+func parsePostSearchFilter(req *http.Request) (model.PostSearchFilter, error) {
+	query := req.URL.Query()
 
-	results, err := h.postService.SearchPost(queryParam)
+	var filter model.PostSearchFilter
+
+	if authorID := query.Get("author_id"); authorID != "" {
+		id, err := primitive.ObjectIDFromHex(authorID)
+		if err != nil {
+			return model.PostSearchFilter{}, errs.Validation(errs.CodeInvalidQuery, "invalid author_id", errs.FieldError{
+				Field:   "author_id",
+				Message: "must be a 24 character hexadecimal ObjectID",
+			})
+		}
+		filter.AuthorID = id
+	}
+
+	for _, tag := range query["tag"] {
+		if tag = strings.ToLower(strings.TrimSpace(tag)); tag != "" {
+			filter.Tags = append(filter.Tags, tag)
+		}
+	}
+
+	if month := query.Get("month"); month != "" {
+		t, err := time.Parse("2006-01", month)
+		if err != nil {
+			return model.PostSearchFilter{}, errs.Validation(errs.CodeInvalidQuery, fmt.Sprintf("invalid month %q", month), errs.FieldError{
+				Field:   "month",
+				Message: "must be a month formatted as YYYY-MM",
+			})
+		}
+		filter.Month = t
+	}
+
+	if created := query.Get("created"); created != "" {
+		valid := false
+		for _, r := range model.PostCreatedRanges {
+			if r == created {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return model.PostSearchFilter{}, errs.Validation(errs.CodeInvalidQuery, fmt.Sprintf("invalid created %q", created), errs.FieldError{
+				Field:   "created",
+				Message: "must be one of " + strings.Join(model.PostCreatedRanges, ", "),
+			})
+		}
+		filter.Created = created
+	}
+
+	return filter, nil
+}
+
+// SuggestPosts handles the GET /posts/suggest endpoint
+func (h *PostHandler) SuggestPosts(w http.ResponseWriter, req *http.Request) {
+	request, err := parseSuggestRequest(req)
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	suggestions, err := h.postService.SuggestPosts(req.Context(), request)
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	writeResponse(w, suggestions)
+}
+
+// GetRelatedPosts handles the GET /posts/{id}/related endpoint
+func (h *PostHandler) GetRelatedPosts(w http.ResponseWriter, req *http.Request) {
+	var request model.RelatedRequest
+	if limit := req.URL.Query().Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			writeError(w, req, errs.Validation(errs.CodeInvalidQuery, fmt.Sprintf("invalid limit %q", limit), errs.FieldError{
+				Field:   "limit",
+				Message: "must be a positive integer",
+			}))
+			return
+		}
+		request.Limit = n
+	}
+
+	posts, err := h.postService.GetRelatedPosts(req.Context(), mux.Vars(req)["id"], request)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, req, err)
 		return
 	}
 
-	// Send it as JSON in the response
-	json.NewEncoder(w).Encode(results)
+	writeResponse(w, posts)
 }
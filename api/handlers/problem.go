@@ -0,0 +1,99 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"main.go/errs"
+)
+
+// problemContentType is the media type of RFC 7807 problem details
+const problemContentType = "application/problem+json"
+
+// problem is the RFC 7807 body of every error response
+type problem struct {
+	Type     string            `json:"type"`
+	Title    string            `json:"title"`
+	Status   int               `json:"status"`
+	Detail   string            `json:"detail,omitempty"`
+	Instance string            `json:"instance"`
+	Code     errs.Code         `json:"code"`
+	Errors   []errs.FieldError `json:"errors,omitempty"`
+}
+
+// statuses maps every error kind to its HTTP status
+var statuses = map[errs.Kind]int{
+	errs.KindInternal:     http.StatusInternalServerError,
+	errs.KindNotFound:     http.StatusNotFound,
+	errs.KindValidation:   http.StatusBadRequest,
+	errs.KindConflict:     http.StatusConflict,
+	errs.KindUnauthorized: http.StatusUnauthorized,
+	errs.KindForbidden:    http.StatusForbidden,
+	errs.KindTimeout:      http.StatusGatewayTimeout,
+
+	errs.KindPreconditionFailed:   http.StatusPreconditionFailed,
+	errs.KindUnsupportedMediaType: http.StatusUnsupportedMediaType,
+}
+
+// writeError answers with the problem details of the error. Internal errors
+// are logged with their cause, but only a generic message reaches the client.
+func writeError(w http.ResponseWriter, req *http.Request, err error) {
+	e := errs.As(err)
+
+	status, ok := statuses[e.Kind]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+	if status >= http.StatusInternalServerError {
+		log.Printf("%s %s failed: %v", req.Method, req.URL.Path, err)
+	}
+
+	if status == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+
+	body := problem{
+		Type:     "/errors/" + string(e.Code),
+		Title:    errs.Titles[e.Code],
+		Status:   status,
+		Detail:   e.Message,
+		Instance: req.URL.Path,
+		Code:     e.Code,
+		Errors:   e.Fields,
+	}
+
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// decodeJSON reads the JSON request body into v. Fields that v does not
+// have are rejected rather than silently dropped.
+func decodeJSON(req *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(req.Body)
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(v)
+	if err == nil {
+		return nil
+	}
+
+	// The decoder has no typed error for unknown fields
+	const unknownField = "json: unknown field "
+	if strings.HasPrefix(err.Error(), unknownField) {
+		field := strings.TrimPrefix(err.Error(), unknownField)
+		return errs.Validation(errs.CodeInvalidRequestBody, "the request body contains an unknown field", errs.FieldError{
+			Field:   strings.Trim(field, `"`),
+			Message: "is not allowed",
+		})
+	}
+
+	return errs.Validation(errs.CodeInvalidRequestBody, "the request body is not valid JSON: "+err.Error())
+}
+
+// NotFound answers requests that match no route
+func NotFound(w http.ResponseWriter, req *http.Request) {
+	writeError(w, req, errs.NotFound(errs.CodeRouteNotFound, "no route matches "+req.Method+" "+req.URL.Path))
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"main.go/errs"
+)
+
+// GetPostRevisions handles the GET /posts/{id}/revisions endpoint
+func (h *PostHandler) GetPostRevisions(w http.ResponseWriter, req *http.Request) {
+	idParam := mux.Vars(req)["id"]
+
+	revisions, err := h.postService.GetPostRevisions(req.Context(), idParam)
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	writeResponse(w, revisions)
+}
+
+// GetPostRevision handles the GET /posts/{id}/revisions/{n} endpoint
+func (h *PostHandler) GetPostRevision(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+
+	number, err := parseRevision("n", vars["n"])
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	revision, err := h.postService.GetPostRevision(req.Context(), vars["id"], number)
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	writeResponse(w, revision)
+}
+
+// DiffPostRevisions handles the GET /posts/{id}/diff?from={n}&to={m} endpoint
+func (h *PostHandler) DiffPostRevisions(w http.ResponseWriter, req *http.Request) {
+	idParam := mux.Vars(req)["id"]
+	query := req.URL.Query()
+
+	from, err := parseRevision("from", query.Get("from"))
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+	to, err := parseRevision("to", query.Get("to"))
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	result, err := h.postService.DiffPostRevisions(req.Context(), idParam, from, to)
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	writeResponse(w, result)
+}
+
+// RestorePostRevision handles the POST /posts/{id}/revisions/{n}/restore endpoint
+func (h *PostHandler) RestorePostRevision(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+
+	number, err := parseRevision("n", vars["n"])
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	version, err := ifMatch(req)
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	post, err := h.postService.RestorePostRevision(req.Context(), vars["id"], number, version)
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(post.Version))
+	writeResponse(w, post)
+}
+
+// parseRevision reads a revision number from the named path or query parameter
+func parseRevision(field string, value string) (int64, error) {
+	number, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || number <= 0 {
+		return 0, errs.Validation(errs.CodeInvalidRevision, fmt.Sprintf("invalid revision %q", value), errs.FieldError{
+			Field:   field,
+			Message: "must be a positive integer",
+		})
+	}
+	return number, nil
+}
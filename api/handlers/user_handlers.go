@@ -1,7 +1,6 @@
 package api
 
 import (
-	"encoding/json"
 	"fmt"
 	"net/http"
 
@@ -26,9 +25,22 @@ func NewUserHandler(userService service.UserService, messaging *service.Messagin
 
 // GetUsers handles the GET /users endpoint
 func (h *UserHandler) GetUsers(w http.ResponseWriter, req *http.Request) {
-	users, err := h.userService.GetUsers()
+	page, err := parsePageRequest(req, model.UserSortFields)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, req, err)
+		return
+	}
+
+	query := req.URL.Query()
+	filter := model.UserFilter{
+		UserName:         query.Get("username"),
+		Email:            query.Get("email"),
+		FullNameContains: query.Get("fullname_contains"),
+	}
+
+	users, err := h.userService.GetUsers(req.Context(), filter, page)
+	if err != nil {
+		writeError(w, req, err)
 		return
 	}
 
@@ -38,15 +50,15 @@ func (h *UserHandler) GetUsers(w http.ResponseWriter, req *http.Request) {
 // AddUser handles the POST /users endpoint
 func (h *UserHandler) AddUser(w http.ResponseWriter, req *http.Request) {
 	var newUser model.User
-	err := json.NewDecoder(req.Body).Decode(&newUser)
+	err := decodeJSON(req, &newUser)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, req, err)
 		return
 	}
 
-	user, err := h.userService.AddUser(newUser)
+	user, err := h.userService.AddUser(req.Context(), newUser)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, req, err)
 		return
 	}
 
@@ -57,9 +69,13 @@ func (h *UserHandler) AddUser(w http.ResponseWriter, req *http.Request) {
 func (h *UserHandler) GetUser(w http.ResponseWriter, req *http.Request) {
 	idParam := mux.Vars(req)["id"]
 	fmt.Println("handler: Fetching user with ID:", idParam)
-	user, err := h.userService.GetUserByID(idParam)
+	user, err := h.userService.GetUserByID(req.Context(), idParam)
 	if err != nil {
-		http.Error(w, "No data found with specified ID", http.StatusNotFound)
+		writeError(w, req, err)
+		return
+	}
+
+	if notModified(w, req, user.Version) {
 		return
 	}
 
@@ -71,40 +87,53 @@ func (h *UserHandler) UpdateUser(w http.ResponseWriter, req *http.Request) {
 	idParam := mux.Vars(req)["id"]
 
 	var updatedUser model.User
-	err := json.NewDecoder(req.Body).Decode(&updatedUser)
+	err := decodeJSON(req, &updatedUser)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, req, err)
+		return
+	}
+
+	// The version comes from If-Match, never from the body
+	updatedUser.Version, err = ifMatch(req)
+	if err != nil {
+		writeError(w, req, err)
 		return
 	}
 
 	// updatedUser.ID = id // Remove this line
-	err = h.userService.UpdateUser(idParam, updatedUser) // Update the parameter to idParam
+	user, err := h.userService.UpdateUser(req.Context(), idParam, updatedUser)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, req, err)
 		return
 	}
 
-	writeResponse(w, updatedUser) // Use updatedUser instead of user
+	w.Header().Set("ETag", etag(user.Version))
+	writeResponse(w, user)
 }
 
 // PatchUser handles the PATCH /users/{id} endpoint
 func (h *UserHandler) PatchUser(w http.ResponseWriter, req *http.Request) {
 	idParam := mux.Vars(req)["id"]
-	// id, err := strconv.Atoi(idParam) // Remove this line
 
-	var patchedUser model.User
-	err := json.NewDecoder(req.Body).Decode(&patchedUser)
+	p, err := readPatch(w, req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, req, err)
 		return
 	}
 
-	user, err := h.userService.PatchUser(idParam, patchedUser) // Update the parameter to idParam
+	version, err := ifMatch(req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, req, err)
 		return
 	}
 
+	user, err := h.userService.PatchUser(req.Context(), idParam, version, p)
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(user.Version))
 	writeResponse(w, user)
 }
 
@@ -112,25 +141,82 @@ func (h *UserHandler) PatchUser(w http.ResponseWriter, req *http.Request) {
 func (h *UserHandler) DeleteUser(w http.ResponseWriter, req *http.Request) {
 	idParam := mux.Vars(req)["id"]
 
-	err := h.userService.DeleteUser(idParam) // Update the parameter to idParam
+	version, err := ifMatch(req)
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	err = h.userService.DeleteUser(req.Context(), idParam, version)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, req, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 }
 
-// SearchUser handles the GET /search/users/{query} endpoint
+// GetDeletedUsers handles the GET /trash/users endpoint
+func (h *UserHandler) GetDeletedUsers(w http.ResponseWriter, req *http.Request) {
+	page, err := parsePageRequest(req, model.UserSortFields)
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	users, err := h.userService.GetDeletedUsers(req.Context(), model.UserFilter{}, page)
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	writeResponse(w, users)
+}
+
+// RestoreUser handles the POST /users/{id}/restore endpoint
+func (h *UserHandler) RestoreUser(w http.ResponseWriter, req *http.Request) {
+	idParam := mux.Vars(req)["id"]
+
+	user, err := h.userService.RestoreUser(req.Context(), idParam)
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(user.Version))
+	writeResponse(w, user)
+}
+
+// SearchUser handles the GET /users/search endpoint
 func (h *UserHandler) SearchUser(w http.ResponseWriter, req *http.Request) {
-	idParam := mux.Vars(req)["query"]
+	request, err := parseSearchRequest(req, model.UserSearchSortFields)
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	results, err := h.userService.SearchUser(req.Context(), request)
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	writeResponse(w, results)
+}
+
+// SuggestUsers handles the GET /users/suggest endpoint
+func (h *UserHandler) SuggestUsers(w http.ResponseWriter, req *http.Request) {
+	request, err := parseSuggestRequest(req)
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
 
-	results, err := h.userService.SearchUser(idParam)
+	suggestions, err := h.userService.SuggestUsers(req.Context(), request)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, req, err)
 		return
 	}
 
-	// send it as JSON in the response
-	json.NewEncoder(w).Encode(results)
+	writeResponse(w, suggestions)
 }
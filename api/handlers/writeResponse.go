@@ -7,6 +7,12 @@ import (
 
 // writeResponse writes the response in JSON format
 func writeResponse(w http.ResponseWriter, data interface{}) {
+	writeResponseStatus(w, http.StatusOK, data)
+}
+
+// writeResponseStatus writes the response in JSON format with the given status code
+func writeResponseStatus(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(data)
 }
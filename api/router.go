@@ -5,43 +5,93 @@ import (
 
 	"github.com/gorilla/mux"
 	api "main.go/api/handlers"
+	"main.go/authz"
 	"main.go/service"
 )
 
 // Router handles the API routing
 type Router struct {
-	router      *mux.Router
-	postHandler *api.PostHandler
-	userHandler *api.UserHandler
+	router       *mux.Router
+	postHandler  *api.PostHandler
+	userHandler  *api.UserHandler
+	authHandler  *api.AuthHandler
+	eventHandler *api.EventHandler
+}
+
+// route is an API endpoint together with the permission it requires.
+// The services check the finer-grained permissions, such as ownership, again.
+type route struct {
+	method     string
+	path       string
+	permission string // Empty for endpoints open to everybody
+	handler    http.HandlerFunc
 }
 
 // NewRouter creates a new API router
-func NewRouter(postService *service.PostService, userService *service.UserService, messagingService *service.MessagingService) *Router {
+func NewRouter(postService *service.PostService, userService *service.UserService, authService *service.AuthService, messagingService *service.MessagingService, authorizer *authz.Authorizer) *Router {
 	router := mux.NewRouter()
 
 	postHandler := api.NewPostHandler(*postService, messagingService)
 	userHandler := api.NewUserHandler(*userService, messagingService)
+	authHandler := api.NewAuthHandler(authService)
+	eventHandler := api.NewEventHandler()
+
+	// Pass the correlation ID and trace context on to the published messages
+	router.Use(api.Correlate)
+	// Attach the caller of every request carrying a bearer token
+	router.Use(api.NewAuthMiddleware(authService).Authenticate)
+	router.NotFoundHandler = http.HandlerFunc(api.NotFound)
 
 	// Register API endpoints
-	router.HandleFunc("/posts", postHandler.GetPosts).Methods("GET")
-	router.HandleFunc("/posts", postHandler.AddPost).Methods("POST")
-	router.HandleFunc("/posts/{id}", postHandler.GetPost).Methods("GET")
-	router.HandleFunc("/posts/{id}", postHandler.UpdatePost).Methods("PUT")
-	router.HandleFunc("/posts/{id}", postHandler.PatchPost).Methods("PATCH")
-	router.HandleFunc("/posts/{id}", postHandler.DeletePost).Methods("DELETE")
-
-	router.HandleFunc("/users", userHandler.GetUsers).Methods("GET")
-	router.HandleFunc("/users", userHandler.AddUser).Methods("POST")
-	router.HandleFunc("/users/{id}", userHandler.GetUser).Methods("GET")
-	router.HandleFunc("/users/{id}", userHandler.UpdateUser).Methods("PUT")
-	router.HandleFunc("/users/{id}", userHandler.PatchUser).Methods("PATCH")
-	router.HandleFunc("/users/{id}", userHandler.DeleteUser).Methods("DELETE")
-	router.HandleFunc("/users/search/{query}", userHandler.SearchUser).Methods("GET")
+	routes := []route{
+		{"POST", "/auth/register", "", authHandler.Register},
+		{"POST", "/auth/login", "", authHandler.Login},
+		{"POST", "/auth/refresh", "", authHandler.Refresh},
+		{"POST", "/auth/logout", "", authHandler.Logout},
+
+		{"GET", "/posts", "posts:read", postHandler.GetPosts},
+		{"POST", "/posts", "posts:create", postHandler.AddPost},
+		{"GET", "/posts/search", "posts:read", postHandler.SearchPost},
+		{"GET", "/posts/suggest", "posts:read", postHandler.SuggestPosts},
+		{"GET", "/posts/{id}", "posts:read", postHandler.GetPost},
+		{"PUT", "/posts/{id}", "posts:update", postHandler.UpdatePost},
+		{"PATCH", "/posts/{id}", "posts:update", postHandler.PatchPost},
+		{"DELETE", "/posts/{id}", "posts:delete", postHandler.DeletePost},
+		{"POST", "/posts/{id}/restore", "posts:restore", postHandler.RestorePost},
+		{"GET", "/posts/{id}/related", "posts:read", postHandler.GetRelatedPosts},
+		{"GET", "/posts/{id}/revisions", "posts:read", postHandler.GetPostRevisions},
+		{"GET", "/posts/{id}/revisions/{n}", "posts:read", postHandler.GetPostRevision},
+		{"POST", "/posts/{id}/revisions/{n}/restore", "posts:update", postHandler.RestorePostRevision},
+		{"GET", "/posts/{id}/diff", "posts:read", postHandler.DiffPostRevisions},
+
+		{"GET", "/users", "users:read", userHandler.GetUsers},
+		{"POST", "/users", "users:create", userHandler.AddUser},
+		{"GET", "/users/search", "users:read", userHandler.SearchUser},
+		{"GET", "/users/suggest", "users:read", userHandler.SuggestUsers},
+		{"GET", "/users/{id}", "users:read", userHandler.GetUser},
+		{"GET", "/users/{id}/posts", "posts:read", postHandler.GetUserPosts},
+		{"PUT", "/users/{id}", "users:update", userHandler.UpdateUser},
+		{"PATCH", "/users/{id}", "users:update", userHandler.PatchUser},
+		{"DELETE", "/users/{id}", "users:delete", userHandler.DeleteUser},
+		{"POST", "/users/{id}/restore", "users:restore", userHandler.RestoreUser},
+
+		{"GET", "/events/schemas", "", eventHandler.GetEventSchemas},
+		{"GET", "/events/schemas/{type}", "", eventHandler.GetEventSchema},
+
+		{"GET", "/trash/posts", "posts:restore", postHandler.GetDeletedPosts},
+		{"GET", "/trash/users", "users:restore", userHandler.GetDeletedUsers},
+	}
+
+	for _, r := range routes {
+		router.HandleFunc(r.path, api.RequirePermission(authorizer, r.permission, r.handler)).Methods(r.method)
+	}
 
 	return &Router{
-		router:      router,
-		postHandler: postHandler,
-		userHandler: userHandler,
+		router:       router,
+		postHandler:  postHandler,
+		userHandler:  userHandler,
+		authHandler:  authHandler,
+		eventHandler: eventHandler,
 	}
 }
 
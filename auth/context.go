@@ -0,0 +1,20 @@
+package auth
+
+import (
+	"context"
+
+	"main.go/model"
+)
+
+type contextKey struct{}
+
+// WithUser returns a copy of the context carrying the authenticated user
+func WithUser(ctx context.Context, user model.User) context.Context {
+	return context.WithValue(ctx, contextKey{}, user)
+}
+
+// UserFromContext returns the authenticated user stored in the context, if any
+func UserFromContext(ctx context.Context) (model.User, bool) {
+	user, ok := ctx.Value(contextKey{}).(model.User)
+	return user, ok
+}
@@ -0,0 +1,10 @@
+package auth
+
+import "main.go/errs"
+
+var (
+	// ErrUnauthenticated is returned when an operation needs a caller but none is known
+	ErrUnauthenticated = errs.Unauthorized(errs.CodeAuthenticationRequired, "authentication required")
+	// ErrForbidden is returned when the caller may not perform the operation
+	ErrForbidden = errs.Forbidden(errs.CodePermissionDenied, "not allowed to perform this operation")
+)
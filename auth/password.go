@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+	"main.go/errs"
+)
+
+// MinPasswordLength is the shortest password accepted on registration
+const MinPasswordLength = 8
+
+// ErrInvalidCredentials is returned when a username or password does not match
+var ErrInvalidCredentials = errs.Unauthorized(errs.CodeInvalidCredentials, "invalid username or password")
+
+// HashPassword hashes a password with bcrypt for storage on the user document
+func HashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength {
+		message := fmt.Sprintf("must be at least %d characters long", MinPasswordLength)
+		return "", errs.Validation(errs.CodeValidationFailed, "invalid password", errs.FieldError{Field: "password", Message: message})
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %v", err)
+	}
+
+	return string(hash), nil
+}
+
+// CheckPassword compares a password with a hash produced by HashPassword
+func CheckPassword(hash, password string) error {
+	if hash == "" {
+		// Users created without a password can never log in
+		return ErrInvalidCredentials
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+		return ErrInvalidCredentials
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"main.go/errs"
+	"main.go/model"
+)
+
+// Token types carried in the "typ" claim, so a refresh token can never be
+// used as an access token and the other way around
+const (
+	AccessToken  = "access"
+	RefreshToken = "refresh"
+)
+
+// issuer is the "iss" claim of every token
+const issuer = "go-crud"
+
+// ErrInvalidToken is returned for tokens that are malformed, expired,
+// revoked or of the wrong type
+var ErrInvalidToken = errs.Unauthorized(errs.CodeInvalidToken, "invalid or expired token")
+
+// Claims are the JWT claims of both access and refresh tokens
+type Claims struct {
+	jwt.RegisteredClaims
+	Type     string `json:"typ"`
+	UserName string `json:"username,omitempty"`
+}
+
+// TokenPair is returned on login and refresh
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"` // Lifetime of the access token in seconds
+}
+
+// TokenIssuer signs and verifies HMAC-SHA256 access and refresh tokens
+type TokenIssuer struct {
+	secret     []byte
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+// NewTokenIssuer creates a new TokenIssuer
+func NewTokenIssuer(secret string, accessTTL, refreshTTL time.Duration) *TokenIssuer {
+	return &TokenIssuer{
+		secret:     []byte(secret),
+		accessTTL:  accessTTL,
+		refreshTTL: refreshTTL,
+	}
+}
+
+// Issue creates a new access and refresh token for the user
+func (t *TokenIssuer) Issue(user model.User) (TokenPair, error) {
+	access, err := t.sign(user, AccessToken, t.accessTTL)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	refresh, err := t.sign(user, RefreshToken, t.refreshTTL)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	return TokenPair{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(t.accessTTL.Seconds()),
+	}, nil
+}
+
+func (t *TokenIssuer) sign(user model.User, tokenType string, ttl time.Duration) (string, error) {
+	now := time.Now()
+
+	claims := Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        primitive.NewObjectID().Hex(),
+			Issuer:    issuer,
+			Subject:   user.ID.Hex(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+		Type:     tokenType,
+		UserName: user.UserName,
+	}
+
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(t.secret)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %v", err)
+	}
+
+	return signed, nil
+}
+
+// Parse verifies the signature, expiry and type of a token and returns its claims
+func (t *TokenIssuer) Parse(token string, tokenType string) (Claims, error) {
+	var claims Claims
+
+	_, err := jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (interface{}, error) {
+		return t.secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer(issuer))
+	if err != nil {
+		return Claims{}, ErrInvalidToken
+	}
+
+	if claims.Type != tokenType {
+		return Claims{}, ErrInvalidToken
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,75 @@
+package authz
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"main.go/auth"
+	"main.go/model"
+)
+
+// Authorizer checks the caller stored in a context against the policy.
+// It is used by the HTTP middleware and by the services, so the same rules
+// apply to every caller.
+type Authorizer struct {
+	policy *Policy
+}
+
+// NewAuthorizer creates a new Authorizer
+func NewAuthorizer(policy *Policy) *Authorizer {
+	return &Authorizer{
+		policy: policy,
+	}
+}
+
+// Role returns the role the caller in the context acts with
+func (a *Authorizer) Role(ctx context.Context) string {
+	user, ok := auth.UserFromContext(ctx)
+	if !ok {
+		return a.policy.AnonymousRole
+	}
+	if user.Role == "" {
+		return a.policy.DefaultRole
+	}
+	return user.Role
+}
+
+// Can reports whether the caller is granted the permission
+func (a *Authorizer) Can(ctx context.Context, permission string) bool {
+	return a.policy.Allows(a.Role(ctx), permission)
+}
+
+// Check returns an error unless the caller is granted the permission.
+// Anonymous callers get auth.ErrUnauthenticated, so they know logging in may help.
+func (a *Authorizer) Check(ctx context.Context, permission string) error {
+	if a.Can(ctx, permission) {
+		return nil
+	}
+
+	if _, ok := auth.UserFromContext(ctx); !ok {
+		return auth.ErrUnauthenticated
+	}
+	return auth.ErrForbidden
+}
+
+// CheckOwned checks a permission on a resource owned by ownerID: the caller
+// needs either the ":any" scope of the permission or, when owning the
+// resource, the ":own" scope
+func (a *Authorizer) CheckOwned(ctx context.Context, permission string, ownerID primitive.ObjectID) error {
+	if a.Can(ctx, permission+":any") {
+		return nil
+	}
+
+	user, ok := auth.UserFromContext(ctx)
+	if ok && !ownerID.IsZero() && user.ID == ownerID && a.Can(ctx, permission+":own") {
+		return nil
+	}
+
+	return a.Check(ctx, permission+":any")
+}
+
+// SystemContext returns a context acting as the application itself, for work
+// that has no end user such as event consumers. The system acts as an admin.
+func SystemContext(ctx context.Context) context.Context {
+	return auth.WithUser(ctx, model.User{UserName: "system", Role: model.RoleAdmin})
+}
@@ -0,0 +1,137 @@
+package authz
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed policy.yaml
+var defaultPolicy []byte
+
+// Policy maps roles to the permissions they are granted
+type Policy struct {
+	DefaultRole   string          `yaml:"default_role"`   // Role of users without one
+	AnonymousRole string          `yaml:"anonymous_role"` // Role of unauthenticated callers
+	Roles         map[string]Role `yaml:"roles"`
+
+	// resolved holds the permissions of every role, inherited ones included
+	resolved map[string][]string
+}
+
+// Role is a named set of permissions
+type Role struct {
+	Inherits    []string `yaml:"inherits"`
+	Permissions []string `yaml:"permissions"`
+}
+
+// DefaultPolicy returns the policy shipped with the application
+func DefaultPolicy() (*Policy, error) {
+	return parsePolicy(defaultPolicy, "default policy")
+}
+
+// LoadPolicy reads a policy file, or returns the default policy when path is empty
+func LoadPolicy(path string) (*Policy, error) {
+	if path == "" {
+		return DefaultPolicy()
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file: %v", err)
+	}
+
+	return parsePolicy(data, path)
+}
+
+func parsePolicy(data []byte, name string) (*Policy, error) {
+	var policy Policy
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&policy); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", name, err)
+	}
+
+	if err := policy.resolve(); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", name, err)
+	}
+
+	return &policy, nil
+}
+
+// resolve validates the policy and flattens the role inheritance
+func (p *Policy) resolve() error {
+	for _, role := range []string{p.DefaultRole, p.AnonymousRole} {
+		if _, ok := p.Roles[role]; !ok {
+			return fmt.Errorf("role %q is not defined", role)
+		}
+	}
+
+	p.resolved = make(map[string][]string)
+	for name := range p.Roles {
+		permissions, err := p.collect(name, map[string]bool{})
+		if err != nil {
+			return err
+		}
+		p.resolved[name] = permissions
+	}
+
+	return nil
+}
+
+// collect gathers the permissions of a role and of the roles it inherits
+func (p *Policy) collect(name string, visiting map[string]bool) ([]string, error) {
+	role, ok := p.Roles[name]
+	if !ok {
+		return nil, fmt.Errorf("role %q is not defined", name)
+	}
+	if visiting[name] {
+		return nil, fmt.Errorf("role %q inherits from itself", name)
+	}
+	visiting[name] = true
+	defer delete(visiting, name)
+
+	permissions := append([]string{}, role.Permissions...)
+	for _, parent := range role.Inherits {
+		inherited, err := p.collect(parent, visiting)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, inherited...)
+	}
+
+	return permissions, nil
+}
+
+// Allows reports whether the role is granted the permission.
+// Unknown roles are granted nothing.
+func (p *Policy) Allows(role, permission string) bool {
+	for _, granted := range p.resolved[role] {
+		if matches(granted, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches compares a granted permission with a required one segment by
+// segment. Only the segments both have are compared, so a broad grant covers
+// every narrower permission and a route asking for "posts:update" is satisfied
+// by any scope of it.
+func matches(granted, required string) bool {
+	grantedParts := strings.Split(granted, ":")
+	requiredParts := strings.Split(required, ":")
+
+	for i := 0; i < len(grantedParts) && i < len(requiredParts); i++ {
+		if grantedParts[i] != "*" && grantedParts[i] != requiredParts[i] {
+			return false
+		}
+	}
+
+	return true
+}
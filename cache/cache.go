@@ -1,11 +1,15 @@
 package cache
 
 import (
+	"context"
 	"time"
 )
 
 type Cacher interface {
-	Get(key string, v interface{}) error
-	Set(key string, v interface{}, expiration time.Duration) error
-	Delete(key string) error
+	Get(ctx context.Context, key string, v interface{}) error
+	Set(ctx context.Context, key string, v interface{}, expiration time.Duration) error
+	Delete(ctx context.Context, key string) error
+	// SetIfAbsent sets the key only when it is not set yet, as a single
+	// atomic step, and reports whether it did
+	SetIfAbsent(ctx context.Context, key string, v interface{}, expiration time.Duration) (bool, error)
 }
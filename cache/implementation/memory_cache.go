@@ -0,0 +1,126 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MemoryCache is a thread-safe in-memory implementation of cache.Cacher.
+// Values are stored JSON-encoded, exactly like RedisCache does, so callers
+// never share memory with the cache.
+type MemoryCache struct {
+	mu      sync.RWMutex
+	entries map[string]memoryEntry
+}
+
+type memoryEntry struct {
+	data      []byte
+	expiresAt time.Time // Zero means the entry never expires
+}
+
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{
+		entries: make(map[string]memoryEntry),
+	}
+}
+
+func (c *MemoryCache) Get(ctx context.Context, key string, v interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to get key '%s' from cache: %v", key, err)
+	}
+
+	c.mu.RLock()
+	entry, ok := c.entries[key]
+	c.mu.RUnlock()
+
+	if !ok || entry.expired(time.Now()) {
+		return fmt.Errorf("key '%s' not found in cache", key)
+	}
+
+	if err := json.Unmarshal(entry.data, v); err != nil {
+		return fmt.Errorf("failed to unmarshal cache data for key '%s': %v", key, err)
+	}
+
+	return nil
+}
+
+func (c *MemoryCache) Set(ctx context.Context, key string, v interface{}, expiration time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to set key '%s' in cache: %v", key, err)
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal data for key '%s': %v", key, err)
+	}
+
+	entry := memoryEntry{data: data}
+	if expiration > 0 {
+		entry.expiresAt = time.Now().Add(expiration)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[key] = entry
+	c.evictExpired(time.Now())
+
+	return nil
+}
+
+func (c *MemoryCache) SetIfAbsent(ctx context.Context, key string, v interface{}, expiration time.Duration) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, fmt.Errorf("failed to set key '%s' in cache: %v", key, err)
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal data for key '%s': %v", key, err)
+	}
+
+	entry := memoryEntry{data: data}
+	if expiration > 0 {
+		entry.expiresAt = time.Now().Add(expiration)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if existing, ok := c.entries[key]; ok && !existing.expired(time.Now()) {
+		return false, nil
+	}
+	c.entries[key] = entry
+	c.evictExpired(time.Now())
+
+	return true, nil
+}
+
+func (c *MemoryCache) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to delete key '%s' from cache: %v", key, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, key)
+
+	return nil
+}
+
+// evictExpired drops expired entries so the map does not grow forever.
+// The caller must hold the write lock.
+func (c *MemoryCache) evictExpired(now time.Time) {
+	for key, entry := range c.entries {
+		if entry.expired(now) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+func (e memoryEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && now.After(e.expiresAt)
+}
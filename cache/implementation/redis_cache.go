@@ -10,26 +10,35 @@ import (
 )
 
 type RedisCache struct {
-	client *redis.Client
+	client  *redis.Client
+	timeout time.Duration
 }
 
-func NewRedisCache(addr, password string, db int) (*RedisCache, error) {
+// NewRedisCache connects to Redis. Every operation is bounded by the given
+// timeout in addition to the deadline of the caller's context; a zero timeout
+// leaves the caller's context untouched.
+func NewRedisCache(addr, password string, db int, timeout time.Duration) (*RedisCache, error) {
 	client := redis.NewClient(&redis.Options{
 		Addr:     addr,
 		Password: password,
 		DB:       db,
 	})
 
+	ctx, cancel := withTimeout(context.Background(), timeout)
+	defer cancel()
+
 	// Ping the Redis server to ensure the connection is successful
-	if err := client.Ping(context.Background()).Err(); err != nil {
+	if err := client.Ping(ctx).Err(); err != nil {
 		return nil, fmt.Errorf("failed to connect to Redis: %v", err)
 	}
 
-	return &RedisCache{client: client}, nil
+	return &RedisCache{client: client, timeout: timeout}, nil
 }
 
-func (c *RedisCache) Get(key string, v interface{}) error {
-	ctx := context.Background()
+func (c *RedisCache) Get(ctx context.Context, key string, v interface{}) error {
+	ctx, cancel := withTimeout(ctx, c.timeout)
+	defer cancel()
+
 	data, err := c.client.Get(ctx, key).Bytes()
 	if err != nil {
 		if err == redis.Nil {
@@ -45,8 +54,9 @@ func (c *RedisCache) Get(key string, v interface{}) error {
 	return nil
 }
 
-func (c *RedisCache) Set(key string, v interface{}, expiration time.Duration) error {
-	ctx := context.Background()
+func (c *RedisCache) Set(ctx context.Context, key string, v interface{}, expiration time.Duration) error {
+	ctx, cancel := withTimeout(ctx, c.timeout)
+	defer cancel()
 
 	data, err := json.Marshal(v)
 	if err != nil {
@@ -60,11 +70,38 @@ func (c *RedisCache) Set(key string, v interface{}, expiration time.Duration) er
 	return nil
 }
 
-func (c *RedisCache) Delete(key string) error {
-	ctx := context.Background()
+func (c *RedisCache) SetIfAbsent(ctx context.Context, key string, v interface{}, expiration time.Duration) (bool, error) {
+	ctx, cancel := withTimeout(ctx, c.timeout)
+	defer cancel()
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal data for key '%s': %v", key, err)
+	}
+
+	set, err := c.client.SetNX(ctx, key, data, expiration).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to set key '%s' in cache: %v", key, err)
+	}
+
+	return set, nil
+}
+
+func (c *RedisCache) Delete(ctx context.Context, key string) error {
+	ctx, cancel := withTimeout(ctx, c.timeout)
+	defer cancel()
+
 	if err := c.client.Del(ctx, key).Err(); err != nil {
 		return fmt.Errorf("failed to delete key '%s' from cache: %v", key, err)
 	}
 
 	return nil
 }
+
+// withTimeout bounds the context by the configured per-operation timeout
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
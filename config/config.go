@@ -0,0 +1,361 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// redacted replaces secrets when the configuration is printed
+const redacted = "******"
+
+// Config is the complete configuration of the application
+type Config struct {
+	Backend       string              `yaml:"backend"` // "mongodb" or "memory"
+	Server        ServerConfig        `yaml:"server"`
+	Mongo         MongoConfig         `yaml:"mongo"`
+	Redis         RedisConfig         `yaml:"redis"`
+	Elasticsearch ElasticsearchConfig `yaml:"elasticsearch"`
+	NATS          NATSConfig          `yaml:"nats"`
+	Auth          AuthConfig          `yaml:"auth"`
+	Users         UsersConfig         `yaml:"users"`
+	Trash         TrashConfig         `yaml:"trash"`
+	Outbox        OutboxConfig        `yaml:"outbox"`
+	Worker        WorkerConfig        `yaml:"worker"`
+	Search        SearchConfig        `yaml:"search"`
+	Authz         AuthzConfig         `yaml:"authz"`
+}
+
+// ServerConfig configures the HTTP server
+type ServerConfig struct {
+	Addr string `yaml:"addr"`
+}
+
+// MongoConfig configures the MongoDB connection
+type MongoConfig struct {
+	URI      string        `yaml:"uri"`
+	Database string        `yaml:"database"`
+	Timeout  time.Duration `yaml:"timeout"` // Deadline for a single operation
+}
+
+// RedisConfig configures the Redis cache
+type RedisConfig struct {
+	Addr     string        `yaml:"addr"`
+	Password string        `yaml:"password"`
+	DB       int           `yaml:"db"`
+	Timeout  time.Duration `yaml:"timeout"` // Deadline for a single operation
+}
+
+// ElasticsearchConfig configures the Elasticsearch client
+type ElasticsearchConfig struct {
+	URL      string        `yaml:"url"`
+	Username string        `yaml:"username"`
+	Password string        `yaml:"password"`
+	Timeout  time.Duration `yaml:"timeout"` // Deadline for a single request
+}
+
+// NATSConfig configures the NATS connection
+type NATSConfig struct {
+	URL     string        `yaml:"url"`
+	Timeout time.Duration `yaml:"timeout"` // Deadline for connecting and, with JetStream, for publishing
+	Mode    string        `yaml:"mode"`    // "jetstream" for durable streams, "core" for plain publish/subscribe
+
+	// Only used by JetStream
+	Consumer     string        `yaml:"consumer"`      // Prefix of the durable consumer names
+	MaxDeliver   int           `yaml:"max_deliver"`   // Deliveries of a message before it is dead-lettered
+	AckWait      time.Duration `yaml:"ack_wait"`      // How long a delivered message may stay unacknowledged
+	RetryBackoff time.Duration `yaml:"retry_backoff"` // Delay before a failed message is redelivered, doubled on every further failure
+	MaxBackoff   time.Duration `yaml:"max_backoff"`   // Longest delay before a redelivery
+	DeadLetter   string        `yaml:"dead_letter"`   // Prefix of the subjects failed messages are moved to
+	MaxAge       time.Duration `yaml:"max_age"`       // How long the streams keep messages; 0 keeps them until the limits are hit
+}
+
+// AuthConfig configures the signing and lifetime of tokens
+type AuthConfig struct {
+	Secret     string        `yaml:"secret"` // HMAC key; a random one is generated per process when empty
+	AccessTTL  time.Duration `yaml:"access_ttl"`
+	RefreshTTL time.Duration `yaml:"refresh_ttl"`
+}
+
+// UsersConfig configures the user lifecycle
+type UsersConfig struct {
+	PostCascade     string `yaml:"post_cascade"`      // "delete", "orphan" or "reassign" the posts of deleted users
+	ReassignPostsTo string `yaml:"reassign_posts_to"` // ID of the user receiving the posts with "reassign"
+}
+
+// TrashConfig configures how long deleted posts and users can be restored
+type TrashConfig struct {
+	Retention     time.Duration `yaml:"retention"`      // Age after which deleted items are purged; 0 keeps them forever
+	PurgeInterval time.Duration `yaml:"purge_interval"` // How often the purger looks for expired items
+}
+
+// OutboxConfig configures the relay publishing the events stored in the outbox
+type OutboxConfig struct {
+	PollInterval time.Duration `yaml:"poll_interval"` // How often the outbox is checked for pending events
+	BatchSize    int           `yaml:"batch_size"`    // Most events read at once
+	RetryBackoff time.Duration `yaml:"retry_backoff"` // Delay before the first retry, doubled on every further failure
+	MaxBackoff   time.Duration `yaml:"max_backoff"`   // Longest delay between two attempts
+	Retention    time.Duration `yaml:"retention"`     // How long delivered events are kept; 0 keeps them forever
+	LeaseTTL     time.Duration `yaml:"lease_ttl"`     // How long a relay stays the only one publishing without renewing its lease
+}
+
+// WorkerConfig configures the worker keeping the cache and the search index
+// in sync with the post.* and user.* events
+type WorkerConfig struct {
+	Embedded     bool          `yaml:"embedded"`      // Run the worker inside serve; otherwise it runs as the worker command
+	Queue        string        `yaml:"queue"`         // Queue group shared by the workers, so each event is handled once
+	Retries      int           `yaml:"retries"`       // Further attempts after a failed reindex before the event fails
+	RetryBackoff time.Duration `yaml:"retry_backoff"` // Delay before the first retry, doubled on every further failure
+	MaxBackoff   time.Duration `yaml:"max_backoff"`   // Longest delay between two attempts
+}
+
+// SearchConfig configures searching the posts and users
+type SearchConfig struct {
+	SuggestCacheTTL time.Duration `yaml:"suggest_cache_ttl"` // How long the suggestions of a prefix are cached; 0 disables the cache
+	RelatedCacheTTL time.Duration `yaml:"related_cache_ttl"` // How long the posts related to a post are cached; 0 disables the cache
+}
+
+// AuthzConfig configures authorization
+type AuthzConfig struct {
+	PolicyFile string `yaml:"policy_file"` // Roles and permissions; the built-in policy is used when empty
+}
+
+// MinSecretLength is the shortest accepted token signing secret
+const MinSecretLength = 32
+
+// Default returns the configuration used when nothing else is specified
+func Default() Config {
+	return Config{
+		Backend: "mongodb",
+		Server: ServerConfig{
+			Addr: ":8080",
+		},
+		Mongo: MongoConfig{
+			URI:      "mongodb://localhost:27017",
+			Database: "project",
+			Timeout:  5 * time.Second,
+		},
+		Redis: RedisConfig{
+			Addr:    "127.0.0.1:6379",
+			Timeout: time.Second,
+		},
+		Elasticsearch: ElasticsearchConfig{
+			URL:     "http://localhost:9200",
+			Timeout: 5 * time.Second,
+		},
+		NATS: NATSConfig{
+			URL:          "nats://localhost:4222",
+			Timeout:      2 * time.Second,
+			Mode:         "jetstream",
+			Consumer:     "gocrud",
+			MaxDeliver:   5,
+			AckWait:      30 * time.Second,
+			RetryBackoff: time.Second,
+			MaxBackoff:   time.Minute,
+			DeadLetter:   "deadletter",
+			MaxAge:       7 * 24 * time.Hour,
+		},
+		Auth: AuthConfig{
+			AccessTTL:  15 * time.Minute,
+			RefreshTTL: 7 * 24 * time.Hour,
+		},
+		Users: UsersConfig{
+			PostCascade: "orphan",
+		},
+		Trash: TrashConfig{
+			Retention:     30 * 24 * time.Hour,
+			PurgeInterval: time.Hour,
+		},
+		Outbox: OutboxConfig{
+			PollInterval: time.Second,
+			BatchSize:    100,
+			RetryBackoff: time.Second,
+			MaxBackoff:   5 * time.Minute,
+			Retention:    7 * 24 * time.Hour,
+			LeaseTTL:     30 * time.Second,
+		},
+		Worker: WorkerConfig{
+			Embedded:     true,
+			Queue:        "worker",
+			Retries:      3,
+			RetryBackoff: 500 * time.Millisecond,
+			MaxBackoff:   10 * time.Second,
+		},
+		Search: SearchConfig{
+			SuggestCacheTTL: 30 * time.Second,
+			RelatedCacheTTL: time.Hour,
+		},
+	}
+}
+
+// Validate checks that the configuration is complete and consistent
+func (c Config) Validate() error {
+	if c.Server.Addr == "" {
+		return fmt.Errorf("server.addr must not be empty")
+	}
+
+	if c.Auth.Secret != "" && len(c.Auth.Secret) < MinSecretLength {
+		return fmt.Errorf("auth.secret must be at least %d characters long", MinSecretLength)
+	}
+	if c.Auth.AccessTTL <= 0 || c.Auth.RefreshTTL <= 0 {
+		return fmt.Errorf("auth.access_ttl and auth.refresh_ttl must be positive")
+	}
+	if c.Auth.RefreshTTL < c.Auth.AccessTTL {
+		return fmt.Errorf("auth.refresh_ttl must not be shorter than auth.access_ttl")
+	}
+
+	switch c.Users.PostCascade {
+	case "delete", "orphan":
+	case "reassign":
+		if _, err := primitive.ObjectIDFromHex(c.Users.ReassignPostsTo); err != nil {
+			return fmt.Errorf("users.reassign_posts_to must be a user ID when users.post_cascade is \"reassign\"")
+		}
+	default:
+		return fmt.Errorf("users.post_cascade must be \"delete\", \"orphan\" or \"reassign\", got %q", c.Users.PostCascade)
+	}
+
+	if c.Trash.Retention < 0 {
+		return fmt.Errorf("trash.retention must not be negative")
+	}
+	if c.Trash.Retention > 0 && c.Trash.PurgeInterval <= 0 {
+		return fmt.Errorf("trash.purge_interval must be positive")
+	}
+
+	if c.Outbox.PollInterval <= 0 {
+		return fmt.Errorf("outbox.poll_interval must be positive")
+	}
+	if c.Outbox.BatchSize <= 0 {
+		return fmt.Errorf("outbox.batch_size must be positive")
+	}
+	if c.Outbox.RetryBackoff <= 0 || c.Outbox.MaxBackoff < c.Outbox.RetryBackoff {
+		return fmt.Errorf("outbox.retry_backoff must be positive and at most outbox.max_backoff")
+	}
+	if c.Outbox.Retention < 0 {
+		return fmt.Errorf("outbox.retention must not be negative")
+	}
+	if c.Outbox.LeaseTTL <= c.Outbox.PollInterval {
+		return fmt.Errorf("outbox.lease_ttl must be longer than outbox.poll_interval")
+	}
+
+	if c.Worker.Queue == "" || strings.ContainsAny(c.Worker.Queue, ".*> \t") {
+		return fmt.Errorf("worker.queue must be a name without dots, wildcards or spaces")
+	}
+	if c.Worker.Retries < 0 {
+		return fmt.Errorf("worker.retries must not be negative")
+	}
+	if c.Worker.RetryBackoff <= 0 || c.Worker.MaxBackoff < c.Worker.RetryBackoff {
+		return fmt.Errorf("worker.retry_backoff must be positive and at most worker.max_backoff")
+	}
+
+	if c.Search.SuggestCacheTTL < 0 {
+		return fmt.Errorf("search.suggest_cache_ttl must not be negative")
+	}
+	if c.Search.RelatedCacheTTL < 0 {
+		return fmt.Errorf("search.related_cache_ttl must not be negative")
+	}
+
+	switch c.Backend {
+	case "memory":
+		return nil
+	case "mongodb":
+	default:
+		return fmt.Errorf("backend must be \"mongodb\" or \"memory\", got %q", c.Backend)
+	}
+
+	if c.Mongo.URI == "" {
+		return fmt.Errorf("mongo.uri must not be empty")
+	}
+	if c.Mongo.Database == "" {
+		return fmt.Errorf("mongo.database must not be empty")
+	}
+	if c.Redis.Addr == "" {
+		return fmt.Errorf("redis.addr must not be empty")
+	}
+	if c.Redis.DB < 0 {
+		return fmt.Errorf("redis.db must not be negative")
+	}
+	if _, err := url.ParseRequestURI(c.Elasticsearch.URL); err != nil {
+		return fmt.Errorf("elasticsearch.url is invalid: %v", err)
+	}
+	if c.NATS.URL == "" {
+		return fmt.Errorf("nats.url must not be empty")
+	}
+	switch c.NATS.Mode {
+	case "core":
+	case "jetstream":
+		if err := c.NATS.validateJetStream(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("nats.mode must be \"jetstream\" or \"core\", got %q", c.NATS.Mode)
+	}
+
+	timeouts := map[string]time.Duration{
+		"mongo.timeout":         c.Mongo.Timeout,
+		"redis.timeout":         c.Redis.Timeout,
+		"elasticsearch.timeout": c.Elasticsearch.Timeout,
+		"nats.timeout":          c.NATS.Timeout,
+	}
+	for key, timeout := range timeouts {
+		if timeout <= 0 {
+			return fmt.Errorf("%s must be positive", key)
+		}
+	}
+
+	return nil
+}
+
+// validateJetStream checks the settings of the JetStream streams and consumers
+func (c NATSConfig) validateJetStream() error {
+	if c.Consumer == "" || strings.ContainsAny(c.Consumer, ".*> \t") {
+		return fmt.Errorf("nats.consumer must be a name without dots, wildcards or spaces")
+	}
+	if c.MaxDeliver < 1 {
+		return fmt.Errorf("nats.max_deliver must be at least 1")
+	}
+	if c.AckWait <= 0 {
+		return fmt.Errorf("nats.ack_wait must be positive")
+	}
+	if c.RetryBackoff <= 0 || c.MaxBackoff < c.RetryBackoff {
+		return fmt.Errorf("nats.retry_backoff must be positive and at most nats.max_backoff")
+	}
+	if c.DeadLetter == "" || strings.ContainsAny(c.DeadLetter, "*> \t") || c.DeadLetter == "post" || c.DeadLetter == "user" {
+		return fmt.Errorf("nats.dead_letter must be a subject prefix without wildcards, other than post and user")
+	}
+	if c.MaxAge < 0 {
+		return fmt.Errorf("nats.max_age must not be negative")
+	}
+	return nil
+}
+
+// Redacted returns a copy of the configuration with every secret masked,
+// suitable for printing or logging
+func (c Config) Redacted() Config {
+	if c.Redis.Password != "" {
+		c.Redis.Password = redacted
+	}
+	if c.Elasticsearch.Password != "" {
+		c.Elasticsearch.Password = redacted
+	}
+	if c.Auth.Secret != "" {
+		c.Auth.Secret = redacted
+	}
+	c.Mongo.URI = redactURL(c.Mongo.URI)
+	c.NATS.URL = redactURL(c.NATS.URL)
+	c.Elasticsearch.URL = redactURL(c.Elasticsearch.URL)
+
+	return c
+}
+
+// redactURL masks the password embedded in a connection URL
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+
+	return u.Redacted()
+}
@@ -0,0 +1,218 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is prepended to the environment variable of every setting,
+// e.g. mongo.uri is read from GOCRUD_MONGO_URI
+const EnvPrefix = "GOCRUD_"
+
+// setting is a single configuration value that can be overridden by an
+// environment variable and a command-line flag
+type setting struct {
+	key    string      // Dotted key, also the path in the configuration file
+	usage  string      // Help text of the command-line flag
+	target interface{} // *string, *int, *bool, *time.Duration or *[]string inside the Config
+}
+
+// settings lists every overridable value of the configuration
+func settings(c *Config) []setting {
+	return []setting{
+		{"backend", `backend to run on: "mongodb" (MongoDB, Redis, Elasticsearch and NATS) or "memory" (self-contained, nothing persisted)`, &c.Backend},
+		{"server.addr", "address the HTTP server listens on", &c.Server.Addr},
+		{"mongo.uri", "MongoDB connection URI", &c.Mongo.URI},
+		{"mongo.database", "MongoDB database name", &c.Mongo.Database},
+		{"mongo.timeout", "deadline for a single MongoDB operation", &c.Mongo.Timeout},
+		{"redis.addr", "Redis address", &c.Redis.Addr},
+		{"redis.password", "Redis password", &c.Redis.Password},
+		{"redis.db", "Redis database number", &c.Redis.DB},
+		{"redis.timeout", "deadline for a single Redis operation", &c.Redis.Timeout},
+		{"elasticsearch.url", "Elasticsearch URL", &c.Elasticsearch.URL},
+		{"elasticsearch.username", "Elasticsearch username", &c.Elasticsearch.Username},
+		{"elasticsearch.password", "Elasticsearch password", &c.Elasticsearch.Password},
+		{"elasticsearch.timeout", "deadline for a single Elasticsearch request", &c.Elasticsearch.Timeout},
+		{"nats.url", "NATS server URL", &c.NATS.URL},
+		{"nats.timeout", "deadline for connecting to NATS and, with JetStream, for publishing", &c.NATS.Timeout},
+		{"nats.mode", `"jetstream" for durable streams and consumers or "core" for plain publish/subscribe`, &c.NATS.Mode},
+		{"nats.consumer", "prefix of the JetStream durable consumer names", &c.NATS.Consumer},
+		{"nats.max_deliver", "deliveries of a message before it is moved to the dead-letter subject", &c.NATS.MaxDeliver},
+		{"nats.ack_wait", "how long a delivered message may stay unacknowledged before it is delivered again", &c.NATS.AckWait},
+		{"nats.retry_backoff", "delay before a failed message is delivered again, doubled on every further failure", &c.NATS.RetryBackoff},
+		{"nats.max_backoff", "longest delay before a failed message is delivered again", &c.NATS.MaxBackoff},
+		{"nats.dead_letter", "prefix of the subjects failed messages are moved to", &c.NATS.DeadLetter},
+		{"nats.max_age", "how long the JetStream streams keep messages, 0 keeps them until the limits are hit", &c.NATS.MaxAge},
+		{"auth.secret", "secret used to sign access and refresh tokens", &c.Auth.Secret},
+		{"auth.access_ttl", "lifetime of access tokens", &c.Auth.AccessTTL},
+		{"auth.refresh_ttl", "lifetime of refresh tokens", &c.Auth.RefreshTTL},
+		{"users.post_cascade", `what happens to the posts of a deleted user: "delete", "orphan" or "reassign"`, &c.Users.PostCascade},
+		{"authz.policy_file", "YAML file defining roles and their permissions, the built-in policy is used when empty", &c.Authz.PolicyFile},
+		{"users.reassign_posts_to", `ID of the user receiving the posts of deleted users with "reassign"`, &c.Users.ReassignPostsTo},
+		{"trash.retention", "how long deleted posts and users can be restored before they are purged, 0 keeps them forever", &c.Trash.Retention},
+		{"trash.purge_interval", "how often expired posts and users are purged from the trash", &c.Trash.PurgeInterval},
+		{"outbox.poll_interval", "how often the outbox is checked for events to publish", &c.Outbox.PollInterval},
+		{"outbox.batch_size", "most outbox events published at once", &c.Outbox.BatchSize},
+		{"outbox.retry_backoff", "delay before publishing a failed event again, doubled on every further failure", &c.Outbox.RetryBackoff},
+		{"outbox.max_backoff", "longest delay between two attempts to publish an event", &c.Outbox.MaxBackoff},
+		{"outbox.retention", "how long published events are kept in the outbox, 0 keeps them forever", &c.Outbox.Retention},
+		{"outbox.lease_ttl", "how long a relay stays the only one publishing the outbox without renewing its lease", &c.Outbox.LeaseTTL},
+		{"worker.embedded", "run the worker syncing the cache and search index inside serve (true or false)", &c.Worker.Embedded},
+		{"worker.queue", "queue group the workers share the events in", &c.Worker.Queue},
+		{"worker.retries", "further attempts to sync a post or user before the event fails", &c.Worker.Retries},
+		{"worker.retry_backoff", "delay before syncing a post or user again, doubled on every further failure", &c.Worker.RetryBackoff},
+		{"worker.max_backoff", "longest delay between two attempts to sync a post or user", &c.Worker.MaxBackoff},
+		{"search.suggest_cache_ttl", "how long the suggestions of a prefix are cached, 0 disables the cache", &c.Search.SuggestCacheTTL},
+		{"search.related_cache_ttl", "how long the posts related to a post are cached, 0 disables the cache", &c.Search.RelatedCacheTTL},
+	}
+}
+
+// envName returns the environment variable of the setting
+func (s setting) envName() string {
+	return EnvPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(s.key))
+}
+
+// flagName returns the command-line flag of the setting
+func (s setting) flagName() string {
+	return strings.NewReplacer(".", "-", "_", "-").Replace(s.key)
+}
+
+// set parses the raw value into the configuration
+func (s setting) set(raw string) error {
+	switch target := s.target.(type) {
+	case *string:
+		*target = raw
+	case *int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("%s: invalid integer %q", s.key, raw)
+		}
+		*target = n
+	case *bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%s: invalid boolean %q", s.key, raw)
+		}
+		*target = b
+	case *time.Duration:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("%s: invalid duration %q", s.key, raw)
+		}
+		*target = d
+	case *[]string:
+		*target = nil
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*target = append(*target, item)
+			}
+		}
+	}
+
+	return nil
+}
+
+// flagValue collects a command-line value so it can be applied after the
+// configuration file and the environment
+type flagValue struct {
+	raw   map[string]string
+	key   string
+	value string
+}
+
+func (f *flagValue) String() string { return f.value }
+
+func (f *flagValue) Set(raw string) error {
+	f.value = raw
+	f.raw[f.key] = raw
+	return nil
+}
+
+// Load builds the configuration from, in increasing order of precedence,
+// the defaults, the YAML configuration file, GOCRUD_* environment variables
+// and command-line flags. The file is given with --config or GOCRUD_CONFIG.
+// The resulting configuration is validated before it is returned.
+func Load(name string, args []string) (Config, error) {
+	return LoadFlags(flag.NewFlagSet(name, flag.ContinueOnError), args)
+}
+
+// LoadFlags is Load for commands with flags of their own, defined on the
+// flag set before, which is parsed together with the configuration flags
+func LoadFlags(fs *flag.FlagSet, args []string) (Config, error) {
+	cfg := Default()
+	all := settings(&cfg)
+
+	configFile := fs.String("config", os.Getenv(EnvPrefix+"CONFIG"), "path of the YAML configuration file")
+
+	overrides := make(map[string]string)
+	for _, s := range all {
+		fs.Var(&flagValue{raw: overrides, key: s.key}, s.flagName(), fmt.Sprintf("%s (env %s)", s.usage, s.envName()))
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	if *configFile != "" {
+		if err := loadFile(*configFile, &cfg); err != nil {
+			return Config{}, err
+		}
+	}
+
+	for _, s := range all {
+		if raw, ok := os.LookupEnv(s.envName()); ok {
+			if err := s.set(raw); err != nil {
+				return Config{}, fmt.Errorf("%s: %v", s.envName(), err)
+			}
+		}
+	}
+
+	for _, s := range all {
+		if raw, ok := overrides[s.key]; ok {
+			if err := s.set(raw); err != nil {
+				return Config{}, fmt.Errorf("--%s: %v", s.flagName(), err)
+			}
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid configuration: %v", err)
+	}
+
+	return cfg, nil
+}
+
+// loadFile reads the YAML configuration file on top of the given configuration.
+// Unknown keys are rejected so typos do not go unnoticed.
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+
+	return nil
+}
+
+// Print writes the effective configuration as YAML with every secret redacted
+func Print(cfg Config) (string, error) {
+	data, err := yaml.Marshal(cfg.Redacted())
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
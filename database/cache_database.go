@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -17,8 +18,8 @@ func NewCacheDatabase(cache cache.Cacher) *CacheDatabase {
 	}
 }
 
-func (cs *CacheDatabase) Get(key string, v interface{}) error {
-	err := cs.cache.Get(key, v)
+func (cs *CacheDatabase) Get(ctx context.Context, key string, v interface{}) error {
+	err := cs.cache.Get(ctx, key, v)
 	if err != nil {
 		fmt.Printf("Cache miss: %v\n", err)
 		return err
@@ -28,8 +29,8 @@ func (cs *CacheDatabase) Get(key string, v interface{}) error {
 	return nil
 }
 
-func (cs *CacheDatabase) Set(key string, v interface{}, expiration time.Duration) error {
-	err := cs.cache.Set(key, v, expiration)
+func (cs *CacheDatabase) Set(ctx context.Context, key string, v interface{}, expiration time.Duration) error {
+	err := cs.cache.Set(ctx, key, v, expiration)
 	if err != nil {
 		fmt.Printf("Failed to set cache: %v\n", err)
 		return err
@@ -38,8 +39,8 @@ func (cs *CacheDatabase) Set(key string, v interface{}, expiration time.Duration
 	return nil
 }
 
-func (cs *CacheDatabase) Delete(key string) error {
-	err := cs.cache.Delete(key)
+func (cs *CacheDatabase) Delete(ctx context.Context, key string) error {
+	err := cs.cache.Delete(ctx, key)
 	if err != nil {
 		fmt.Printf("Failed to delete cache: %v\n", err)
 		return err
@@ -47,3 +48,13 @@ func (cs *CacheDatabase) Delete(key string) error {
 
 	return nil
 }
+
+func (cs *CacheDatabase) SetIfAbsent(ctx context.Context, key string, v interface{}, expiration time.Duration) (bool, error) {
+	set, err := cs.cache.SetIfAbsent(ctx, key, v, expiration)
+	if err != nil {
+		fmt.Printf("Failed to set cache: %v\n", err)
+		return false, err
+	}
+
+	return set, nil
+}
@@ -0,0 +1,182 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"main.go/model"
+)
+
+// OutboxMemoryDB is a thread-safe in-memory implementation of OutboxDatabase,
+// mirroring OutboxMongoDB
+type OutboxMemoryDB struct {
+	mu          sync.RWMutex
+	events      []model.OutboxEvent         // In the order they were added
+	uncommitted map[primitive.ObjectID]bool // Events added by transactions still running
+	leases      map[string]memoryLease
+}
+
+// memoryLease is the holder of a lease and when it expires
+type memoryLease struct {
+	owner     string
+	expiresAt time.Time
+}
+
+func NewOutboxMemoryDB() *OutboxMemoryDB {
+	return &OutboxMemoryDB{
+		uncommitted: make(map[primitive.ObjectID]bool),
+		leases:      make(map[string]memoryLease),
+	}
+}
+
+func (m *OutboxMemoryDB) AddEvent(ctx context.Context, event model.OutboxEvent) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	event.ID = primitive.NewObjectID()
+	event.CreatedAt = now()
+	event.NextAttemptAt = event.CreatedAt
+	event.DeliveredAt = nil
+	m.events = append(m.events, event)
+
+	// Hide the event from the relay until the transaction adding it committed,
+	// and drop it should the transaction fail
+	id := event.ID
+	if onCommit(ctx, func() { m.forget(id, false) }) {
+		m.uncommitted[id] = true
+		onRollback(ctx, func() { m.forget(id, true) })
+	}
+
+	return nil
+}
+
+// forget stops hiding the event of a transaction, removing it when the
+// transaction failed
+func (m *OutboxMemoryDB) forget(id primitive.ObjectID, remove bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.uncommitted, id)
+	if !remove {
+		return
+	}
+	for i, event := range m.events {
+		if event.ID == id {
+			m.events = append(m.events[:i], m.events[i+1:]...)
+			return
+		}
+	}
+}
+
+func (m *OutboxMemoryDB) GetPendingEvents(ctx context.Context, after *model.OutboxEvent, limit int) ([]model.OutboxEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// The events are kept in order, so those following the given one come
+	// after it in the list
+	start := 0
+	if after != nil {
+		for i, event := range m.events {
+			if event.ID == after.ID {
+				start = i + 1
+				break
+			}
+		}
+	}
+
+	events := []model.OutboxEvent{}
+	for _, event := range m.events[start:] {
+		if len(events) == limit {
+			break
+		}
+		if event.DeliveredAt == nil && !m.uncommitted[event.ID] {
+			events = append(events, event)
+		}
+	}
+
+	return events, nil
+}
+
+func (m *OutboxMemoryDB) MarkDelivered(ctx context.Context, id primitive.ObjectID, deliveredAt time.Time) error {
+	return m.updateEvent(ctx, id, func(event *model.OutboxEvent) {
+		event.DeliveredAt = &deliveredAt
+	})
+}
+
+func (m *OutboxMemoryDB) MarkFailed(ctx context.Context, id primitive.ObjectID, attempts int, nextAttemptAt time.Time, lastError string) error {
+	return m.updateEvent(ctx, id, func(event *model.OutboxEvent) {
+		event.Attempts = attempts
+		event.NextAttemptAt = nextAttemptAt
+		event.LastError = lastError
+	})
+}
+
+// updateEvent applies the update to the event, which must exist
+func (m *OutboxMemoryDB) updateEvent(ctx context.Context, id primitive.ObjectID, update func(event *model.OutboxEvent)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.events {
+		if m.events[i].ID == id {
+			update(&m.events[i])
+			return nil
+		}
+	}
+
+	return mongo.ErrNoDocuments
+}
+
+func (m *OutboxMemoryDB) DeleteDelivered(ctx context.Context, deliveredBefore time.Time) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var deleted int64
+	kept := m.events[:0]
+	for _, event := range m.events {
+		if event.DeliveredAt != nil && event.DeliveredAt.Before(deliveredBefore) {
+			deleted++
+			continue
+		}
+		kept = append(kept, event)
+	}
+	m.events = kept
+
+	return deleted, nil
+}
+
+func (m *OutboxMemoryDB) AcquireLease(ctx context.Context, name string, owner string, ttl time.Duration) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	lease, ok := m.leases[name]
+	if ok && lease.owner != owner && lease.expiresAt.After(now) {
+		return false, nil
+	}
+
+	m.leases[name] = memoryLease{owner: owner, expiresAt: now.Add(ttl)}
+	return true, nil
+}
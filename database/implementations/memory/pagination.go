@@ -0,0 +1,85 @@
+package memory
+
+import (
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"main.go/model"
+)
+
+// sortKey is the value a document is ordered by, together with its ID
+// which breaks ties the same way the MongoDB implementation does
+type sortKey struct {
+	id    primitive.ObjectID
+	value string
+}
+
+// compare orders two keys by value first and ID second
+func (k sortKey) compare(other sortKey) int {
+	if c := strings.Compare(k.value, other.value); c != 0 {
+		return c
+	}
+	return strings.Compare(k.id.Hex(), other.id.Hex())
+}
+
+// paginate sorts the keys, skips everything up to the cursor and returns the
+// positions of the documents on the requested page plus the next cursor
+func paginate(keys []sortKey, page model.PageRequest) ([]int, string, error) {
+	field, desc := page.SortField()
+
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		c := keys[order[a]].compare(keys[order[b]])
+		if desc {
+			return c > 0
+		}
+		return c < 0
+	})
+
+	start := 0
+	if page.Cursor != "" {
+		cursor, err := model.DecodeCursor(page.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+
+		after := sortKey{id: cursor.ID}
+		if field != "_id" {
+			after.value, _ = cursor.Value.(string)
+		}
+
+		for start < len(order) {
+			c := keys[order[start]].compare(after)
+			if (!desc && c > 0) || (desc && c < 0) {
+				break
+			}
+			start++
+		}
+	}
+
+	order = order[start:]
+
+	limit := page.PageLimit()
+	if len(order) <= limit {
+		return order, "", nil
+	}
+
+	order = order[:limit]
+	last := keys[order[limit-1]]
+
+	cursor := model.Cursor{ID: last.id}
+	if field != "_id" {
+		cursor.Value = last.value
+	}
+
+	return order, model.EncodeCursor(cursor), nil
+}
+
+// containsFold reports whether substr is within s, ignoring case
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
@@ -0,0 +1,341 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"main.go/model"
+)
+
+// PostMemoryDB is a thread-safe in-memory implementation of PostDatabase.
+// It mirrors the behaviour of PostMongoDB so it can stand in for it in
+// local development and tests.
+type PostMemoryDB struct {
+	mu    sync.RWMutex
+	posts map[primitive.ObjectID]model.Post
+}
+
+func NewPostMemoryDB() *PostMemoryDB {
+	return &PostMemoryDB{
+		posts: make(map[primitive.ObjectID]model.Post),
+	}
+}
+
+// remember notes how to bring back the post as it is, for the rollback of the
+// transaction about to change it. The caller holds the lock.
+func (m *PostMemoryDB) remember(ctx context.Context, id primitive.ObjectID) {
+	previous, existed := m.posts[id]
+	onRollback(ctx, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		if existed {
+			m.posts[id] = previous
+		} else {
+			delete(m.posts, id)
+		}
+	})
+}
+
+func (m *PostMemoryDB) GetPosts(ctx context.Context, filter model.PostFilter, page model.PageRequest) (model.PostPage, error) {
+	if err := ctx.Err(); err != nil {
+		return model.PostPage{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	field, _ := page.SortField()
+
+	var matches []model.Post
+	var keys []sortKey
+	for _, post := range m.posts {
+		if !matchPost(post, filter) {
+			continue
+		}
+
+		key := sortKey{id: post.ID}
+		if field == "title" {
+			key.value = post.Title
+		}
+
+		matches = append(matches, post)
+		keys = append(keys, key)
+	}
+
+	order, next, err := paginate(keys, page)
+	if err != nil {
+		return model.PostPage{}, err
+	}
+
+	posts := []model.Post{}
+	for _, i := range order {
+		posts = append(posts, matches[i])
+	}
+
+	return model.PostPage{Data: posts, NextCursor: next}, nil
+}
+
+// matchPost applies the listing filters the same way postFilterQuery does
+func matchPost(post model.Post, filter model.PostFilter) bool {
+	if (post.DeletedAt != nil) != filter.Deleted {
+		return false
+	}
+	if !filter.AuthorID.IsZero() && post.AuthorID != filter.AuthorID {
+		return false
+	}
+	if filter.Title != "" {
+		if post.Title != filter.Title {
+			return false
+		}
+	} else if filter.TitleContains != "" && !containsFold(post.Title, filter.TitleContains) {
+		return false
+	}
+	if filter.BodyContains != "" && !containsFold(post.Body, filter.BodyContains) {
+		return false
+	}
+	return true
+}
+
+func (m *PostMemoryDB) GetPostByID(ctx context.Context, id primitive.ObjectID) (model.Post, error) {
+	if err := ctx.Err(); err != nil {
+		return model.Post{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	post, ok := m.posts[id]
+	if !ok || post.DeletedAt != nil {
+		return model.Post{}, mongo.ErrNoDocuments
+	}
+
+	return post, nil
+}
+
+func (m *PostMemoryDB) GetLatestInsertedPost(ctx context.Context) (model.Post, error) {
+	if err := ctx.Err(); err != nil {
+		return model.Post{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// ObjectIDs grow with insertion time, so the largest one is the latest post
+	var latest model.Post
+	for id, post := range m.posts {
+		if post.DeletedAt != nil {
+			continue
+		}
+		if latest.ID.IsZero() || id.Hex() > latest.ID.Hex() {
+			latest = post
+		}
+	}
+
+	if latest.ID.IsZero() {
+		return model.Post{}, mongo.ErrNoDocuments
+	}
+
+	return latest, nil
+}
+
+func (m *PostMemoryDB) AddPost(ctx context.Context, post model.Post) (model.Post, error) {
+	if err := ctx.Err(); err != nil {
+		return model.Post{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if post.ID.IsZero() {
+		post.ID = primitive.NewObjectID()
+	}
+
+	if _, exists := m.posts[post.ID]; exists {
+		return model.Post{}, mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000, Message: "duplicate key error"}}}
+	}
+
+	post.Version = 1
+	post.CreatedAt = now()
+	post.UpdatedAt = post.CreatedAt
+	post.DeletedAt = nil
+	m.remember(ctx, post.ID)
+	m.posts[post.ID] = post
+
+	return post, nil
+}
+
+func (m *PostMemoryDB) UpdatePost(ctx context.Context, post model.Post) (model.Post, error) {
+	if err := ctx.Err(); err != nil {
+		return model.Post{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.posts[post.ID]
+	if err := checkVersion(ok && stored.DeletedAt == nil, stored.Version, post.Version); err != nil {
+		return model.Post{}, err
+	}
+
+	stored.Title = post.Title
+	stored.Body = post.Body
+	stored.Tags = post.Tags
+	stored.Version++
+	stored.UpdatedAt = now()
+	m.remember(ctx, post.ID)
+	m.posts[post.ID] = stored
+
+	return stored, nil
+}
+
+func (m *PostMemoryDB) PatchPost(ctx context.Context, post model.Post, fields []string) (model.Post, error) {
+	if err := ctx.Err(); err != nil {
+		return model.Post{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.posts[post.ID]
+	if err := checkVersion(ok && stored.DeletedAt == nil, stored.Version, post.Version); err != nil {
+		return model.Post{}, err
+	}
+
+	for _, field := range fields {
+		switch field {
+		case "title":
+			stored.Title = post.Title
+		case "body":
+			stored.Body = post.Body
+		case "tags":
+			stored.Tags = post.Tags
+		}
+	}
+	stored.Version++
+	stored.UpdatedAt = now()
+	m.remember(ctx, post.ID)
+	m.posts[post.ID] = stored
+
+	return stored, nil
+}
+
+func (m *PostMemoryDB) DeletePost(ctx context.Context, id primitive.ObjectID, version int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.posts[id]
+	if err := checkVersion(ok && stored.DeletedAt == nil, stored.Version, version); err != nil {
+		return err
+	}
+
+	deletedAt := now()
+	stored.DeletedAt = &deletedAt
+	stored.UpdatedAt = deletedAt
+	stored.Version++
+	m.remember(ctx, id)
+	m.posts[id] = stored
+
+	return nil
+}
+
+func (m *PostMemoryDB) GetDeletedPostByID(ctx context.Context, id primitive.ObjectID) (model.Post, error) {
+	if err := ctx.Err(); err != nil {
+		return model.Post{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	post, ok := m.posts[id]
+	if !ok || post.DeletedAt == nil {
+		return model.Post{}, mongo.ErrNoDocuments
+	}
+
+	return post, nil
+}
+
+func (m *PostMemoryDB) RestorePost(ctx context.Context, id primitive.ObjectID) (model.Post, error) {
+	if err := ctx.Err(); err != nil {
+		return model.Post{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.posts[id]
+	if !ok || stored.DeletedAt == nil {
+		return model.Post{}, mongo.ErrNoDocuments
+	}
+
+	stored.DeletedAt = nil
+	stored.UpdatedAt = now()
+	stored.Version++
+	m.remember(ctx, id)
+	m.posts[id] = stored
+
+	return stored, nil
+}
+
+func (m *PostMemoryDB) PurgePosts(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var purged int64
+	for id, post := range m.posts {
+		if post.DeletedAt != nil && post.DeletedAt.Before(deletedBefore) {
+			m.remember(ctx, id)
+			delete(m.posts, id)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+func (m *PostMemoryDB) ReassignPosts(ctx context.Context, from primitive.ObjectID, to primitive.ObjectID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, post := range m.posts {
+		if post.AuthorID == from {
+			post.AuthorID = to
+			post.UpdatedAt = now()
+			post.Version++
+			m.remember(ctx, id)
+			m.posts[id] = post
+		}
+	}
+
+	return nil
+}
+
+// GetPostsFromDB is GetPosts, the posts are not cached
+func (m *PostMemoryDB) GetPostsFromDB(ctx context.Context, filter model.PostFilter, page model.PageRequest) (model.PostPage, error) {
+	return m.GetPosts(ctx, filter, page)
+}
+
+// GetPostByIDFromDB is GetPostByID, the posts are not cached
+func (m *PostMemoryDB) GetPostByIDFromDB(ctx context.Context, id primitive.ObjectID) (model.Post, error) {
+	return m.GetPostByID(ctx, id)
+}
+
+// InvalidateCache does nothing, the posts are not cached
+func (m *PostMemoryDB) InvalidateCache(ctx context.Context, id primitive.ObjectID) error {
+	return nil
+}
@@ -0,0 +1,96 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"main.go/model"
+)
+
+// PostRevisionMemoryDB is a thread-safe in-memory implementation of
+// PostRevisionDatabase, mirroring PostRevisionMongoDB
+type PostRevisionMemoryDB struct {
+	mu        sync.RWMutex
+	revisions map[primitive.ObjectID][]model.PostRevision
+}
+
+func NewPostRevisionMemoryDB() *PostRevisionMemoryDB {
+	return &PostRevisionMemoryDB{
+		revisions: make(map[primitive.ObjectID][]model.PostRevision),
+	}
+}
+
+// remember notes how to bring back the revisions of the post as they are,
+// for the rollback of the transaction about to add one. The caller holds the
+// lock.
+func (m *PostRevisionMemoryDB) remember(ctx context.Context, postID primitive.ObjectID) {
+	// Copied, as adding a revision sorts the slice in place
+	previous, existed := m.revisions[postID]
+	previous = append([]model.PostRevision(nil), previous...)
+	onRollback(ctx, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		if existed {
+			m.revisions[postID] = previous
+		} else {
+			delete(m.revisions, postID)
+		}
+	})
+}
+
+func (m *PostRevisionMemoryDB) AddPostRevision(ctx context.Context, revision model.PostRevision) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	revisions := m.revisions[revision.PostID]
+	for _, existing := range revisions {
+		if existing.Number == revision.Number {
+			return duplicateKey("post_revisions", "post_revision_unique")
+		}
+	}
+
+	revisions = append(revisions, revision)
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].Number < revisions[j].Number
+	})
+	m.remember(ctx, revision.PostID)
+	m.revisions[revision.PostID] = revisions
+
+	return nil
+}
+
+func (m *PostRevisionMemoryDB) GetPostRevisions(ctx context.Context, postID primitive.ObjectID) ([]model.PostRevision, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return append([]model.PostRevision{}, m.revisions[postID]...), nil
+}
+
+func (m *PostRevisionMemoryDB) GetPostRevision(ctx context.Context, postID primitive.ObjectID, number int64) (model.PostRevision, error) {
+	if err := ctx.Err(); err != nil {
+		return model.PostRevision{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, revision := range m.revisions[postID] {
+		if revision.Number == number {
+			return revision, nil
+		}
+	}
+
+	return model.PostRevision{}, mongo.ErrNoDocuments
+}
@@ -0,0 +1,91 @@
+package memory
+
+import (
+	"context"
+	"sync"
+)
+
+// Transactor stands in for the MongoDB transactor. The in-memory databases
+// note how to undo every write made with the context of a transaction, and
+// the writes are undone, last first, when fn fails. Unlike MongoDB, other
+// callers see the writes before the transaction ends, except for the events
+// added to the outbox, which the relay only sees once it committed.
+type Transactor struct{}
+
+func NewTransactor() *Transactor {
+	return &Transactor{}
+}
+
+func (t *Transactor) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	// Nested calls join the outer transaction, which undoes their writes too
+	if _, ok := ctx.Value(transactionKey{}).(*transaction); ok {
+		return fn(ctx)
+	}
+
+	tx := &transaction{}
+	err := fn(context.WithValue(ctx, transactionKey{}, tx))
+	if err != nil {
+		tx.rollback()
+		return err
+	}
+	tx.commit()
+	return nil
+}
+
+type transactionKey struct{}
+
+// transaction collects how to undo the writes made in it, and what to do
+// once they are committed
+type transaction struct {
+	mu        sync.Mutex
+	undo      []func()
+	committed []func()
+}
+
+func (tx *transaction) commit() {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	for _, fn := range tx.committed {
+		fn()
+	}
+	tx.committed = nil
+}
+
+func (tx *transaction) rollback() {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		tx.undo[i]()
+	}
+	tx.undo = nil
+}
+
+// onRollback registers how to undo a write made with the context, when it
+// is part of a transaction. The undo function takes the locks it needs.
+func onRollback(ctx context.Context, undo func()) {
+	tx, ok := ctx.Value(transactionKey{}).(*transaction)
+	if !ok {
+		return
+	}
+
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	tx.undo = append(tx.undo, undo)
+}
+
+// onCommit registers what to do once the transaction a write made with the
+// context is part of committed, and reports whether it is part of one. The
+// function takes the locks it needs.
+func onCommit(ctx context.Context, fn func()) bool {
+	tx, ok := ctx.Value(transactionKey{}).(*transaction)
+	if !ok {
+		return false
+	}
+
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	tx.committed = append(tx.committed, fn)
+	return true
+}
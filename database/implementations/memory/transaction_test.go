@@ -0,0 +1,95 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"main.go/model"
+)
+
+func pendingEvents(t *testing.T, outbox *OutboxMemoryDB) int {
+	t.Helper()
+
+	events, err := outbox.GetPendingEvents(context.Background(), nil, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(events)
+}
+
+func TestTransactionHidesEventsUntilCommitted(t *testing.T) {
+	outbox := NewOutboxMemoryDB()
+
+	err := NewTransactor().WithTransaction(context.Background(), func(ctx context.Context) error {
+		if err := outbox.AddEvent(ctx, model.OutboxEvent{Subject: "post.added"}); err != nil {
+			return err
+		}
+		if n := pendingEvents(t, outbox); n != 0 {
+			t.Errorf("expected the event to be hidden before the commit, got %d pending", n)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := pendingEvents(t, outbox); n != 1 {
+		t.Fatalf("expected the committed event to be pending, got %d", n)
+	}
+}
+
+func TestTransactionRollsBackEveryWrite(t *testing.T) {
+	posts := NewPostMemoryDB()
+	revisions := NewPostRevisionMemoryDB()
+	outbox := NewOutboxMemoryDB()
+
+	post, err := posts.AddPost(context.Background(), model.Post{Title: "Hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	added := primitive.NewObjectID()
+
+	failure := errors.New("failure")
+	err = NewTransactor().WithTransaction(context.Background(), func(ctx context.Context) error {
+		post.Title = "Changed"
+		if _, err := posts.UpdatePost(ctx, post); err != nil {
+			return err
+		}
+		if _, err := posts.AddPost(ctx, model.Post{ID: added, Title: "Added"}); err != nil {
+			return err
+		}
+		if err := revisions.AddPostRevision(ctx, model.PostRevision{PostID: post.ID, Number: 2}); err != nil {
+			return err
+		}
+
+		// Nested transactions join the outer one
+		return NewTransactor().WithTransaction(ctx, func(ctx context.Context) error {
+			if err := outbox.AddEvent(ctx, model.OutboxEvent{Subject: "post.updated"}); err != nil {
+				return err
+			}
+			return failure
+		})
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("expected the failure of fn, got %v", err)
+	}
+
+	stored, err := posts.GetPostByID(context.Background(), post.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Title != "Hello" || stored.Version != 1 {
+		t.Errorf("expected the update to be undone, got %+v", stored)
+	}
+	if _, err := posts.GetPostByID(context.Background(), added); err == nil {
+		t.Error("expected the added post to be removed")
+	}
+	if stored, _ := revisions.GetPostRevisions(context.Background(), post.ID); len(stored) != 0 {
+		t.Errorf("expected the revision to be removed, got %+v", stored)
+	}
+	if n := pendingEvents(t, outbox); n != 0 {
+		t.Errorf("expected the event to be removed, got %d pending", n)
+	}
+}
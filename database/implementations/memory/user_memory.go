@@ -0,0 +1,377 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"main.go/model"
+)
+
+// UserMemoryDB is a thread-safe in-memory implementation of UserDatabase.
+// It mirrors the behaviour of UserMongoDB so it can stand in for it in
+// local development and tests.
+type UserMemoryDB struct {
+	mu    sync.RWMutex
+	users map[primitive.ObjectID]model.User
+}
+
+func NewUserMemoryDB() *UserMemoryDB {
+	return &UserMemoryDB{
+		users: make(map[primitive.ObjectID]model.User),
+	}
+}
+
+// remember notes how to bring back the user as it is, for the rollback of the
+// transaction about to change it. The caller holds the lock.
+func (m *UserMemoryDB) remember(ctx context.Context, id primitive.ObjectID) {
+	previous, existed := m.users[id]
+	onRollback(ctx, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		if existed {
+			m.users[id] = previous
+		} else {
+			delete(m.users, id)
+		}
+	})
+}
+
+func (m *UserMemoryDB) GetUsers(ctx context.Context, filter model.UserFilter, page model.PageRequest) (model.UserPage, error) {
+	if err := ctx.Err(); err != nil {
+		return model.UserPage{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	field, _ := page.SortField()
+
+	var matches []model.User
+	var keys []sortKey
+	for _, user := range m.users {
+		if !matchUser(user, filter) {
+			continue
+		}
+
+		key := sortKey{id: user.ID}
+		switch field {
+		case "username":
+			key.value = user.UserName
+		case "fullname":
+			key.value = user.FullName
+		case "email":
+			key.value = user.Email
+		}
+
+		matches = append(matches, user)
+		keys = append(keys, key)
+	}
+
+	order, next, err := paginate(keys, page)
+	if err != nil {
+		return model.UserPage{}, err
+	}
+
+	users := []model.User{}
+	for _, i := range order {
+		users = append(users, matches[i])
+	}
+
+	return model.UserPage{Data: users, NextCursor: next}, nil
+}
+
+// matchUser applies the listing filters the same way userFilterQuery does
+func matchUser(user model.User, filter model.UserFilter) bool {
+	if (user.DeletedAt != nil) != filter.Deleted {
+		return false
+	}
+	if filter.UserName != "" && !strings.EqualFold(user.UserName, filter.UserName) {
+		return false
+	}
+	if filter.Email != "" && !strings.EqualFold(user.Email, filter.Email) {
+		return false
+	}
+	if filter.FullNameContains != "" && !containsFold(user.FullName, filter.FullNameContains) {
+		return false
+	}
+	return true
+}
+
+func (m *UserMemoryDB) GetUserByID(ctx context.Context, id primitive.ObjectID) (model.User, error) {
+	if err := ctx.Err(); err != nil {
+		return model.User{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, ok := m.users[id]
+	if !ok || user.DeletedAt != nil {
+		return model.User{}, mongo.ErrNoDocuments
+	}
+
+	return user, nil
+}
+
+func (m *UserMemoryDB) GetUserByUserName(ctx context.Context, username string) (model.User, error) {
+	if err := ctx.Err(); err != nil {
+		return model.User{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, user := range m.users {
+		if strings.EqualFold(user.UserName, username) && user.DeletedAt == nil {
+			return user, nil
+		}
+	}
+
+	return model.User{}, mongo.ErrNoDocuments
+}
+
+func (m *UserMemoryDB) GetLatestInsertedUser(ctx context.Context) (model.User, error) {
+	if err := ctx.Err(); err != nil {
+		return model.User{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// ObjectIDs grow with insertion time, so the largest one is the latest user
+	var latest model.User
+	for id, user := range m.users {
+		if user.DeletedAt != nil {
+			continue
+		}
+		if latest.ID.IsZero() || id.Hex() > latest.ID.Hex() {
+			latest = user
+		}
+	}
+
+	if latest.ID.IsZero() {
+		return model.User{}, mongo.ErrNoDocuments
+	}
+
+	return latest, nil
+}
+
+func (m *UserMemoryDB) AddUser(ctx context.Context, user model.User) (model.User, error) {
+	if err := ctx.Err(); err != nil {
+		return model.User{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+
+	if _, exists := m.users[user.ID]; exists {
+		return model.User{}, mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000, Message: "duplicate key error"}}}
+	}
+	if err := m.checkUnique(user); err != nil {
+		return model.User{}, err
+	}
+
+	user.Version = 1
+	user.CreatedAt = now()
+	user.UpdatedAt = user.CreatedAt
+	user.DeletedAt = nil
+	m.remember(ctx, user.ID)
+	m.users[user.ID] = user
+
+	return user, nil
+}
+
+func (m *UserMemoryDB) UpdateUser(ctx context.Context, user model.User) (model.User, error) {
+	if err := ctx.Err(); err != nil {
+		return model.User{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkUnique(user); err != nil {
+		return model.User{}, err
+	}
+
+	stored, ok := m.users[user.ID]
+	if err := checkVersion(ok && stored.DeletedAt == nil, stored.Version, user.Version); err != nil {
+		return model.User{}, err
+	}
+
+	stored.FullName = user.FullName
+	stored.UserName = user.UserName
+	stored.Email = user.Email
+	stored.Version++
+	stored.UpdatedAt = now()
+	m.remember(ctx, user.ID)
+	m.users[user.ID] = stored
+
+	return stored, nil
+}
+
+func (m *UserMemoryDB) PatchUser(ctx context.Context, user model.User, fields []string) (model.User, error) {
+	if err := ctx.Err(); err != nil {
+		return model.User{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkUnique(user); err != nil {
+		return model.User{}, err
+	}
+
+	stored, ok := m.users[user.ID]
+	if err := checkVersion(ok && stored.DeletedAt == nil, stored.Version, user.Version); err != nil {
+		return model.User{}, err
+	}
+
+	for _, field := range fields {
+		switch field {
+		case "fullname":
+			stored.FullName = user.FullName
+		case "username":
+			stored.UserName = user.UserName
+		case "email":
+			stored.Email = user.Email
+		}
+	}
+	stored.Version++
+	stored.UpdatedAt = now()
+	m.remember(ctx, user.ID)
+	m.users[user.ID] = stored
+
+	return stored, nil
+}
+
+// checkUnique mimics the unique indexes of UserMongoDB on username and email,
+// which ignore case. The caller must hold the lock.
+func (m *UserMemoryDB) checkUnique(user model.User) error {
+	for id, stored := range m.users {
+		if id == user.ID {
+			continue
+		}
+		if user.UserName != "" && strings.EqualFold(stored.UserName, user.UserName) {
+			return duplicateKey("users", "username_unique")
+		}
+		if user.Email != "" && strings.EqualFold(stored.Email, user.Email) {
+			return duplicateKey("users", "email_unique")
+		}
+	}
+
+	return nil
+}
+
+// duplicateKey builds the error MongoDB reports when a unique index is violated
+func duplicateKey(collection, index string) error {
+	return mongo.WriteException{WriteErrors: []mongo.WriteError{{
+		Code:    11000,
+		Message: fmt.Sprintf("E11000 duplicate key error collection: %s index: %s", collection, index),
+	}}}
+}
+
+func (m *UserMemoryDB) DeleteUser(ctx context.Context, id primitive.ObjectID, version int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.users[id]
+	if err := checkVersion(ok && stored.DeletedAt == nil, stored.Version, version); err != nil {
+		return err
+	}
+
+	deletedAt := now()
+	stored.DeletedAt = &deletedAt
+	stored.UpdatedAt = deletedAt
+	stored.Version++
+	m.remember(ctx, id)
+	m.users[id] = stored
+
+	return nil
+}
+
+func (m *UserMemoryDB) GetDeletedUserByID(ctx context.Context, id primitive.ObjectID) (model.User, error) {
+	if err := ctx.Err(); err != nil {
+		return model.User{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, ok := m.users[id]
+	if !ok || user.DeletedAt == nil {
+		return model.User{}, mongo.ErrNoDocuments
+	}
+
+	return user, nil
+}
+
+func (m *UserMemoryDB) RestoreUser(ctx context.Context, id primitive.ObjectID) (model.User, error) {
+	if err := ctx.Err(); err != nil {
+		return model.User{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.users[id]
+	if !ok || stored.DeletedAt == nil {
+		return model.User{}, mongo.ErrNoDocuments
+	}
+
+	stored.DeletedAt = nil
+	stored.UpdatedAt = now()
+	stored.Version++
+	m.remember(ctx, id)
+	m.users[id] = stored
+
+	return stored, nil
+}
+
+func (m *UserMemoryDB) PurgeUsers(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var purged int64
+	for id, user := range m.users {
+		if user.DeletedAt != nil && user.DeletedAt.Before(deletedBefore) {
+			m.remember(ctx, id)
+			delete(m.users, id)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+// GetUsersFromDB is GetUsers, the users are not cached
+func (m *UserMemoryDB) GetUsersFromDB(ctx context.Context, filter model.UserFilter, page model.PageRequest) (model.UserPage, error) {
+	return m.GetUsers(ctx, filter, page)
+}
+
+// GetUserByIDFromDB is GetUserByID, the users are not cached
+func (m *UserMemoryDB) GetUserByIDFromDB(ctx context.Context, id primitive.ObjectID) (model.User, error) {
+	return m.GetUserByID(ctx, id)
+}
+
+// InvalidateCache does nothing, the users are not cached
+func (m *UserMemoryDB) InvalidateCache(ctx context.Context, id primitive.ObjectID) error {
+	return nil
+}
@@ -0,0 +1,28 @@
+package memory
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	database "main.go/database/models"
+)
+
+// now returns the current time at the millisecond precision MongoDB stores
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
+
+// checkVersion mirrors the versioned writes of the MongoDB implementation: a
+// missing document is not found, and a non-zero expected version must match
+// the stored one
+func checkVersion(exists bool, stored int64, expected int64) error {
+	if !exists {
+		return mongo.ErrNoDocuments
+	}
+
+	if expected != 0 && stored != expected {
+		return database.ErrVersionMismatch
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+package mongodb
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"main.go/model"
+)
+
+// OutboxMongoDB keeps the outbox in a collection next to the entities, so
+// events can be written in their transactions. The leases of the relays are
+// kept in a collection of their own.
+type OutboxMongoDB struct {
+	db      *mongo.Collection
+	leases  *mongo.Collection
+	timeout time.Duration
+}
+
+func NewOutboxMongoDB(database *mongo.Database, timeout time.Duration) *OutboxMongoDB {
+	collection := database.Collection("outbox")
+	return &OutboxMongoDB{
+		db:      collection,
+		leases:  database.Collection("leases"),
+		timeout: timeout,
+	}
+}
+
+// EnsureIndexes creates the index the pending events are read in order from,
+// which also serves removing the delivered ones
+func (m *OutboxMongoDB) EnsureIndexes(ctx context.Context) error {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	index := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "delivered_at", Value: 1},
+			{Key: "created_at", Value: 1},
+			{Key: "_id", Value: 1},
+		},
+		Options: options.Index().SetName("outbox_pending"),
+	}
+
+	_, err := m.db.Indexes().CreateOne(ctx, index)
+	if err != nil {
+		return fmt.Errorf("failed to create outbox indexes: %v", err)
+	}
+
+	return nil
+}
+
+func (m *OutboxMongoDB) AddEvent(ctx context.Context, event model.OutboxEvent) error {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	event.ID = primitive.NewObjectID()
+	event.CreatedAt = now()
+	event.NextAttemptAt = event.CreatedAt
+	event.DeliveredAt = nil
+
+	_, err := m.db.InsertOne(ctx, event)
+	return err
+}
+
+func (m *OutboxMongoDB) GetPendingEvents(ctx context.Context, after *model.OutboxEvent, limit int) ([]model.OutboxEvent, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	// A null delivered_at also matches the events without one, using the index
+	query := bson.M{"delivered_at": nil}
+	if after != nil {
+		query["$or"] = bson.A{
+			bson.M{"created_at": bson.M{"$gt": after.CreatedAt}},
+			bson.M{"created_at": after.CreatedAt, "_id": bson.M{"$gt": after.ID}},
+		}
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}).
+		SetLimit(int64(limit))
+
+	cursor, err := m.db.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	events := []model.OutboxEvent{}
+	err = cursor.All(ctx, &events)
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+func (m *OutboxMongoDB) MarkDelivered(ctx context.Context, id primitive.ObjectID, deliveredAt time.Time) error {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	update := bson.M{"$set": bson.M{"delivered_at": deliveredAt}}
+	return m.updateEvent(ctx, id, update)
+}
+
+func (m *OutboxMongoDB) MarkFailed(ctx context.Context, id primitive.ObjectID, attempts int, nextAttemptAt time.Time, lastError string) error {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	update := bson.M{"$set": bson.M{
+		"attempts":        attempts,
+		"next_attempt_at": nextAttemptAt,
+		"last_error":      lastError,
+	}}
+	return m.updateEvent(ctx, id, update)
+}
+
+// updateEvent applies the update to the event, which must exist
+func (m *OutboxMongoDB) updateEvent(ctx context.Context, id primitive.ObjectID, update bson.M) error {
+	result, err := m.db.UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+func (m *OutboxMongoDB) DeleteDelivered(ctx context.Context, deliveredBefore time.Time) (int64, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	result, err := m.db.DeleteMany(ctx, bson.M{"delivered_at": bson.M{"$lt": deliveredBefore}})
+	if err != nil {
+		return 0, err
+	}
+
+	return result.DeletedCount, nil
+}
+
+func (m *OutboxMongoDB) AcquireLease(ctx context.Context, name string, owner string, ttl time.Duration) (bool, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	// Take the lease over when it is ours or expired. Otherwise the filter
+	// matches nothing and the upsert collides with the lease of the holder.
+	at := now()
+	filter := bson.M{
+		"_id": name,
+		"$or": bson.A{
+			bson.M{"owner": owner},
+			bson.M{"expires_at": bson.M{"$lte": at}},
+		},
+	}
+	update := bson.M{"$set": bson.M{"owner": owner, "expires_at": at.Add(ttl)}}
+
+	_, err := m.leases.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
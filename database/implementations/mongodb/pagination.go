@@ -0,0 +1,101 @@
+package mongodb
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"main.go/cache"
+	"main.go/model"
+)
+
+// pageQuery extends the filter with the cursor condition and builds the find
+// options (sort and limit) for the requested page. One extra document is
+// requested so the caller can tell whether another page follows.
+func pageQuery(filter bson.M, page model.PageRequest) (bson.M, *options.FindOptions, error) {
+	field, desc := page.SortField()
+
+	direction := 1
+	operator := "$gt"
+	if desc {
+		direction = -1
+		operator = "$lt"
+	}
+
+	if page.Cursor != "" {
+		cursor, err := model.DecodeCursor(page.Cursor)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var cursorFilter bson.M
+		if field == "_id" {
+			cursorFilter = bson.M{"_id": bson.M{operator: cursor.ID}}
+		} else {
+			// Resume after the last document, using the ID to break ties
+			cursorFilter = bson.M{"$or": []bson.M{
+				{field: bson.M{operator: cursor.Value}},
+				{field: cursor.Value, "_id": bson.M{operator: cursor.ID}},
+			}}
+		}
+
+		filter = bson.M{"$and": []bson.M{filter, cursorFilter}}
+	}
+
+	sort := bson.D{{Key: field, Value: direction}}
+	if field != "_id" {
+		sort = append(sort, bson.E{Key: "_id", Value: direction})
+	}
+
+	opts := options.Find().
+		SetSort(sort).
+		SetLimit(int64(page.PageLimit() + 1))
+
+	return filter, opts, nil
+}
+
+// nextCursor builds the cursor pointing after the given document
+func nextCursor(page model.PageRequest, id primitive.ObjectID, value interface{}) string {
+	field, _ := page.SortField()
+	if field == "_id" {
+		return model.EncodeCursor(model.Cursor{ID: id})
+	}
+	return model.EncodeCursor(model.Cursor{ID: id, Value: value})
+}
+
+// containsPattern builds a case-insensitive substring match
+func containsPattern(s string) primitive.Regex {
+	return primitive.Regex{Pattern: regexp.QuoteMeta(s), Options: "i"}
+}
+
+// listCacheKey builds the cache key of a listing. The key includes the current
+// generation of the collection so every cached page is invalidated at once
+// when the generation is bumped after a write.
+func listCacheKey(ctx context.Context, c cache.Cacher, prefix string, query ...interface{}) string {
+	var generation string
+	if err := c.Get(ctx, prefix+"generation", &generation); err != nil {
+		generation = "0"
+	}
+
+	hash := sha1.Sum([]byte(fmt.Sprintf("%+v", query)))
+	return fmt.Sprintf("%slist:%s:%s", prefix, generation, hex.EncodeToString(hash[:]))
+}
+
+// invalidateListCache bumps the generation of the collection's listings
+func invalidateListCache(ctx context.Context, c cache.Cacher, prefix string) error {
+	return c.Set(ctx, prefix+"generation", primitive.NewObjectID().Hex(), 0)
+}
+
+// withTimeout bounds the context by the configured per-operation timeout
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
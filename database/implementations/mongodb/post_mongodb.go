@@ -17,31 +17,36 @@ type PostMongoDB struct {
 	db          *mongo.Collection
 	cache       cache.Cacher
 	cachePrefix string
+	timeout     time.Duration
 }
 
-func NewPostMongoDB(database *mongo.Database, cache cache.Cacher) *PostMongoDB {
+func NewPostMongoDB(database *mongo.Database, cache cache.Cacher, timeout time.Duration) *PostMongoDB {
 	collection := database.Collection("posts")
 	return &PostMongoDB{
 		db:          collection,
 		cache:       cache,
 		cachePrefix: "post:",
+		timeout:     timeout,
 	}
 }
 
-func (m *PostMongoDB) GetPosts() ([]model.Post, error) {
-	var posts []model.Post
-	cacheKey := "posts"
+func (m *PostMongoDB) GetPosts(ctx context.Context, filter model.PostFilter, page model.PageRequest) (model.PostPage, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
 
-	err := m.cache.Get(cacheKey, &posts)
+	var posts model.PostPage
+	cacheKey := listCacheKey(ctx, m.cache, m.cachePrefix, filter, page)
+
+	err := m.cache.Get(ctx, cacheKey, &posts)
 	if err != nil {
 		// Cache miss, retrieve the posts from the repository
-		posts, err = m.getPostsFromDB()
+		posts, err = m.getPostsFromDB(ctx, filter, page)
 		if err != nil {
-			return nil, err
+			return model.PostPage{}, err
 		}
 
 		// Store the posts in the cache
-		err = m.cache.Set(cacheKey, posts, time.Hour)
+		err = m.cache.Set(ctx, cacheKey, posts, time.Hour)
 		if err != nil {
 			// Log the error, but don't affect the response
 			fmt.Printf("Failed to set posts in cache: %v\n", err)
@@ -51,45 +56,104 @@ func (m *PostMongoDB) GetPosts() ([]model.Post, error) {
 	return posts, nil
 }
 
-func (m *PostMongoDB) getPostsFromDB() ([]model.Post, error) {
-	var posts []model.Post
+// GetPostsFromDB lists the posts from the database, leaving the cache alone
+func (m *PostMongoDB) GetPostsFromDB(ctx context.Context, filter model.PostFilter, page model.PageRequest) (model.PostPage, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	return m.getPostsFromDB(ctx, filter, page)
+}
+
+func (m *PostMongoDB) getPostsFromDB(ctx context.Context, filter model.PostFilter, page model.PageRequest) (model.PostPage, error) {
+	posts := []model.Post{}
+
+	query, opts, err := pageQuery(postFilterQuery(filter), page)
+	if err != nil {
+		return model.PostPage{}, err
+	}
 
-	cursor, err := m.db.Find(context.Background(), bson.M{})
+	cursor, err := m.db.Find(ctx, query, opts)
 	if err != nil {
-		return nil, err
+		return model.PostPage{}, err
 	}
-	defer cursor.Close(context.Background())
+	defer cursor.Close(ctx)
 
-	for cursor.Next(context.Background()) {
+	for cursor.Next(ctx) {
 		var post model.Post
 		err := cursor.Decode(&post)
 		if err != nil {
-			return nil, err
+			return model.PostPage{}, err
 		}
 		posts = append(posts, post)
 	}
 
 	if err := cursor.Err(); err != nil {
-		return nil, err
+		return model.PostPage{}, err
 	}
 
-	return posts, nil
+	result := model.PostPage{Data: posts}
+
+	// A document beyond the limit means there is another page
+	if limit := page.PageLimit(); len(posts) > limit {
+		result.Data = posts[:limit]
+		last := result.Data[limit-1]
+		result.NextCursor = nextCursor(page, last.ID, postSortValue(last, page))
+	}
+
+	return result, nil
 }
 
-func (m *PostMongoDB) GetPostByID(id primitive.ObjectID) (model.Post, error) {
+// postFilterQuery translates the listing filters into a MongoDB filter
+func postFilterQuery(filter model.PostFilter) bson.M {
+	query := bson.M{"deleted_at": notDeleted}
+	if filter.Deleted {
+		query["deleted_at"] = inTrash
+	}
+
+	if !filter.AuthorID.IsZero() {
+		query["author_id"] = filter.AuthorID
+	}
+	if filter.Title != "" {
+		query["title"] = filter.Title
+	}
+	if filter.TitleContains != "" && filter.Title == "" {
+		query["title"] = containsPattern(filter.TitleContains)
+	}
+	if filter.BodyContains != "" {
+		query["body"] = containsPattern(filter.BodyContains)
+	}
+
+	return query
+}
+
+// postSortValue returns the value of the field the page is sorted by
+func postSortValue(post model.Post, page model.PageRequest) interface{} {
+	field, _ := page.SortField()
+	switch field {
+	case "title":
+		return post.Title
+	default:
+		return post.ID
+	}
+}
+
+func (m *PostMongoDB) GetPostByID(ctx context.Context, id primitive.ObjectID) (model.Post, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
 	var post model.Post
 	cacheKey := fmt.Sprintf("%s%s", m.cachePrefix, id.Hex())
 
-	err := m.cache.Get(cacheKey, &post)
+	err := m.cache.Get(ctx, cacheKey, &post)
 	if err != nil {
 		// Cache miss, retrieve the post from the repository
-		post, err = m.getPostByIDFromDB(id)
+		post, err = m.getPostByIDFromDB(ctx, id)
 		if err != nil {
 			return model.Post{}, err
 		}
 
 		// Store the post in the cache
-		err = m.cache.Set(cacheKey, post, time.Hour)
+		err = m.cache.Set(ctx, cacheKey, post, time.Hour)
 		if err != nil {
 			// Log the error, but don't affect the response
 			fmt.Printf("Failed to set post in cache: %v\n", err)
@@ -99,11 +163,19 @@ func (m *PostMongoDB) GetPostByID(id primitive.ObjectID) (model.Post, error) {
 	return post, nil
 }
 
-func (m *PostMongoDB) getPostByIDFromDB(id primitive.ObjectID) (model.Post, error) {
+// GetPostByIDFromDB reads the post from the database, leaving the cache alone
+func (m *PostMongoDB) GetPostByIDFromDB(ctx context.Context, id primitive.ObjectID) (model.Post, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	return m.getPostByIDFromDB(ctx, id)
+}
+
+func (m *PostMongoDB) getPostByIDFromDB(ctx context.Context, id primitive.ObjectID) (model.Post, error) {
 	var post model.Post
-	filter := bson.M{"_id": id}
+	filter := bson.M{"_id": id, "deleted_at": notDeleted}
 
-	err := m.db.FindOne(context.Background(), filter).Decode(&post)
+	err := m.db.FindOne(ctx, filter).Decode(&post)
 	if err != nil {
 		return model.Post{}, err
 	}
@@ -111,12 +183,15 @@ func (m *PostMongoDB) getPostByIDFromDB(id primitive.ObjectID) (model.Post, erro
 	return post, nil
 }
 
-func (m *PostMongoDB) GetLatestInsertedPost() (model.Post, error) {
+func (m *PostMongoDB) GetLatestInsertedPost(ctx context.Context) (model.Post, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
 	// Sort the posts by insertion time in descending order
 	opts := options.FindOne().SetSort(bson.M{"_id": -1})
 
 	var post model.Post
-	err := m.db.FindOne(context.Background(), bson.M{}, opts).Decode(&post)
+	err := m.db.FindOne(ctx, bson.M{"deleted_at": notDeleted}, opts).Decode(&post)
 	if err != nil {
 		return model.Post{}, err
 	}
@@ -124,24 +199,29 @@ func (m *PostMongoDB) GetLatestInsertedPost() (model.Post, error) {
 	return post, nil
 }
 
-func (m *PostMongoDB) AddPost(post model.Post) (model.Post, error) {
-	addedPost, err := m.addPostToDB(post)
-	if err != nil {
-		return model.Post{}, err
-	}
+func (m *PostMongoDB) AddPost(ctx context.Context, post model.Post) (model.Post, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
 
-	// Clear the posts cache
-	err = m.cache.Delete("posts")
+	addedPost, err := m.addPostToDB(ctx, post)
 	if err != nil {
-		// Log the error, but don't affect the response
-		fmt.Printf("Failed to delete posts cache: %v\n", err)
+		return model.Post{}, err
 	}
 
 	return addedPost, nil
 }
 
-func (m *PostMongoDB) addPostToDB(post model.Post) (model.Post, error) {
-	_, err := m.db.InsertOne(context.Background(), post)
+func (m *PostMongoDB) addPostToDB(ctx context.Context, post model.Post) (model.Post, error) {
+	// Assign the ID up front so the caller gets back the stored document
+	if post.ID.IsZero() {
+		post.ID = primitive.NewObjectID()
+	}
+	post.Version = 1
+	post.CreatedAt = now()
+	post.UpdatedAt = post.CreatedAt
+	post.DeletedAt = nil
+
+	_, err := m.db.InsertOne(ctx, post)
 	if err != nil {
 		return model.Post{}, err
 	}
@@ -149,53 +229,75 @@ func (m *PostMongoDB) addPostToDB(post model.Post) (model.Post, error) {
 	return post, nil
 }
 
-func (m *PostMongoDB) UpdatePost(post model.Post) (model.Post, error) {
-	filter := bson.M{"_id": post.ID}
+func (m *PostMongoDB) UpdatePost(ctx context.Context, post model.Post) (model.Post, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
 
-	update := bson.M{
-		"$set": bson.M{
-			"title": post.Title,
-			"body":  post.Body,
-		},
+	set := bson.M{
+		"title": post.Title,
+		"body":  post.Body,
+	}
+	update := bson.M{"$set": set}
+	if len(post.Tags) > 0 {
+		set["tags"] = post.Tags
+	} else {
+		update["$unset"] = bson.M{"tags": ""}
 	}
 
-	// Clear the post cache
-	cacheKey := fmt.Sprintf("%s%s", m.cachePrefix, post.ID.Hex())
-	err := m.cache.Delete(cacheKey)
+	var updated model.Post
+	err := updateVersioned(ctx, m.db, post.ID, post.Version, update, &updated)
 	if err != nil {
-		// Log the error, but don't affect the response
-		fmt.Printf("Failed to delete post cache: %v\n", err)
+		return model.Post{}, err
 	}
 
-	_, err = m.db.UpdateOne(context.Background(), filter, update)
+	return updated, nil
+}
+
+func (m *PostMongoDB) PatchPost(ctx context.Context, post model.Post, fields []string) (model.Post, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	update, err := fieldUpdate(post, fields)
 	if err != nil {
 		return model.Post{}, err
 	}
 
-	return post, nil
+	var updated model.Post
+	err = updateVersioned(ctx, m.db, post.ID, post.Version, update, &updated)
+	if err != nil {
+		return model.Post{}, err
+	}
+
+	return updated, nil
 }
 
-func (m *PostMongoDB) PatchPost(post model.Post) (model.Post, error) {
-	filter := bson.M{"_id": post.ID}
+func (m *PostMongoDB) DeletePost(ctx context.Context, id primitive.ObjectID, version int64) error {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
 
-	update := bson.M{}
+	return softDelete(ctx, m.db, id, version)
+}
 
-	if post.Title != "" {
-		update["$set"] = bson.M{"title": post.Title}
-	}
-	if post.Body != "" {
-		update["$set"] = bson.M{"body": post.Body}
-	}
+// GetDeletedPostByID reads a post in the trash straight from the database
+func (m *PostMongoDB) GetDeletedPostByID(ctx context.Context, id primitive.ObjectID) (model.Post, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
 
-	// Clear the post cache
-	cacheKey := fmt.Sprintf("%s%s", m.cachePrefix, post.ID.Hex())
-	err := m.cache.Delete(cacheKey)
+	var post model.Post
+	err := findDeleted(ctx, m.db, id, &post)
 	if err != nil {
-		// Log the error, but don't affect the response
-		fmt.Printf("Failed to delete post cache: %v\n", err)
+		return model.Post{}, err
 	}
 
-	_, err = m.db.UpdateOne(context.Background(), filter, update)
+	return post, nil
+}
+
+func (m *PostMongoDB) RestorePost(ctx context.Context, id primitive.ObjectID) (model.Post, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	var post model.Post
+	err := restoreDeleted(ctx, m.db, id, &post)
 	if err != nil {
 		return model.Post{}, err
 	}
@@ -203,21 +305,61 @@ func (m *PostMongoDB) PatchPost(post model.Post) (model.Post, error) {
 	return post, nil
 }
 
-func (m *PostMongoDB) DeletePost(id primitive.ObjectID) error {
-	filter := bson.M{"_id": id}
+func (m *PostMongoDB) PurgePosts(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
 
-	// Clear the post cache
-	cacheKey := fmt.Sprintf("%s%s", m.cachePrefix, id.Hex())
-	err := m.cache.Delete(cacheKey)
+	purged, err := purgeDeleted(ctx, m.db, deletedBefore)
 	if err != nil {
-		// Log the error, but don't affect the response
-		fmt.Printf("Failed to delete post cache: %v\n", err)
+		return 0, err
+	}
+
+	// Clear the cached listings of the trash, as purging publishes no events
+	// for the worker to do so
+	if purged > 0 {
+		err = invalidateListCache(ctx, m.cache, m.cachePrefix)
+		if err != nil {
+			// Log the error, but don't affect the response
+			fmt.Printf("Failed to invalidate posts cache: %v\n", err)
+		}
+	}
+
+	return purged, nil
+}
+
+func (m *PostMongoDB) ReassignPosts(ctx context.Context, from primitive.ObjectID, to primitive.ObjectID) error {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	filter := bson.M{"author_id": from}
+
+	update := bson.M{
+		"$set": bson.M{"author_id": to, "updated_at": now()},
+		"$inc": bson.M{"version": 1},
 	}
+	if to.IsZero() {
+		update = bson.M{
+			"$set":   bson.M{"updated_at": now()},
+			"$unset": bson.M{"author_id": ""},
+			"$inc":   bson.M{"version": 1},
+		}
+	}
+
+	_, err := m.db.UpdateMany(ctx, filter, update)
+	return err
+}
+
+// InvalidateCache drops the cached post and post listings. The writes leave
+// the cache alone: the worker calls this once their events arrived, after
+// they were committed, and retries when it fails.
+func (m *PostMongoDB) InvalidateCache(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
 
-	_, err = m.db.DeleteOne(context.Background(), filter)
+	err := m.cache.Delete(ctx, fmt.Sprintf("%s%s", m.cachePrefix, id.Hex()))
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return invalidateListCache(ctx, m.cache, m.cachePrefix)
 }
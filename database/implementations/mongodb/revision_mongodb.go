@@ -0,0 +1,91 @@
+package mongodb
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"main.go/model"
+)
+
+// PostRevisionMongoDB keeps the post revisions in a collection of their own.
+// Revisions never change, so they are not cached.
+type PostRevisionMongoDB struct {
+	db      *mongo.Collection
+	timeout time.Duration
+}
+
+func NewPostRevisionMongoDB(database *mongo.Database, timeout time.Duration) *PostRevisionMongoDB {
+	collection := database.Collection("post_revisions")
+	return &PostRevisionMongoDB{
+		db:      collection,
+		timeout: timeout,
+	}
+}
+
+// postRevisionIndex is the name of the unique index on the post and revision number
+const postRevisionIndex = "post_revision_unique"
+
+// EnsureIndexes creates the unique index on the post and revision number,
+// which also serves the lookups of a post's revisions
+func (m *PostRevisionMongoDB) EnsureIndexes(ctx context.Context) error {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: "post_id", Value: 1}, {Key: "number", Value: 1}},
+		Options: options.Index().SetName(postRevisionIndex).SetUnique(true),
+	}
+
+	_, err := m.db.Indexes().CreateOne(ctx, index)
+	if err != nil {
+		return fmt.Errorf("failed to create post revision indexes: %v", err)
+	}
+
+	return nil
+}
+
+func (m *PostRevisionMongoDB) AddPostRevision(ctx context.Context, revision model.PostRevision) error {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	_, err := m.db.InsertOne(ctx, revision)
+	return err
+}
+
+func (m *PostRevisionMongoDB) GetPostRevisions(ctx context.Context, postID primitive.ObjectID) ([]model.PostRevision, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "number", Value: 1}})
+	cursor, err := m.db.Find(ctx, bson.M{"post_id": postID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	revisions := []model.PostRevision{}
+	err = cursor.All(ctx, &revisions)
+	if err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+func (m *PostRevisionMongoDB) GetPostRevision(ctx context.Context, postID primitive.ObjectID, number int64) (model.PostRevision, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	var revision model.PostRevision
+	err := m.db.FindOne(ctx, bson.M{"post_id": postID, "number": number}).Decode(&revision)
+	if err != nil {
+		return model.PostRevision{}, err
+	}
+
+	return revision, nil
+}
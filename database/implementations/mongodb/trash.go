@@ -0,0 +1,54 @@
+package mongodb
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Conditions on deleted_at selecting the documents outside and inside the trash
+var (
+	notDeleted = bson.M{"$exists": false}
+	inTrash    = bson.M{"$exists": true}
+)
+
+// softDelete moves the document to the trash if it still has the version
+func softDelete(ctx context.Context, collection *mongo.Collection, id primitive.ObjectID, version int64) error {
+	update := bson.M{"$set": bson.M{"deleted_at": now()}}
+
+	var deleted bson.M
+	return updateVersioned(ctx, collection, id, version, update, &deleted)
+}
+
+// findDeleted decodes the document with the ID into result if it is in the trash
+func findDeleted(ctx context.Context, collection *mongo.Collection, id primitive.ObjectID, result interface{}) error {
+	return collection.FindOne(ctx, bson.M{"_id": id, "deleted_at": inTrash}).Decode(result)
+}
+
+// restoreDeleted takes the document out of the trash and decodes the restored
+// document into result
+func restoreDeleted(ctx context.Context, collection *mongo.Collection, id primitive.ObjectID, result interface{}) error {
+	filter := bson.M{"_id": id, "deleted_at": inTrash}
+	update := bson.M{
+		"$unset": bson.M{"deleted_at": ""},
+		"$set":   bson.M{"updated_at": now()},
+		"$inc":   bson.M{"version": 1},
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	return collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(result)
+}
+
+// purgeDeleted permanently removes the documents deleted before the given time
+func purgeDeleted(ctx context.Context, collection *mongo.Collection, deletedBefore time.Time) (int64, error) {
+	result, err := collection.DeleteMany(ctx, bson.M{"deleted_at": bson.M{"$lt": deletedBefore}})
+	if err != nil {
+		return 0, err
+	}
+
+	return result.DeletedCount, nil
+}
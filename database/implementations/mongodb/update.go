@@ -0,0 +1,38 @@
+package mongodb
+
+import "go.mongodb.org/mongo-driver/bson"
+
+// fieldUpdate builds an update that sets the named fields of doc to their
+// values, removing those that are empty, so several fields change at once
+func fieldUpdate(doc interface{}, fields []string) (bson.M, error) {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var values bson.M
+	if err := bson.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+
+	set := bson.M{}
+	unset := bson.M{}
+	for _, field := range fields {
+		value, ok := values[field]
+		if !ok || value == "" {
+			unset[field] = ""
+		} else {
+			set[field] = value
+		}
+	}
+
+	update := bson.M{}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	return update, nil
+}
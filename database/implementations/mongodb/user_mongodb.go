@@ -17,31 +17,108 @@ type UserMongoDB struct {
 	db          *mongo.Collection
 	cache       cache.Cacher
 	cachePrefix string
+	timeout     time.Duration
 }
 
-func NewUserMongoDB(database *mongo.Database, cache cache.Cacher) *UserMongoDB {
+func NewUserMongoDB(database *mongo.Database, cache cache.Cacher, timeout time.Duration) *UserMongoDB {
 	collection := database.Collection("users")
 	return &UserMongoDB{
 		db:          collection,
 		cache:       cache,
 		cachePrefix: "user:",
+		timeout:     timeout,
 	}
 }
 
-func (m *UserMongoDB) GetUsers() ([]model.User, error) {
-	var users []model.User
-	cacheKey := "users"
+// Names of the unique indexes, which show up in duplicate key errors
+const (
+	usernameIndex = "username_unique"
+	emailIndex    = "email_unique"
+)
+
+// caseInsensitive compares usernames and email addresses regardless of case.
+// Lookups and listing filters by username or email use it too, so they match
+// the index.
+var caseInsensitive = &options.Collation{Locale: "en", Strength: 2}
+
+// EnsureIndexes creates the unique indexes on username and email, which
+// ignore case. Email addresses are stored lowercased as well, the collation
+// covers those stored before. Users without an email address are left out of
+// the email index.
+func (m *UserMongoDB) EnsureIndexes(ctx context.Context) error {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	if err := m.dropCaseSensitiveIndexes(ctx); err != nil {
+		return err
+	}
+
+	indexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "username", Value: 1}},
+			Options: options.Index().SetName(usernameIndex).SetUnique(true).SetCollation(caseInsensitive),
+		},
+		{
+			Keys: bson.D{{Key: "email", Value: 1}},
+			Options: options.Index().SetName(emailIndex).SetUnique(true).SetCollation(caseInsensitive).
+				SetPartialFilterExpression(bson.M{"email": bson.M{"$gt": ""}}),
+		},
+	}
+
+	_, err := m.db.Indexes().CreateMany(ctx, indexes)
+	if err != nil {
+		return fmt.Errorf("failed to create user indexes: %v", err)
+	}
 
-	err := m.cache.Get(cacheKey, &users)
+	return nil
+}
+
+// dropCaseSensitiveIndexes drops the unique indexes created before they
+// ignored case, which the indexes with the same names cannot replace in place
+func (m *UserMongoDB) dropCaseSensitiveIndexes(ctx context.Context) error {
+	cursor, err := m.db.Indexes().List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list user indexes: %v", err)
+	}
+
+	var indexes []bson.M
+	if err := cursor.All(ctx, &indexes); err != nil {
+		return fmt.Errorf("failed to list user indexes: %v", err)
+	}
+
+	for _, index := range indexes {
+		name, _ := index["name"].(string)
+		if name != usernameIndex && name != emailIndex {
+			continue
+		}
+		if _, ok := index["collation"]; ok {
+			continue
+		}
+		if _, err := m.db.Indexes().DropOne(ctx, name); err != nil {
+			return fmt.Errorf("failed to drop the case-sensitive index %s: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+func (m *UserMongoDB) GetUsers(ctx context.Context, filter model.UserFilter, page model.PageRequest) (model.UserPage, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	var users model.UserPage
+	cacheKey := listCacheKey(ctx, m.cache, m.cachePrefix, filter, page)
+
+	err := m.cache.Get(ctx, cacheKey, &users)
 	if err != nil {
 		// Cache miss, retrieve the users from the repository
-		users, err = m.getUsersFromDB()
+		users, err = m.getUsersFromDB(ctx, filter, page)
 		if err != nil {
-			return nil, err
+			return model.UserPage{}, err
 		}
 
 		// Store the users in the cache
-		err = m.cache.Set(cacheKey, users, time.Hour)
+		err = m.cache.Set(ctx, cacheKey, users, time.Hour)
 		if err != nil {
 			// Log the error, but don't affect the response
 			fmt.Printf("Failed to set users in cache: %v\n", err)
@@ -51,45 +128,109 @@ func (m *UserMongoDB) GetUsers() ([]model.User, error) {
 	return users, nil
 }
 
-func (m *UserMongoDB) getUsersFromDB() ([]model.User, error) {
-	var users []model.User
+// GetUsersFromDB lists the users from the database, leaving the cache alone
+func (m *UserMongoDB) GetUsersFromDB(ctx context.Context, filter model.UserFilter, page model.PageRequest) (model.UserPage, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	return m.getUsersFromDB(ctx, filter, page)
+}
 
-	cursor, err := m.db.Find(context.Background(), bson.M{})
+func (m *UserMongoDB) getUsersFromDB(ctx context.Context, filter model.UserFilter, page model.PageRequest) (model.UserPage, error) {
+	users := []model.User{}
+
+	query, opts, err := pageQuery(userFilterQuery(filter), page)
+	if err != nil {
+		return model.UserPage{}, err
+	}
+	if filter.UserName != "" || filter.Email != "" {
+		// Match the names and addresses the way the unique indexes compare them
+		opts.SetCollation(caseInsensitive)
+	}
+
+	cursor, err := m.db.Find(ctx, query, opts)
 	if err != nil {
-		return nil, err
+		return model.UserPage{}, err
 	}
-	defer cursor.Close(context.Background())
+	defer cursor.Close(ctx)
 
-	for cursor.Next(context.Background()) {
+	for cursor.Next(ctx) {
 		var user model.User
 		err := cursor.Decode(&user)
 		if err != nil {
-			return nil, err
+			return model.UserPage{}, err
 		}
 		users = append(users, user)
 	}
 
 	if err := cursor.Err(); err != nil {
-		return nil, err
+		return model.UserPage{}, err
 	}
 
-	return users, nil
+	result := model.UserPage{Data: users}
+
+	// A document beyond the limit means there is another page
+	if limit := page.PageLimit(); len(users) > limit {
+		result.Data = users[:limit]
+		last := result.Data[limit-1]
+		result.NextCursor = nextCursor(page, last.ID, userSortValue(last, page))
+	}
+
+	return result, nil
+}
+
+// userFilterQuery translates the listing filters into a MongoDB filter
+func userFilterQuery(filter model.UserFilter) bson.M {
+	query := bson.M{"deleted_at": notDeleted}
+	if filter.Deleted {
+		query["deleted_at"] = inTrash
+	}
+
+	if filter.UserName != "" {
+		query["username"] = filter.UserName
+	}
+	if filter.Email != "" {
+		query["email"] = filter.Email
+	}
+	if filter.FullNameContains != "" {
+		query["fullname"] = containsPattern(filter.FullNameContains)
+	}
+
+	return query
+}
+
+// userSortValue returns the value of the field the page is sorted by
+func userSortValue(user model.User, page model.PageRequest) interface{} {
+	field, _ := page.SortField()
+	switch field {
+	case "username":
+		return user.UserName
+	case "fullname":
+		return user.FullName
+	case "email":
+		return user.Email
+	default:
+		return user.ID
+	}
 }
 
-func (m *UserMongoDB) GetUserByID(id primitive.ObjectID) (model.User, error) {
+func (m *UserMongoDB) GetUserByID(ctx context.Context, id primitive.ObjectID) (model.User, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
 	var user model.User
 	cacheKey := fmt.Sprintf("%s%s", m.cachePrefix, id.Hex())
 
-	err := m.cache.Get(cacheKey, &user)
+	err := m.cache.Get(ctx, cacheKey, &user)
 	if err != nil {
 		// Cache miss, retrieve the user from the repository
-		user, err = m.getUserByIDFromDB(id)
+		user, err = m.getUserByIDFromDB(ctx, id)
 		if err != nil {
 			return model.User{}, err
 		}
 
 		// Store the user in the cache
-		err = m.cache.Set(cacheKey, user, time.Hour)
+		err = m.cache.Set(ctx, cacheKey, user, time.Hour)
 		if err != nil {
 			// Log the error, but don't affect the response
 			fmt.Printf("Failed to set user in cache: %v\n", err)
@@ -99,11 +240,19 @@ func (m *UserMongoDB) GetUserByID(id primitive.ObjectID) (model.User, error) {
 	return user, nil
 }
 
-func (m *UserMongoDB) getUserByIDFromDB(id primitive.ObjectID) (model.User, error) {
+// GetUserByIDFromDB reads the user from the database, leaving the cache alone
+func (m *UserMongoDB) GetUserByIDFromDB(ctx context.Context, id primitive.ObjectID) (model.User, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	return m.getUserByIDFromDB(ctx, id)
+}
+
+func (m *UserMongoDB) getUserByIDFromDB(ctx context.Context, id primitive.ObjectID) (model.User, error) {
 	var user model.User
-	filter := bson.M{"_id": id}
+	filter := bson.M{"_id": id, "deleted_at": notDeleted}
 
-	err := m.db.FindOne(context.Background(), filter).Decode(&user)
+	err := m.db.FindOne(ctx, filter).Decode(&user)
 	if err != nil {
 		return model.User{}, err
 	}
@@ -111,12 +260,17 @@ func (m *UserMongoDB) getUserByIDFromDB(id primitive.ObjectID) (model.User, erro
 	return user, nil
 }
 
-func (m *UserMongoDB) GetLatestInsertedUser() (model.User, error) {
-	// Sort the users by insertion time in descending order
-	opts := options.FindOne().SetSort(bson.M{"_id": -1})
+// GetUserByUserName reads the user straight from the database, bypassing the
+// cache, because the cached copy does not carry the password hash
+func (m *UserMongoDB) GetUserByUserName(ctx context.Context, username string) (model.User, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
 
 	var user model.User
-	err := m.db.FindOne(context.Background(), bson.M{}, opts).Decode(&user)
+	filter := bson.M{"username": username, "deleted_at": notDeleted}
+	opts := options.FindOne().SetCollation(caseInsensitive)
+
+	err := m.db.FindOne(ctx, filter, opts).Decode(&user)
 	if err != nil {
 		return model.User{}, err
 	}
@@ -124,24 +278,45 @@ func (m *UserMongoDB) GetLatestInsertedUser() (model.User, error) {
 	return user, nil
 }
 
-func (m *UserMongoDB) AddUser(user model.User) (model.User, error) {
-	addedUser, err := m.addUserToDB(user)
+func (m *UserMongoDB) GetLatestInsertedUser(ctx context.Context) (model.User, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	// Sort the users by insertion time in descending order
+	opts := options.FindOne().SetSort(bson.M{"_id": -1})
+
+	var user model.User
+	err := m.db.FindOne(ctx, bson.M{"deleted_at": notDeleted}, opts).Decode(&user)
 	if err != nil {
 		return model.User{}, err
 	}
 
-	// Clear the users cache
-	err = m.cache.Delete("users")
+	return user, nil
+}
+
+func (m *UserMongoDB) AddUser(ctx context.Context, user model.User) (model.User, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	addedUser, err := m.addUserToDB(ctx, user)
 	if err != nil {
-		// Log the error, but don't affect the response
-		fmt.Printf("Failed to delete users cache: %v\n", err)
+		return model.User{}, err
 	}
 
 	return addedUser, nil
 }
 
-func (m *UserMongoDB) addUserToDB(user model.User) (model.User, error) {
-	_, err := m.db.InsertOne(context.Background(), user)
+func (m *UserMongoDB) addUserToDB(ctx context.Context, user model.User) (model.User, error) {
+	// Assign the ID up front so the caller gets back the stored document
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+	user.Version = 1
+	user.CreatedAt = now()
+	user.UpdatedAt = user.CreatedAt
+	user.DeletedAt = nil
+
+	_, err := m.db.InsertOne(ctx, user)
 	if err != nil {
 		return model.User{}, err
 	}
@@ -149,8 +324,9 @@ func (m *UserMongoDB) addUserToDB(user model.User) (model.User, error) {
 	return user, nil
 }
 
-func (m *UserMongoDB) UpdateUser(user model.User) (model.User, error) {
-	filter := bson.M{"_id": user.ID}
+func (m *UserMongoDB) UpdateUser(ctx context.Context, user model.User) (model.User, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
 
 	update := bson.M{
 		"$set": bson.M{
@@ -160,46 +336,60 @@ func (m *UserMongoDB) UpdateUser(user model.User) (model.User, error) {
 		},
 	}
 
-	// Clear the user cache
-	cacheKey := fmt.Sprintf("%s%s", m.cachePrefix, user.ID.Hex())
-	err := m.cache.Delete(cacheKey)
+	var updated model.User
+	err := updateVersioned(ctx, m.db, user.ID, user.Version, update, &updated)
 	if err != nil {
-		// Log the error, but don't affect the response
-		fmt.Printf("Failed to delete user cache: %v\n", err)
+		return model.User{}, err
 	}
 
-	_, err = m.db.UpdateOne(context.Background(), filter, update)
+	return updated, nil
+}
+
+func (m *UserMongoDB) PatchUser(ctx context.Context, user model.User, fields []string) (model.User, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	update, err := fieldUpdate(user, fields)
 	if err != nil {
 		return model.User{}, err
 	}
 
-	return user, nil
+	var updated model.User
+	err = updateVersioned(ctx, m.db, user.ID, user.Version, update, &updated)
+	if err != nil {
+		return model.User{}, err
+	}
+
+	return updated, nil
 }
 
-func (m *UserMongoDB) PatchUser(user model.User) (model.User, error) {
-	filter := bson.M{"_id": user.ID}
+func (m *UserMongoDB) DeleteUser(ctx context.Context, id primitive.ObjectID, version int64) error {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
 
-	update := bson.M{}
+	return softDelete(ctx, m.db, id, version)
+}
 
-	if user.FullName != "" {
-		update["$set"] = bson.M{"fullname": user.FullName}
-	}
-	if user.UserName != "" {
-		update["$set"] = bson.M{"username": user.UserName}
-	}
-	if user.Email != "" {
-		update["$set"] = bson.M{"email": user.Email}
-	}
+// GetDeletedUserByID reads a user in the trash straight from the database
+func (m *UserMongoDB) GetDeletedUserByID(ctx context.Context, id primitive.ObjectID) (model.User, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
 
-	// Clear the user cache
-	cacheKey := fmt.Sprintf("%s%s", m.cachePrefix, user.ID.Hex())
-	err := m.cache.Delete(cacheKey)
+	var user model.User
+	err := findDeleted(ctx, m.db, id, &user)
 	if err != nil {
-		// Log the error, but don't affect the response
-		fmt.Printf("Failed to delete user cache: %v\n", err)
+		return model.User{}, err
 	}
 
-	_, err = m.db.UpdateOne(context.Background(), filter, update)
+	return user, nil
+}
+
+func (m *UserMongoDB) RestoreUser(ctx context.Context, id primitive.ObjectID) (model.User, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	var user model.User
+	err := restoreDeleted(ctx, m.db, id, &user)
 	if err != nil {
 		return model.User{}, err
 	}
@@ -207,21 +397,39 @@ func (m *UserMongoDB) PatchUser(user model.User) (model.User, error) {
 	return user, nil
 }
 
-func (m *UserMongoDB) DeleteUser(id primitive.ObjectID) error {
-	filter := bson.M{"_id": id}
+func (m *UserMongoDB) PurgeUsers(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
 
-	// Clear the user cache
-	cacheKey := fmt.Sprintf("%s%s", m.cachePrefix, id.Hex())
-	err := m.cache.Delete(cacheKey)
+	purged, err := purgeDeleted(ctx, m.db, deletedBefore)
 	if err != nil {
-		// Log the error, but don't affect the response
-		fmt.Printf("Failed to delete user cache: %v\n", err)
+		return 0, err
+	}
+
+	// Clear the cached listings of the trash, as purging publishes no events
+	// for the worker to do so
+	if purged > 0 {
+		err = invalidateListCache(ctx, m.cache, m.cachePrefix)
+		if err != nil {
+			// Log the error, but don't affect the response
+			fmt.Printf("Failed to invalidate users cache: %v\n", err)
+		}
 	}
 
-	_, err = m.db.DeleteOne(context.Background(), filter)
+	return purged, nil
+}
+
+// InvalidateCache drops the cached user and user listings. The writes leave
+// the cache alone: the worker calls this once their events arrived, after
+// they were committed, and retries when it fails.
+func (m *UserMongoDB) InvalidateCache(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	err := m.cache.Delete(ctx, fmt.Sprintf("%s%s", m.cachePrefix, id.Hex()))
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return invalidateListCache(ctx, m.cache, m.cachePrefix)
 }
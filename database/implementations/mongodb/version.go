@@ -0,0 +1,65 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	database "main.go/database/models"
+)
+
+// now returns the current time at the millisecond precision MongoDB stores
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
+
+// versionFilter matches the document with the ID unless it is in the trash
+// and, unless the version is zero, only while it still has that version
+func versionFilter(id primitive.ObjectID, version int64) bson.M {
+	filter := bson.M{"_id": id, "deleted_at": notDeleted}
+	if version != 0 {
+		filter["version"] = version
+	}
+	return filter
+}
+
+// updateVersioned applies the update, stamps the update time and increments
+// the version in a single atomic operation, decoding the updated document into
+// result. The version check is part of the update filter, so concurrent
+// writers cannot interleave.
+func updateVersioned(ctx context.Context, collection *mongo.Collection, id primitive.ObjectID, version int64, update bson.M, result interface{}) error {
+	set, ok := update["$set"].(bson.M)
+	if !ok {
+		set = bson.M{}
+		update["$set"] = set
+	}
+	set["updated_at"] = now()
+	update["$inc"] = bson.M{"version": 1}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := collection.FindOneAndUpdate(ctx, versionFilter(id, version), update, opts).Decode(result)
+	if errors.Is(err, mongo.ErrNoDocuments) && version != 0 {
+		return versionMismatch(ctx, collection, id, err)
+	}
+
+	return err
+}
+
+// versionMismatch tells a stale version apart from a missing document after a
+// versioned write matched nothing, returning notFound in the latter case
+func versionMismatch(ctx context.Context, collection *mongo.Collection, id primitive.ObjectID, notFound error) error {
+	count, err := collection.CountDocuments(ctx, bson.M{"_id": id, "deleted_at": notDeleted})
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return notFound
+	}
+
+	return database.ErrVersionMismatch
+}
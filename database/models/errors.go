@@ -0,0 +1,7 @@
+package database
+
+import "errors"
+
+// ErrVersionMismatch is returned by updates and deletes that expect a version
+// other than the stored one, meaning somebody else changed the document first
+var ErrVersionMismatch = errors.New("version mismatch")
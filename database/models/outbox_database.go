@@ -0,0 +1,39 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"main.go/model"
+)
+
+// OutboxDatabase represents the database operations for the outbox of
+// events. Events are added within the transaction of the change they
+// announce and marked as delivered once they were published.
+type OutboxDatabase interface {
+	// AddEvent stores the event, assigning its ID and creation time
+	AddEvent(ctx context.Context, event model.OutboxEvent) error
+	// GetPendingEvents returns the undelivered events in the order they were
+	// added, starting after the given event, or with the first when it is nil
+	GetPendingEvents(ctx context.Context, after *model.OutboxEvent, limit int) ([]model.OutboxEvent, error)
+	MarkDelivered(ctx context.Context, id primitive.ObjectID, deliveredAt time.Time) error
+	// MarkFailed records a failed attempt and when to try the event again
+	MarkFailed(ctx context.Context, id primitive.ObjectID, attempts int, nextAttemptAt time.Time, lastError string) error
+	// DeleteDelivered removes the events delivered before the given time and
+	// returns how many there were
+	DeleteDelivered(ctx context.Context, deliveredBefore time.Time) (int64, error)
+	// AcquireLease makes the owner the holder of the named lease for the ttl,
+	// unless another owner holds it, and reports whether it does. The holder
+	// renews the lease by acquiring it again.
+	AcquireLease(ctx context.Context, name string, owner string, ttl time.Duration) (bool, error)
+}
+
+// Transactor runs functions in a database transaction
+type Transactor interface {
+	// WithTransaction runs fn in a transaction, committing it when fn returns
+	// nil and aborting it otherwise. The database calls made with the context
+	// passed to fn are part of the transaction; fn may run more than once when
+	// the transaction is retried. Nested calls join the outer transaction.
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
@@ -1,17 +1,46 @@
 package database
 
 import (
+	"context"
+	"time"
+
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"main.go/model"
 )
 
-// PostDatabase represents the database operations for posts
+// PostDatabase represents the database operations for posts.
+//
+// Updates and deletes only apply while the stored version equals the Version
+// of the post, or the given version, and fail with ErrVersionMismatch otherwise.
+// A zero version applies them unconditionally. Every change increments the version.
+//
+// Deleting a post moves it to the trash, from where it can be restored until
+// it is purged. Posts in the trash are invisible to every other method.
 type PostDatabase interface {
-	GetPosts() ([]model.Post, error)
-	GetPostByID(id primitive.ObjectID) (model.Post, error)
-	GetLatestInsertedPost() (model.Post, error)
-	AddPost(post model.Post) (model.Post, error)
-	UpdatePost(post model.Post) (model.Post, error)
-	PatchPost(post model.Post) (model.Post, error)
-	DeletePost(id primitive.ObjectID) error
+	GetPosts(ctx context.Context, filter model.PostFilter, page model.PageRequest) (model.PostPage, error)
+	GetPostByID(ctx context.Context, id primitive.ObjectID) (model.Post, error)
+	// GetPostsFromDB lists the posts from the database, bypassing the cache, for
+	// walks over every post that must see the committed state
+	GetPostsFromDB(ctx context.Context, filter model.PostFilter, page model.PageRequest) (model.PostPage, error)
+	// GetPostByIDFromDB reads the post from the database, bypassing the cache,
+	// for changes and checks that must see the latest committed version
+	GetPostByIDFromDB(ctx context.Context, id primitive.ObjectID) (model.Post, error)
+	GetLatestInsertedPost(ctx context.Context) (model.Post, error)
+	AddPost(ctx context.Context, post model.Post) (model.Post, error)
+	UpdatePost(ctx context.Context, post model.Post) (model.Post, error)
+	// PatchPost sets the named fields to their values in post in one update,
+	// removing the ones left empty, and returns the stored post
+	PatchPost(ctx context.Context, post model.Post, fields []string) (model.Post, error)
+	DeletePost(ctx context.Context, id primitive.ObjectID, version int64) error
+	GetDeletedPostByID(ctx context.Context, id primitive.ObjectID) (model.Post, error)
+	RestorePost(ctx context.Context, id primitive.ObjectID) (model.Post, error)
+	// PurgePosts permanently removes the posts deleted before the given time
+	// and returns how many there were
+	PurgePosts(ctx context.Context, deletedBefore time.Time) (int64, error)
+	// ReassignPosts moves every post of an author to another user, or removes
+	// the author from the posts when the new author is the zero ObjectID
+	ReassignPosts(ctx context.Context, from primitive.ObjectID, to primitive.ObjectID) error
+	// InvalidateCache drops the cached copy of the post and the cached post
+	// listings, so the next reads see the stored post
+	InvalidateCache(ctx context.Context, id primitive.ObjectID) error
 }
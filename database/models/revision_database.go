@@ -0,0 +1,17 @@
+package database
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"main.go/model"
+)
+
+// PostRevisionDatabase represents the database operations for post revisions.
+// Revisions are only ever added; a post has at most one revision per number.
+type PostRevisionDatabase interface {
+	AddPostRevision(ctx context.Context, revision model.PostRevision) error
+	// GetPostRevisions returns the revisions of a post, oldest first
+	GetPostRevisions(ctx context.Context, postID primitive.ObjectID) ([]model.PostRevision, error)
+	GetPostRevision(ctx context.Context, postID primitive.ObjectID, number int64) (model.PostRevision, error)
+}
@@ -1,17 +1,45 @@
 package database
 
 import (
+	"context"
+	"time"
+
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"main.go/model"
 )
 
-// UserDatabase provides an abstraction for user-related database operations
+// UserDatabase provides an abstraction for user-related database operations.
+//
+// Updates and deletes only apply while the stored version equals the Version
+// of the user, or the given version, and fail with ErrVersionMismatch otherwise.
+// A zero version applies them unconditionally. Every change increments the version.
+//
+// Deleting a user moves it to the trash, from where it can be restored until
+// it is purged. Users in the trash are invisible to every other method, but
+// keep their username and email address taken.
 type UserDatabase interface {
-	GetUsers() ([]model.User, error)
-	GetUserByID(id primitive.ObjectID) (model.User, error)
-	GetLatestInsertedUser() (model.User, error)
-	AddUser(user model.User) (model.User, error)
-	UpdateUser(user model.User) (model.User, error)
-	PatchUser(post model.User) (model.User, error)
-	DeleteUser(id primitive.ObjectID) error
+	GetUsers(ctx context.Context, filter model.UserFilter, page model.PageRequest) (model.UserPage, error)
+	GetUserByID(ctx context.Context, id primitive.ObjectID) (model.User, error)
+	GetUserByUserName(ctx context.Context, username string) (model.User, error)
+	// GetUsersFromDB lists the users from the database, bypassing the cache, for
+	// walks over every user that must see the committed state
+	GetUsersFromDB(ctx context.Context, filter model.UserFilter, page model.PageRequest) (model.UserPage, error)
+	// GetUserByIDFromDB reads the user from the database, bypassing the cache,
+	// for changes and checks that must see the latest committed version
+	GetUserByIDFromDB(ctx context.Context, id primitive.ObjectID) (model.User, error)
+	GetLatestInsertedUser(ctx context.Context) (model.User, error)
+	AddUser(ctx context.Context, user model.User) (model.User, error)
+	UpdateUser(ctx context.Context, user model.User) (model.User, error)
+	// PatchUser sets the named fields to their values in user in one update,
+	// removing the ones left empty, and returns the stored user
+	PatchUser(ctx context.Context, user model.User, fields []string) (model.User, error)
+	DeleteUser(ctx context.Context, id primitive.ObjectID, version int64) error
+	GetDeletedUserByID(ctx context.Context, id primitive.ObjectID) (model.User, error)
+	RestoreUser(ctx context.Context, id primitive.ObjectID) (model.User, error)
+	// PurgeUsers permanently removes the users deleted before the given time
+	// and returns how many there were
+	PurgeUsers(ctx context.Context, deletedBefore time.Time) (int64, error)
+	// InvalidateCache drops the cached copy of the user and the cached user
+	// listings, so the next reads see the stored user
+	InvalidateCache(ctx context.Context, id primitive.ObjectID) error
 }
@@ -0,0 +1,231 @@
+// Package diff compares texts line by line and renders the differences in
+// unified diff format.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// context is the number of unchanged lines shown around every change
+const context = 3
+
+// op is a single step of an edit script
+type op struct {
+	kind byte // ' ' keeps the line, '-' deletes it from a, '+' inserts it from b
+	line string
+}
+
+// Unified returns the differences between a and b as unified diff hunks, or
+// an empty string when they are equal. The file names label the two sides.
+func Unified(fromName string, toName string, a string, b string) string {
+	if a == b {
+		return ""
+	}
+
+	ops := edits(splitLines(a), splitLines(b))
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+	for _, h := range hunks(ops) {
+		writeHunk(&out, ops, h)
+	}
+
+	return out.String()
+}
+
+// splitLines splits the text into lines, without the line endings
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// maxEdits bounds the edit distance searched for, which keeps the time and
+// memory in check for texts that have little in common. Beyond it the
+// differing lines are replaced as a whole.
+const maxEdits = 1000
+
+// edits returns the shortest edit script turning a into b, using Myers'
+// O((N+M)D) algorithm on the lines between the common prefix and suffix
+func edits(a []string, b []string) []op {
+	var prefix, suffix int
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var ops []op
+	for _, line := range a[:prefix] {
+		ops = append(ops, op{' ', line})
+	}
+	ops = append(ops, myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, op{' ', line})
+	}
+
+	return ops
+}
+
+// myers returns the shortest edit script turning a into b, or one replacing
+// all of a by b when that needs more than maxEdits steps
+func myers(a []string, b []string) []op {
+	n, m := len(a), len(b)
+	max := n + m
+	if max > maxEdits {
+		max = maxEdits
+	}
+
+	// v[k+offset] is the furthest x reached on diagonal k; trace keeps the
+	// diagonals -d-1..d+1 per edit distance d to walk the path back
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	var trace [][]int
+
+	found := false
+search:
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[k-1+offset] < v[k+1+offset]) {
+				x = v[k+1+offset]
+			} else {
+				x = v[k-1+offset] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[k+offset] = x
+			if x >= n && y >= m {
+				found = true
+				break search
+			}
+		}
+	}
+
+	if !found {
+		var ops []op
+		for _, line := range a {
+			ops = append(ops, op{'-', line})
+		}
+		for _, line := range b {
+			ops = append(ops, op{'+', line})
+		}
+		return ops
+	}
+
+	// Walk back from the end, prepending the steps taken
+	var ops []op
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		// The saved diagonals of distance d start at -d-1
+		v := trace[d]
+		at := func(k int) int { return v[k+d+1] }
+		k := x - y
+
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x, y = x-1, y-1
+			ops = append(ops, op{' ', a[x]})
+		}
+		if d > 0 {
+			if x == prevX {
+				ops = append(ops, op{'+', b[prevY]})
+			} else {
+				ops = append(ops, op{'-', a[prevX]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+
+	return ops
+}
+
+// hunk is a range of the edit script shown together
+type hunk struct {
+	start, end int
+}
+
+// hunks groups the changes of the edit script with their context, merging
+// the groups whose context overlaps
+func hunks(ops []op) []hunk {
+	var result []hunk
+	for i, o := range ops {
+		if o.kind == ' ' {
+			continue
+		}
+
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		end := i + context + 1
+		if end > len(ops) {
+			end = len(ops)
+		}
+
+		if n := len(result); n > 0 && start <= result[n-1].end {
+			result[n-1].end = end
+		} else {
+			result = append(result, hunk{start, end})
+		}
+	}
+	return result
+}
+
+// writeHunk writes the hunk header with its line ranges, followed by the lines
+func writeHunk(out *strings.Builder, ops []op, h hunk) {
+	// Count the lines of a and b before and within the hunk
+	aStart, bStart := 1, 1
+	for _, o := range ops[:h.start] {
+		if o.kind != '+' {
+			aStart++
+		}
+		if o.kind != '-' {
+			bStart++
+		}
+	}
+	var aLines, bLines int
+	for _, o := range ops[h.start:h.end] {
+		if o.kind != '+' {
+			aLines++
+		}
+		if o.kind != '-' {
+			bLines++
+		}
+	}
+
+	fmt.Fprintf(out, "@@ -%s +%s @@\n", hunkRange(aStart, aLines), hunkRange(bStart, bLines))
+	for _, o := range ops[h.start:h.end] {
+		fmt.Fprintf(out, "%c%s\n", o.kind, o.line)
+	}
+}
+
+// hunkRange formats the start and length of one side of a hunk. An empty
+// side starts at the line before it, as in GNU diff.
+func hunkRange(start int, lines int) string {
+	if lines == 0 {
+		return fmt.Sprintf("%d,0", start-1)
+	}
+	if lines == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, lines)
+}
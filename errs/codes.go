@@ -0,0 +1,74 @@
+package errs
+
+// Code identifies an error condition. Codes are part of the API contract and
+// must never change once published; add new ones instead.
+type Code string
+
+const (
+	CodeInternal Code = "internal_error"
+	CodeTimeout  Code = "timeout"
+
+	CodeInvalidID            Code = "invalid_id"
+	CodeInvalidRequestBody   Code = "invalid_request_body"
+	CodeInvalidQuery         Code = "invalid_query_parameter"
+	CodeInvalidSearchQuery   Code = "invalid_search_query"
+	CodeInvalidRevision      Code = "invalid_revision"
+	CodeValidationFailed     Code = "validation_failed"
+	CodeInvalidPatch         Code = "invalid_patch"
+	CodeUnsupportedMediaType Code = "unsupported_media_type"
+
+	CodePostNotFound      Code = "post_not_found"
+	CodeUserNotFound      Code = "user_not_found"
+	CodeRevisionNotFound  Code = "revision_not_found"
+	CodeEventTypeNotFound Code = "event_type_not_found"
+	CodeRouteNotFound     Code = "route_not_found"
+
+	CodeAlreadyExists  Code = "already_exists"
+	CodeUsernameTaken  Code = "username_taken"
+	CodeEmailTaken     Code = "email_taken"
+	CodeCascadeInvalid Code = "cascade_invalid"
+
+	CodeVersionMismatch Code = "version_mismatch"
+	CodeInvalidETag     Code = "invalid_etag"
+	CodePatchTestFailed Code = "patch_test_failed"
+
+	CodeInvalidCredentials     Code = "invalid_credentials"
+	CodeInvalidToken           Code = "invalid_token"
+	CodeAuthenticationRequired Code = "authentication_required"
+	CodePermissionDenied       Code = "permission_denied"
+)
+
+// Titles holds the short, human-readable summary of every code
+var Titles = map[Code]string{
+	CodeInternal: "Internal server error",
+	CodeTimeout:  "Operation timed out",
+
+	CodeInvalidID:            "Invalid identifier",
+	CodeInvalidRequestBody:   "Invalid request body",
+	CodeInvalidQuery:         "Invalid query parameter",
+	CodeInvalidSearchQuery:   "Invalid search query",
+	CodeInvalidRevision:      "Invalid revision number",
+	CodeValidationFailed:     "Validation failed",
+	CodeInvalidPatch:         "Invalid patch document",
+	CodeUnsupportedMediaType: "Unsupported media type",
+
+	CodePostNotFound:      "Post not found",
+	CodeUserNotFound:      "User not found",
+	CodeRevisionNotFound:  "Revision not found",
+	CodeEventTypeNotFound: "Event type not found",
+	CodeRouteNotFound:     "Route not found",
+
+	CodeAlreadyExists:  "Resource already exists",
+	CodeUsernameTaken:  "Username already taken",
+	CodeEmailTaken:     "Email address already in use",
+	CodeCascadeInvalid: "Cascade not possible",
+
+	CodeVersionMismatch: "Version mismatch",
+	CodeInvalidETag:     "Invalid entity tag",
+	CodePatchTestFailed: "Patch test failed",
+
+	CodeInvalidCredentials:     "Invalid credentials",
+	CodeInvalidToken:           "Invalid token",
+	CodeAuthenticationRequired: "Authentication required",
+	CodePermissionDenied:       "Permission denied",
+}
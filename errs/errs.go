@@ -0,0 +1,123 @@
+package errs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Kind classifies an error independently of the transport it is reported over
+type Kind int
+
+const (
+	KindInternal Kind = iota
+	KindNotFound
+	KindValidation
+	KindConflict
+	KindUnauthorized
+	KindForbidden
+	KindTimeout
+	KindPreconditionFailed
+	KindUnsupportedMediaType
+)
+
+// FieldError describes what is wrong with a single input field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error is a domain error. Message is safe to show to clients, while Err keeps
+// the underlying cause for logging only.
+type Error struct {
+	Kind    Kind
+	Code    Code
+	Message string
+	Fields  []FieldError
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// NotFound reports a resource that does not exist
+func NotFound(code Code, message string) *Error {
+	return &Error{Kind: KindNotFound, Code: code, Message: message}
+}
+
+// Validation reports invalid input, optionally detailed per field
+func Validation(code Code, message string, fields ...FieldError) *Error {
+	return &Error{Kind: KindValidation, Code: code, Message: message, Fields: fields}
+}
+
+// Conflict reports a request that clashes with the current state, such as a duplicate
+func Conflict(code Code, message string) *Error {
+	return &Error{Kind: KindConflict, Code: code, Message: message}
+}
+
+// Unauthorized reports missing or invalid credentials
+func Unauthorized(code Code, message string) *Error {
+	return &Error{Kind: KindUnauthorized, Code: code, Message: message}
+}
+
+// Forbidden reports a caller that may not perform the operation
+func Forbidden(code Code, message string) *Error {
+	return &Error{Kind: KindForbidden, Code: code, Message: message}
+}
+
+// PreconditionFailed reports a conditional request whose condition does not hold,
+// such as a version that is no longer current
+func PreconditionFailed(code Code, message string) *Error {
+	return &Error{Kind: KindPreconditionFailed, Code: code, Message: message}
+}
+
+// UnsupportedMediaType reports a request body in a format the operation does not accept
+func UnsupportedMediaType(code Code, message string) *Error {
+	return &Error{Kind: KindUnsupportedMediaType, Code: code, Message: message}
+}
+
+// Internal wraps an unexpected error; its details are never shown to clients
+func Internal(err error) *Error {
+	return &Error{Kind: KindInternal, Code: CodeInternal, Message: "internal server error", Err: err}
+}
+
+// Wrap returns a copy of the error carrying the given cause
+func (e *Error) Wrap(err error) *Error {
+	wrapped := *e
+	wrapped.Err = err
+	return &wrapped
+}
+
+// Is matches errors with the same code, so sentinel errors still match after Wrap
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// As returns the domain error in the chain. Errors that are not domain errors
+// are reported as internal errors, except for expired deadlines.
+func As(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &Error{Kind: KindTimeout, Code: CodeTimeout, Message: "the operation timed out", Err: err}
+	}
+
+	return Internal(err)
+}
+
+// KindOf returns the kind of the error
+func KindOf(err error) Kind {
+	return As(err).Kind
+}
@@ -1,98 +1,581 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"main.go/api"
+	"main.go/auth"
+	"main.go/authz"
+	cacher "main.go/cache"
 	cache "main.go/cache/implementation"
+	"main.go/config"
 	"main.go/database"
+	"main.go/database/implementations/memory"
 	"main.go/database/implementations/mongodb"
+	models "main.go/database/models"
 	"main.go/messaging"
+	"main.go/model"
 	"main.go/repository"
 	"main.go/search"
 	"main.go/service"
 )
 
+// backends bundles the storage, search and messaging implementations the services run on
+type backends struct {
+	postDB       models.PostDatabase
+	revisionDB   models.PostRevisionDatabase
+	outboxDB     models.OutboxDatabase
+	transactor   models.Transactor
+	userDB       models.UserDatabase
+	searchEngine search.SearchEngine
+	messaging    messaging.Messaging
+	cache        cacher.Cacher
+}
+
 func main() {
-	// Create a MongoDB connection
-	mongoDB, err := connectToMongoDB()
+	// The first argument selects the command, serving the API is the default
+	args := os.Args[1:]
+	command := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "serve":
+		cfg := loadConfig("serve", args)
+		serve(cfg)
+	case "worker":
+		cfg := loadConfig("worker", args)
+		work(cfg)
+	case "reindex", "verify":
+		maintainIndices(command, args)
+	case "create-admin":
+		createAdmin(args)
+	case "config":
+		if len(args) == 0 || args[0] != "print" {
+			log.Fatal("usage: config print [flags]")
+		}
+		cfg := loadConfig("config print", args[1:])
+		printConfig(cfg)
+	default:
+		log.Fatalf("unknown command %q, expected serve, worker, reindex, verify, create-admin or config print", command)
+	}
+}
+
+// loadConfig loads the configuration or exits when it is invalid
+func loadConfig(name string, args []string) config.Config {
+	cfg, err := config.Load(name, args)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
+	return cfg
+}
 
-	//setting up the Elastic Search Configuration
-	esURL := "http://localhost:9200"
-	esUsername := "root"
-	esPassword := "123456"
-
-	// Create the ElasticSearchEngine instance with the necessary configurations
-	esEngine, err := search.NewElasticSearchEngine(esURL, esUsername, esPassword)
+// printConfig writes the effective configuration, secrets redacted, to stdout
+func printConfig(cfg config.Config) {
+	out, err := config.Print(cfg)
 	if err != nil {
 		log.Fatal(err)
 	}
+	fmt.Print(out)
+}
 
-	// Create the Redis cache instance
-	redisAddr := "127.0.0.1:6379"
-	redisPassword := ""
-	redisDB := 0
+// openBackends connects to the configured backend or exits when it is unavailable
+func openBackends(cfg config.Config) backends {
+	var b backends
+	var err error
 
-	redisCache, err := cache.NewRedisCache(redisAddr, redisPassword, redisDB)
+	switch cfg.Backend {
+	case "memory":
+		b = newMemoryBackends()
+	case "mongodb":
+		b, err = newMongoBackends(cfg)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// Create the CacheService
-	cacheDatabse := database.NewCacheDatabase(redisCache) // Pass the Redis cache instance
+	// Declare the mappings of the search indices created from now on
+	err = b.searchEngine.PutIndexTemplates(context.Background(), search.IndexDefinitions())
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	// Create the PostRepository using the MongoDB database instance
-	postRepository := repository.NewPostRepository(mongodb.NewPostMongoDB(mongoDB, cacheDatabse), esEngine)
+	return b
+}
 
-	// Create the UserRepository using the MongoDB database instance
-	userRepository := repository.NewUserRepository(mongodb.NewUserMongoDB(mongoDB, cacheDatabse), esEngine)
+// serve wires the application and starts the HTTP server
+func serve(cfg config.Config) {
+	b := openBackends(cfg)
 
-	// Create the NATS messaging system
-	natsURL := "nats://localhost:4222" // Default URL
-	natsMessaging, err := messaging.NewNatsMessaging(natsURL)
+	// Create the PostRepository using the database instance
+	postRepository := repository.NewPostRepository(b.postDB, b.searchEngine)
+
+	// Create the PostRevisionRepository keeping the history of the posts
+	revisionRepository := repository.NewPostRevisionRepository(b.revisionDB)
+
+	// Create the UserRepository using the database instance
+	userRepository := repository.NewUserRepository(b.userDB, b.searchEngine)
+
+	// Create the OutboxRepository, storing the messages with the changes they announce
+	outboxRepository := repository.NewOutboxRepository(b.outboxDB, b.transactor)
+
+	// Create the MessagingService
+	messagingService := service.NewMessagingService(b.messaging, outboxRepository)
+
+	// Publish the messages in the outbox in the background
+	relay := service.NewOutboxRelay(outboxRepository, b.messaging, relayOptions(cfg.Outbox))
+	go relay.Run(context.Background())
+
+	// Cache the posts related to a post until the post changes
+	related := service.NewRelatedPostCache(b.cache, cfg.Search.RelatedCacheTTL)
+
+	// Keep the cache and search index in sync with the changes in the background
+	if cfg.Worker.Embedded {
+		go ensureIndices(postRepository, userRepository)
+
+		worker := service.NewWorker(messagingService, postRepository, userRepository, related, workerOptions(cfg.Worker))
+		go func() {
+			if err := worker.Run(context.Background()); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	// Load the roles and permissions the services and routes are checked against
+	policy, err := authz.LoadPolicy(cfg.Authz.PolicyFile)
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	// Create the MessagingService
-	messagingService := service.NewMessagingService(natsMessaging)
+	authorizer := authz.NewAuthorizer(policy)
 
 	// Create the services
-	postService := service.NewPostService(postRepository, messagingService) // Pass the messagingService
-	userService := service.NewUserService(userRepository, messagingService) // Pass the messagingService
+	suggestions := service.NewSuggestionCache(b.cache, cfg.Search.SuggestCacheTTL)
+	postService := service.NewPostService(postRepository, revisionRepository, messagingService, authorizer, suggestions, related) // Pass the messagingService
+	userService := service.NewUserService(userRepository, postService, messagingService, authorizer, postCascade(cfg.Users), suggestions)
+
+	// Create the AuthService, signing tokens with the configured secret
+	tokens := auth.NewTokenIssuer(tokenSecret(cfg.Auth), cfg.Auth.AccessTTL, cfg.Auth.RefreshTTL)
+	authService := service.NewAuthService(userRepository, userService, tokens, b.cache)
+
+	// Empty the trash of items past the retention in the background
+	if cfg.Trash.Retention > 0 {
+		purger := service.NewPurger(postRepository, userRepository, cfg.Trash.Retention, cfg.Trash.PurgeInterval)
+		go purger.Run(context.Background())
+	}
 
 	// Create the API router
-	router := api.NewRouter(postService, userService, messagingService) // Pass the messagingService
+	router := api.NewRouter(postService, userService, authService, messagingService, authorizer) // Pass the messagingService
 
 	// Start the server
-	log.Fatal(http.ListenAndServe(":8080", router))
+	log.Printf("Listening on %s with the %s backend", cfg.Server.Addr, cfg.Backend)
+	log.Fatal(http.ListenAndServe(cfg.Server.Addr, router))
 }
 
-func connectToMongoDB() (*mongo.Database, error) {
+// work runs the worker keeping the cache and search index in sync until the
+// process is interrupted or terminated
+func work(cfg config.Config) {
+	if cfg.Backend == "memory" {
+		log.Fatal("the worker needs the mongodb backend, with the memory backend it runs inside serve")
+	}
+
+	b := openBackends(cfg)
+
+	postRepository := repository.NewPostRepository(b.postDB, b.searchEngine)
+	userRepository := repository.NewUserRepository(b.userDB, b.searchEngine)
+	outboxRepository := repository.NewOutboxRepository(b.outboxDB, b.transactor)
+	messagingService := service.NewMessagingService(b.messaging, outboxRepository)
+	related := service.NewRelatedPostCache(b.cache, cfg.Search.RelatedCacheTTL)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go ensureIndices(postRepository, userRepository)
+
+	log.Printf("Syncing the cache and search index in queue group %s", cfg.Worker.Queue)
+	worker := service.NewWorker(messagingService, postRepository, userRepository, related, workerOptions(cfg.Worker))
+	if err := worker.Run(ctx); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := messagingService.Close(); err != nil {
+		log.Println("Failed to close the messaging connection:", err)
+	}
+}
+
+// ensureIndices rebuilds the search indices whose mappings are outdated,
+// while the worker keeps the current ones in sync
+func ensureIndices(postRepository *repository.PostRepository, userRepository *repository.UserRepository) {
+	searchIndexService := service.NewSearchIndexService(postRepository, userRepository)
+	if err := searchIndexService.EnsureIndices(context.Background()); err != nil {
+		log.Println("Failed to bring the search indices up to date:", err)
+	}
+}
+
+// maintainIndices rebuilds the search indices with reindex or checks them
+// against the database with verify, optionally repairing the differences.
+// Both run on every index unless --index names one.
+func maintainIndices(command string, args []string) {
+	fs := flag.NewFlagSet(command, flag.ContinueOnError)
+	index := fs.String("index", "", fmt.Sprintf("index to %s, one of %v; all when empty", command, service.SearchIndices))
+	repair := false
+	if command == "verify" {
+		fs.BoolVar(&repair, "repair", false, "sync the missing, stale and orphaned documents found")
+	}
+
+	cfg, err := config.LoadFlags(fs, args)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.Backend == "memory" {
+		log.Fatalf("%s needs the mongodb backend, the memory backend keeps nothing to %s", command, command)
+	}
+
+	indices := service.SearchIndices
+	if *index != "" {
+		indices = []string{*index}
+	}
+	for _, name := range indices {
+		if !contains(service.SearchIndices, name) {
+			log.Fatalf("unknown index %q, expected one of %v", name, service.SearchIndices)
+		}
+	}
+
+	b := openBackends(cfg)
+	searchIndexService := service.NewSearchIndexService(
+		repository.NewPostRepository(b.postDB, b.searchEngine),
+		repository.NewUserRepository(b.userDB, b.searchEngine),
+	)
+
+	consistent := true
+	for _, name := range indices {
+		if command == "reindex" {
+			rebuild, err := searchIndexService.Reindex(context.Background(), name)
+			if err != nil {
+				log.Fatalf("Failed to reindex %s: %v", name, err)
+			}
+			log.Printf("Indexed %d documents into %s, %s points at it now (%d synced after the swap, removed %v)",
+				rebuild.Documents, rebuild.Index, rebuild.Alias, rebuild.Repaired, rebuild.Removed)
+			continue
+		}
+
+		report, err := searchIndexService.Verify(context.Background(), name, repair)
+		if err != nil {
+			log.Fatalf("Failed to verify %s: %v", name, err)
+		}
+		printIndexReport(report)
+		if !report.Consistent() && !repair {
+			consistent = false
+		}
+	}
+
+	if !consistent {
+		os.Exit(1)
+	}
+}
+
+// createAdmin creates a user with the admin role, reading the password from
+// the first line of stdin. Registration never hands out roles, so this is how
+// a deployment gets its first admin.
+func createAdmin(args []string) {
+	fs := flag.NewFlagSet("create-admin", flag.ContinueOnError)
+	username := fs.String("username", "", "username of the admin")
+	email := fs.String("email", "", "email address of the admin")
+	fullname := fs.String("fullname", "", "full name of the admin")
+
+	cfg, err := config.LoadFlags(fs, args)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.Backend == "memory" {
+		log.Fatal("create-admin needs the mongodb backend, the memory backend keeps nothing to add the admin to")
+	}
+
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		log.Fatal("failed to read the password from stdin: ", err)
+	}
+	password = strings.TrimRight(password, "\r\n")
+
+	b := openBackends(cfg)
+
+	postRepository := repository.NewPostRepository(b.postDB, b.searchEngine)
+	revisionRepository := repository.NewPostRevisionRepository(b.revisionDB)
+	userRepository := repository.NewUserRepository(b.userDB, b.searchEngine)
+	outboxRepository := repository.NewOutboxRepository(b.outboxDB, b.transactor)
+	messagingService := service.NewMessagingService(b.messaging, outboxRepository)
+
+	policy, err := authz.LoadPolicy(cfg.Authz.PolicyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	authorizer := authz.NewAuthorizer(policy)
+
+	suggestions := service.NewSuggestionCache(b.cache, cfg.Search.SuggestCacheTTL)
+	related := service.NewRelatedPostCache(b.cache, cfg.Search.RelatedCacheTTL)
+	postService := service.NewPostService(postRepository, revisionRepository, messagingService, authorizer, suggestions, related)
+	userService := service.NewUserService(userRepository, postService, messagingService, authorizer, postCascade(cfg.Users), suggestions)
+
+	// Creating a user issues no tokens
+	authService := service.NewAuthService(userRepository, userService, nil, b.cache)
+
+	// The outbox relay of the servers announces the new user
+	user, err := authService.CreateAdmin(context.Background(), model.User{
+		UserName: *username,
+		Email:    *email,
+		FullName: *fullname,
+	}, password)
+	if err != nil {
+		log.Fatalf("Failed to create the admin: %v", err)
+	}
+	log.Printf("Created admin %s with ID %s", user.UserName, user.ID.Hex())
+
+	if err := messagingService.Close(); err != nil {
+		log.Println("Failed to close the messaging connection:", err)
+	}
+}
+
+// printIndexReport writes the differences between an index and the database to stdout
+func printIndexReport(report repository.IndexReport) {
+	fmt.Printf("%s: %d checked, %d missing, %d stale, %d orphaned, %d repaired\n",
+		report.Index, report.Checked, len(report.Missing), len(report.Stale), len(report.Orphaned), report.Repaired)
+
+	for _, id := range report.Missing {
+		fmt.Printf("  missing %s\n", id)
+	}
+	for _, id := range report.Stale {
+		fmt.Printf("  stale %s\n", id)
+	}
+	for _, id := range report.Orphaned {
+		fmt.Printf("  orphaned %s\n", id)
+	}
+}
+
+// contains reports whether the list holds the value
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// postCascade translates the configured treatment of a deleted user's posts
+func postCascade(cfg config.UsersConfig) service.PostCascade {
+	cascade := service.PostCascade{Mode: cfg.PostCascade}
+	if cfg.PostCascade == service.CascadeReassign {
+		// The configuration was validated, so the ID is well formed
+		cascade.ReassignTo, _ = primitive.ObjectIDFromHex(cfg.ReassignPostsTo)
+	}
+	return cascade
+}
+
+// checkTransactions fails unless MongoDB runs as a replica set or a sharded
+// cluster, the deployments supporting transactions
+func checkTransactions(ctx context.Context, client *mongo.Client) error {
+	var hello struct {
+		SetName string `bson:"setName"` // Set by members of a replica set
+		Msg     string `bson:"msg"`     // "isdbgrid" for a mongos router
+	}
+	err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&hello)
+	if err != nil {
+		return fmt.Errorf("failed to check the MongoDB deployment: %v", err)
+	}
+
+	if hello.SetName == "" && hello.Msg != "isdbgrid" {
+		return fmt.Errorf("MongoDB runs as a standalone server, but writes need transactions, which need a replica set: " +
+			"start mongod with --replSet and run rs.initiate() once, or use the memory backend")
+	}
+
+	return nil
+}
+
+// relayOptions translates the configuration of the outbox relay
+func relayOptions(cfg config.OutboxConfig) service.RelayOptions {
+	return service.RelayOptions{
+		PollInterval: cfg.PollInterval,
+		BatchSize:    cfg.BatchSize,
+		RetryBackoff: cfg.RetryBackoff,
+		MaxBackoff:   cfg.MaxBackoff,
+		Retention:    cfg.Retention,
+		LeaseTTL:     cfg.LeaseTTL,
+	}
+}
+
+// workerOptions translates the configuration of the worker
+func workerOptions(cfg config.WorkerConfig) service.WorkerOptions {
+	return service.WorkerOptions{
+		Queue:        cfg.Queue,
+		Retries:      cfg.Retries,
+		RetryBackoff: cfg.RetryBackoff,
+		MaxBackoff:   cfg.MaxBackoff,
+	}
+}
+
+// tokenSecret returns the configured signing secret, or a random one when
+// none is configured, in which case tokens are invalidated by a restart
+func tokenSecret(cfg config.AuthConfig) string {
+	if cfg.Secret != "" {
+		return cfg.Secret
+	}
+
+	log.Println("No auth.secret configured, using a random secret for this process")
+
+	secret := make([]byte, config.MinSecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		log.Fatal(err)
+	}
+	return hex.EncodeToString(secret)
+}
+
+// newMemoryBackends wires the in-memory implementations, so the API runs
+// without any external service
+func newMemoryBackends() backends {
+	return backends{
+		postDB:       memory.NewPostMemoryDB(),
+		revisionDB:   memory.NewPostRevisionMemoryDB(),
+		outboxDB:     memory.NewOutboxMemoryDB(),
+		transactor:   memory.NewTransactor(),
+		userDB:       memory.NewUserMemoryDB(),
+		searchEngine: search.NewMemorySearchEngine(),
+		messaging:    messaging.NewMemoryMessaging(),
+		cache:        cache.NewMemoryCache(),
+	}
+}
+
+// newMongoBackends connects to MongoDB, Redis, Elasticsearch and NATS
+func newMongoBackends(cfg config.Config) (backends, error) {
+	// Create a MongoDB connection
+	mongoDB, err := connectToMongoDB(cfg.Mongo)
+	if err != nil {
+		return backends{}, err
+	}
+
+	// Create the ElasticSearchEngine instance with the necessary configurations
+	es := cfg.Elasticsearch
+	esEngine, err := search.NewElasticSearchEngine(es.URL, es.Username, es.Password, es.Timeout)
+	if err != nil {
+		return backends{}, err
+	}
+
+	// Create the Redis cache instance
+	redisCache, err := cache.NewRedisCache(cfg.Redis.Addr, cfg.Redis.Password, cfg.Redis.DB, cfg.Redis.Timeout)
+	if err != nil {
+		return backends{}, err
+	}
+
+	// Create the CacheService
+	cacheDatabse := database.NewCacheDatabase(redisCache) // Pass the Redis cache instance
+
+	// Create the NATS messaging system
+	natsMessaging, err := newNatsMessaging(cfg.NATS)
+	if err != nil {
+		return backends{}, err
+	}
+
+	// Usernames and email addresses are unique, also under concurrent registrations
+	userDB := mongodb.NewUserMongoDB(mongoDB, cacheDatabse, cfg.Mongo.Timeout)
+	err = userDB.EnsureIndexes(context.Background())
+	if err != nil {
+		return backends{}, err
+	}
+
+	// A post has one revision per version
+	revisionDB := mongodb.NewPostRevisionMongoDB(mongoDB, cfg.Mongo.Timeout)
+	err = revisionDB.EnsureIndexes(context.Background())
+	if err != nil {
+		return backends{}, err
+	}
+
+	// Pending events are read in the order they were added
+	outboxDB := mongodb.NewOutboxMongoDB(mongoDB, cfg.Mongo.Timeout)
+	err = outboxDB.EnsureIndexes(context.Background())
+	if err != nil {
+		return backends{}, err
+	}
+
+	return backends{
+		postDB:       mongodb.NewPostMongoDB(mongoDB, cacheDatabse, cfg.Mongo.Timeout),
+		revisionDB:   revisionDB,
+		outboxDB:     outboxDB,
+		transactor:   mongodb.NewTransactor(mongoDB.Client()),
+		userDB:       userDB,
+		searchEngine: esEngine,
+		messaging:    natsMessaging,
+		cache:        redisCache,
+	}, nil
+}
+
+// newNatsMessaging connects to NATS, with durable JetStream streams and
+// consumers unless core NATS is configured
+func newNatsMessaging(cfg config.NATSConfig) (messaging.Messaging, error) {
+	if cfg.Mode == "core" {
+		return messaging.NewNatsMessaging(cfg.URL, cfg.Timeout)
+	}
+
+	return messaging.NewJetStreamMessaging(cfg.URL, cfg.Timeout, messaging.JetStreamOptions{
+		Consumer:     cfg.Consumer,
+		MaxDeliver:   cfg.MaxDeliver,
+		AckWait:      cfg.AckWait,
+		RetryBackoff: cfg.RetryBackoff,
+		MaxBackoff:   cfg.MaxBackoff,
+		DeadLetter:   cfg.DeadLetter,
+		MaxAge:       cfg.MaxAge,
+	})
+}
+
+func connectToMongoDB(cfg config.MongoConfig) (*mongo.Database, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+	defer cancel()
+
 	// Set MongoDB connection options
-	clientOptions := options.Client().ApplyURI("mongodb://localhost:27017")
+	clientOptions := options.Client().ApplyURI(cfg.URI)
 
 	// Connect to MongoDB
-	client, err := mongo.Connect(context.Background(), clientOptions)
+	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
 		return nil, err
 	}
 
 	// Ping MongoDB to verify the connection
-	err = client.Ping(context.Background(), nil)
+	err = client.Ping(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every write runs in a transaction, which a standalone server lacks
+	err = checkTransactions(ctx, client)
 	if err != nil {
 		return nil, err
 	}
 
 	// Get the MongoDB database instance
-	db := client.Database("project")
+	db := client.Database(cfg.Database)
 
 	// Return the MongoDB database instance
 	return db, nil
@@ -0,0 +1,394 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Headers describing why a message was moved to the dead-letter subject
+const (
+	DeadLetterSubjectHeader    = "Dead-Letter-Subject"
+	DeadLetterConsumerHeader   = "Dead-Letter-Consumer"
+	DeadLetterDeliveriesHeader = "Dead-Letter-Deliveries"
+	DeadLetterErrorHeader      = "Dead-Letter-Error"
+)
+
+// fetchWait bounds how long a pull waits for messages before it asks again
+const fetchWait = 5 * time.Second
+
+// JetStreamOptions configures the streams and consumers of JetStreamMessaging
+type JetStreamOptions struct {
+	Consumer     string        // Prefix of the durable consumer names, shared by the replicas of a service
+	MaxDeliver   int           // Deliveries of a message before it is moved to the dead-letter subject
+	AckWait      time.Duration // How long a delivered message may stay unacknowledged before it is delivered again
+	RetryBackoff time.Duration // Delay before redelivering a failed message, doubled on every further failure
+	MaxBackoff   time.Duration // Longest delay before a redelivery
+	DeadLetter   string        // Prefix of the subjects failed messages are moved to, followed by their subject
+	MaxAge       time.Duration // How long the streams keep messages; 0 keeps them until the limits are hit
+}
+
+// streams are the JetStream streams provisioned for the published subjects
+var streams = []nats.StreamConfig{
+	{Name: "POSTS", Subjects: []string{"post.*"}},
+	{Name: "USERS", Subjects: []string{"user.*"}},
+}
+
+// JetStreamMessaging is a Messaging implementation on NATS JetStream. Messages
+// are stored in streams, and queue subscriptions are durable pull consumers,
+// so nothing is lost while every member of a queue group is down. Plain
+// subscriptions are ephemeral and only see new messages. Every subscription
+// handles its messages one at a time, in order. A message
+// whose handling fails is delivered again after a backoff and moved to the
+// dead-letter subject once it was delivered MaxDeliver times. Handlers get a
+// context cancelled when their subscription ends or the messaging is closed;
+// a message whose handler was cut short by that is delivered again at once.
+type JetStreamMessaging struct {
+	nc      *nats.Conn
+	js      nats.JetStreamContext
+	options JetStreamOptions
+	timeout time.Duration
+
+	ctx    context.Context // Cancelled by Close to stop the consumers
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewJetStreamMessaging connects to NATS and creates or updates the streams
+// and the dead-letter stream. The timeout bounds connecting and every request
+// to JetStream.
+func NewJetStreamMessaging(url string, timeout time.Duration, options JetStreamOptions) (*JetStreamMessaging, error) {
+	var opts []nats.Option
+	if timeout > 0 {
+		opts = append(opts, nats.Timeout(timeout))
+	}
+
+	nc, err := nats.Connect(url, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	js, err := nc.JetStream(nats.MaxWait(timeout))
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &JetStreamMessaging{
+		nc:      nc,
+		js:      js,
+		options: options,
+		timeout: timeout,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+
+	err = m.provisionStreams()
+	if err != nil {
+		m.Close()
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// provisionStreams creates the streams that do not exist yet and brings the
+// existing ones up to date
+func (m *JetStreamMessaging) provisionStreams() error {
+	configs := append([]nats.StreamConfig{}, streams...)
+	configs = append(configs, nats.StreamConfig{
+		Name:     "DEAD_LETTERS",
+		Subjects: []string{m.options.DeadLetter + ".>"},
+	})
+
+	for _, cfg := range configs {
+		cfg.Storage = nats.FileStorage
+		cfg.Retention = nats.LimitsPolicy
+		cfg.MaxAge = m.options.MaxAge
+
+		_, err := m.js.StreamInfo(cfg.Name)
+		switch {
+		case errors.Is(err, nats.ErrStreamNotFound):
+			_, err = m.js.AddStream(&cfg)
+		case err == nil:
+			_, err = m.js.UpdateStream(&cfg)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to provision stream %s: %v", cfg.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Publish stores the message in the stream of its subject, returning once
+// JetStream acknowledged it. A message carrying a Message-Id header is stored
+// only once, however often it is published within the duplicate window.
+func (m *JetStreamMessaging) Publish(ctx context.Context, msg *Message) error {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	opts := []nats.PubOpt{nats.Context(ctx)}
+	if id := msg.Header[MessageIDHeader]; id != "" {
+		opts = append(opts, nats.MsgId(id))
+	}
+
+	_, err := m.js.PublishMsg(toNatsMsg(msg), opts...)
+	return err
+}
+
+// Subscribe consumes the messages published on the subject from now on with
+// an ephemeral consumer, which is deleted when the subscription ends
+func (m *JetStreamMessaging) Subscribe(subject string, handler Handler) (Subscription, error) {
+	if err := m.ctx.Err(); err != nil {
+		return nil, ErrClosed
+	}
+
+	sub, err := m.js.PullSubscribe(subject, "",
+		nats.AckExplicit(),
+		nats.AckWait(m.options.AckWait),
+		nats.MaxDeliver(-1),
+		nats.DeliverNew(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.consume(sub, subject, handler), nil
+}
+
+// QueueSubscribe consumes the subject with a durable consumer named after the
+// configured consumer prefix, the queue group and the subject. Subscriptions
+// of the same group, also in other processes, share the messages, and the
+// consumer outlives them, so nothing published while every member of the
+// group is down is lost.
+func (m *JetStreamMessaging) QueueSubscribe(subject string, queue string, handler Handler) (Subscription, error) {
+	if err := m.ctx.Err(); err != nil {
+		return nil, ErrClosed
+	}
+
+	name := durableName(m.options.Consumer + "_" + queue + "_" + subject)
+	stream, err := m.provisionConsumer(subject, name)
+	if err != nil {
+		return nil, err
+	}
+
+	sub, err := m.js.PullSubscribe(subject, name, nats.Bind(stream, name))
+	if err != nil {
+		return nil, err
+	}
+
+	return m.consume(sub, name, handler), nil
+}
+
+// provisionConsumer creates the durable consumer of the subject or brings the
+// existing one up to date and returns the name of its stream. Consumers made
+// here are not deleted when a subscription bound to them ends.
+func (m *JetStreamMessaging) provisionConsumer(subject string, name string) (string, error) {
+	stream, err := m.js.StreamNameBySubject(subject)
+	if err != nil {
+		return "", fmt.Errorf("no stream for subject %s: %v", subject, err)
+	}
+
+	cfg := &nats.ConsumerConfig{
+		Durable:       name,
+		FilterSubject: subject,
+		AckPolicy:     nats.AckExplicitPolicy,
+		AckWait:       m.options.AckWait,
+		MaxDeliver:    -1,
+		DeliverPolicy: nats.DeliverAllPolicy,
+	}
+
+	_, err = m.js.ConsumerInfo(stream, name)
+	switch {
+	case errors.Is(err, nats.ErrConsumerNotFound):
+		_, err = m.js.AddConsumer(stream, cfg)
+	case err == nil:
+		_, err = m.js.UpdateConsumer(stream, cfg)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to provision consumer %s: %v", name, err)
+	}
+
+	return stream, nil
+}
+
+// Request publishes the message on core NATS and waits for the first reply.
+// Replies are not stored, so the subject needs a subscriber that is up.
+func (m *JetStreamMessaging) Request(ctx context.Context, msg *Message, timeout time.Duration) (*Message, error) {
+	return (&NatsMessaging{nc: m.nc}).Request(ctx, msg, timeout)
+}
+
+// jetStreamSubscription is the Subscription of a pull consumer
+type jetStreamSubscription struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Unsubscribe stops fetching messages and cancels the handling of the message
+// at hand. Unless its handler completes regardless, it is delivered again, as
+// are the ones fetched later, to another subscription.
+func (s *jetStreamSubscription) Unsubscribe() error {
+	s.cancel()
+	return nil
+}
+
+// Drain stops fetching messages, cancels the handling of the message at hand
+// and waits for its handler to return
+func (s *jetStreamSubscription) Drain() error {
+	s.cancel()
+	<-s.done
+	return nil
+}
+
+// consume fetches and handles the messages of the subscription on its own
+// goroutine until the subscription ends or the connection is closed
+func (m *JetStreamMessaging) consume(sub *nats.Subscription, consumer string, handler Handler) Subscription {
+	ctx, cancel := context.WithCancel(m.ctx)
+	s := &jetStreamSubscription{cancel: cancel, done: make(chan struct{})}
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer close(s.done)
+		defer sub.Unsubscribe()
+
+		for ctx.Err() == nil {
+			fetchCtx, cancel := context.WithTimeout(ctx, fetchWait)
+			msgs, err := sub.Fetch(1, nats.Context(fetchCtx))
+			cancel()
+
+			if err != nil {
+				if ctx.Err() == nil && !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, nats.ErrTimeout) {
+					log.Printf("Failed to fetch messages for consumer %s: %v", consumer, err)
+					time.Sleep(m.options.RetryBackoff)
+				}
+				continue
+			}
+
+			for _, msg := range msgs {
+				m.handle(ctx, msg, consumer, handler)
+			}
+		}
+	}()
+
+	return s
+}
+
+// handle passes the message to the handler and acknowledges it, schedules its
+// redelivery or moves it to the dead-letter subject. The consumers deliver a
+// message without limit, so one that could not be moved is delivered again
+// and its move retried rather than given up by JetStream. A failure after the
+// context was cancelled is no failure of the message, which is delivered
+// again at once without counting towards MaxDeliver.
+func (m *JetStreamMessaging) handle(ctx context.Context, msg *nats.Msg, consumer string, handler Handler) {
+	meta, err := msg.Metadata()
+	if err != nil {
+		log.Printf("Dropping message on %s without JetStream metadata: %v", msg.Subject, err)
+		msg.Term()
+		return
+	}
+
+	delivered := fromNatsMsg(msg)
+	delivered.Metadata = Metadata{
+		Deliveries: int(meta.NumDelivered),
+		Sequence:   meta.Sequence.Stream,
+		Timestamp:  meta.Timestamp,
+	}
+
+	err = safeHandle(ctx, handler, delivered)
+	if err == nil {
+		if err := msg.Ack(); err != nil {
+			log.Printf("Failed to acknowledge message %d on %s: %v", meta.Sequence.Stream, msg.Subject, err)
+		}
+		return
+	}
+
+	if ctx.Err() != nil {
+		log.Printf("Stopped handling message %d on %s, leaving it to be delivered again: %v", meta.Sequence.Stream, msg.Subject, err)
+		msg.Nak()
+		return
+	}
+
+	deliveries := int(meta.NumDelivered)
+	if deliveries < m.options.MaxDeliver {
+		log.Printf("Failed to handle message %d on %s (delivery %d), retrying: %v", meta.Sequence.Stream, msg.Subject, deliveries, err)
+		msg.NakWithDelay(m.backoff(deliveries))
+		return
+	}
+
+	log.Printf("Moving message %d on %s to the dead-letter subject after %d deliveries: %v", meta.Sequence.Stream, msg.Subject, deliveries, err)
+	if err := m.deadLetter(msg, consumer, deliveries, err); err != nil {
+		log.Printf("Failed to move message %d on %s to the dead-letter subject, retrying: %v", meta.Sequence.Stream, msg.Subject, err)
+		msg.NakWithDelay(m.backoff(deliveries))
+		return
+	}
+	msg.Term()
+}
+
+// safeHandle calls the handler with the context, carrying the headers of the
+// message, turning a panic into an error so the message is redelivered
+func safeHandle(ctx context.Context, handler Handler, msg *Message) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panicked: %v", r)
+		}
+	}()
+
+	return handler(ContextWithHeader(ctx, msg.Header), msg)
+}
+
+// deadLetter publishes a copy of the message to the dead-letter subject,
+// with headers telling where it came from and why it failed
+func (m *JetStreamMessaging) deadLetter(msg *nats.Msg, consumer string, deliveries int, cause error) error {
+	dead := nats.NewMsg(m.options.DeadLetter + "." + msg.Subject)
+	dead.Data = msg.Data
+	for key, values := range msg.Header {
+		dead.Header[key] = values
+	}
+	dead.Header.Set(DeadLetterSubjectHeader, msg.Subject)
+	dead.Header.Set(DeadLetterConsumerHeader, consumer)
+	dead.Header.Set(DeadLetterDeliveriesHeader, strconv.Itoa(deliveries))
+	dead.Header.Set(DeadLetterErrorHeader, cause.Error())
+
+	ctx, cancel := context.WithTimeout(m.ctx, m.timeout)
+	defer cancel()
+
+	_, err := m.js.PublishMsg(dead, nats.Context(ctx))
+	return err
+}
+
+// backoff returns the delay before the redelivery following the given delivery
+func (m *JetStreamMessaging) backoff(deliveries int) time.Duration {
+	delay := m.options.RetryBackoff
+	for i := 1; i < deliveries && delay < m.options.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > m.options.MaxBackoff {
+		delay = m.options.MaxBackoff
+	}
+	return delay
+}
+
+// durableName derives a consumer name from a topic, which may not contain
+// the dots and wildcards of subjects
+func durableName(topic string) string {
+	return strings.NewReplacer(".", "_", "*", "any", ">", "all").Replace(topic)
+}
+
+// Close stops the consumers, cancelling the handling of the messages at hand,
+// waits for their handlers to return and closes the connection
+func (m *JetStreamMessaging) Close() error {
+	m.cancel()
+	m.wg.Wait()
+	m.nc.Close()
+	return nil
+}
@@ -0,0 +1,271 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+// testOptions keeps the backoff short, so redeliveries happen within a test
+var testOptions = JetStreamOptions{
+	Consumer:     "test",
+	MaxDeliver:   3,
+	AckWait:      30 * time.Second,
+	RetryBackoff: 50 * time.Millisecond,
+	MaxBackoff:   200 * time.Millisecond,
+	DeadLetter:   "dead",
+}
+
+// newTestJetStream starts a NATS server with JetStream in the process and
+// connects a JetStreamMessaging to it
+func newTestJetStream(t *testing.T) *JetStreamMessaging {
+	t.Helper()
+
+	ns, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      server.RANDOM_PORT,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ns.Start()
+	if !ns.ReadyForConnections(10 * time.Second) {
+		t.Fatal("NATS server did not start")
+	}
+	t.Cleanup(ns.Shutdown)
+
+	m, err := NewJetStreamMessaging(ns.ClientURL(), 5*time.Second, testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Close() })
+
+	return m
+}
+
+// deliveries records the deliveries a handler saw
+type deliveries struct {
+	mu    sync.Mutex
+	times []time.Time
+	count []int
+}
+
+func (d *deliveries) add(msg *Message) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.times = append(d.times, time.Now())
+	d.count = append(d.count, msg.Metadata.Deliveries)
+	return len(d.count)
+}
+
+func (d *deliveries) len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.count)
+}
+
+// eventually fails the test unless the condition holds within a few seconds
+func eventually(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// acknowledged reports whether the consumer of the queue group acknowledged
+// every message of the subject
+func acknowledged(t *testing.T, m *JetStreamMessaging, subject string, queue string) bool {
+	t.Helper()
+
+	info, err := m.js.ConsumerInfo("POSTS", durableName(m.options.Consumer+"_"+queue+"_"+subject))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.NumPending == 0 && info.NumAckPending == 0 && info.NumRedelivered == 0 && info.AckFloor.Stream > 0
+}
+
+func publish(t *testing.T, m *JetStreamMessaging, subject string) {
+	t.Helper()
+
+	err := m.Publish(context.Background(), NewMessage(context.Background(), subject, []byte("payload")))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJetStreamAcknowledgesHandledMessage(t *testing.T) {
+	m := newTestJetStream(t)
+
+	var seen deliveries
+	_, err := m.QueueSubscribe("post.created", "workers", func(ctx context.Context, msg *Message) error {
+		seen.add(msg)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publish(t, m, "post.created")
+
+	eventually(t, "the message to be acknowledged", func() bool {
+		return acknowledged(t, m, "post.created", "workers")
+	})
+	if seen.len() != 1 || seen.count[0] != 1 {
+		t.Fatalf("expected one delivery, got %v", seen.count)
+	}
+}
+
+func TestJetStreamRedeliversFailedMessageAfterBackoff(t *testing.T) {
+	m := newTestJetStream(t)
+
+	var seen deliveries
+	_, err := m.QueueSubscribe("post.updated", "workers", func(ctx context.Context, msg *Message) error {
+		if seen.add(msg) == 1 {
+			return errors.New("temporary failure")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publish(t, m, "post.updated")
+
+	eventually(t, "the redelivered message to be acknowledged", func() bool {
+		return seen.len() == 2 && acknowledged(t, m, "post.updated", "workers")
+	})
+	if seen.count[0] != 1 || seen.count[1] != 2 {
+		t.Fatalf("expected deliveries 1 and 2, got %v", seen.count)
+	}
+	if delay := seen.times[1].Sub(seen.times[0]); delay < testOptions.RetryBackoff {
+		t.Fatalf("redelivered after %v, before the backoff of %v", delay, testOptions.RetryBackoff)
+	}
+}
+
+func TestJetStreamMovesMessageToDeadLetterAfterMaxDeliver(t *testing.T) {
+	m := newTestJetStream(t)
+
+	var seen deliveries
+	_, err := m.QueueSubscribe("post.deleted", "workers", func(ctx context.Context, msg *Message) error {
+		seen.add(msg)
+		return errors.New("permanent failure")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publish(t, m, "post.deleted")
+
+	var dead *nats.RawStreamMsg
+	eventually(t, "the message to be moved to the dead-letter subject", func() bool {
+		dead, err = m.js.GetLastMsg("DEAD_LETTERS", "dead.post.deleted")
+		return err == nil
+	})
+
+	if string(dead.Data) != "payload" {
+		t.Errorf("dead letter carries %q, expected the payload", dead.Data)
+	}
+	expected := map[string]string{
+		DeadLetterSubjectHeader:    "post.deleted",
+		DeadLetterConsumerHeader:   "test_workers_post_deleted",
+		DeadLetterDeliveriesHeader: "3",
+		DeadLetterErrorHeader:      "permanent failure",
+	}
+	for key, value := range expected {
+		if got := dead.Header.Get(key); got != value {
+			t.Errorf("dead letter header %s is %q, expected %q", key, got, value)
+		}
+	}
+
+	// The terminated message is not delivered again
+	time.Sleep(4 * testOptions.MaxBackoff)
+	if seen.len() != testOptions.MaxDeliver {
+		t.Fatalf("expected %d deliveries, got %v", testOptions.MaxDeliver, seen.count)
+	}
+}
+
+func TestJetStreamRedeliversMessageWhoseHandlerPanicked(t *testing.T) {
+	m := newTestJetStream(t)
+
+	var seen deliveries
+	_, err := m.QueueSubscribe("post.restored", "workers", func(ctx context.Context, msg *Message) error {
+		if seen.add(msg) == 1 {
+			panic("handler bug")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publish(t, m, "post.restored")
+
+	eventually(t, "the redelivered message to be acknowledged", func() bool {
+		return seen.len() == 2 && acknowledged(t, m, "post.restored", "workers")
+	})
+}
+
+func TestJetStreamDrainCancelsHandlerAndRedeliversMessage(t *testing.T) {
+	m := newTestJetStream(t)
+
+	started := make(chan struct{})
+	sub, err := m.QueueSubscribe("post.purged", "workers", func(ctx context.Context, msg *Message) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publish(t, m, "post.purged")
+	<-started
+
+	drained := make(chan struct{})
+	go func() {
+		sub.Drain()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Drain waited for the handler instead of cancelling it")
+	}
+
+	var seen deliveries
+	_, err = m.QueueSubscribe("post.purged", "workers", func(ctx context.Context, msg *Message) error {
+		seen.add(msg)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, "the message to be delivered again and acknowledged", func() bool {
+		return seen.len() == 1 && acknowledged(t, m, "post.purged", "workers")
+	})
+}
+
+func TestSafeHandleTurnsPanicIntoError(t *testing.T) {
+	err := safeHandle(context.Background(), func(ctx context.Context, msg *Message) error {
+		panic("handler bug")
+	}, &Message{Subject: "post.created"})
+
+	if err == nil || err.Error() != "handler panicked: handler bug" {
+		t.Fatalf("expected the panic as an error, got %v", err)
+	}
+}
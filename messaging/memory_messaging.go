@@ -0,0 +1,267 @@
+package messaging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrClosed is returned when publishing or subscribing on a closed connection
+var ErrClosed = errors.New("messaging: connection closed")
+
+// MemoryMessaging is an in-process implementation of Messaging.
+// Topics follow NATS subject rules, including the "*" and ">" wildcards, and
+// like NATS every subscription receives its messages in order on its own
+// goroutine, asynchronously from the publisher. Messages are not stored, so
+// handler errors are only logged.
+type MemoryMessaging struct {
+	mu            sync.RWMutex
+	subscriptions []*memorySubscription
+	queues        map[string]*uint64 // Round-robin counter per subject and queue group
+	closed        bool
+}
+
+type memorySubscription struct {
+	owner    *MemoryMessaging
+	topic    string
+	queue    string
+	messages chan *Message
+	done     chan struct{}
+	stopped  int32 // Set by Unsubscribe to drop the messages not handled yet
+}
+
+// NewMemoryMessaging creates a new instance of MemoryMessaging
+func NewMemoryMessaging() Messaging {
+	return &MemoryMessaging{
+		queues: make(map[string]*uint64),
+	}
+}
+
+// Publish delivers a copy of the message to every matching subscription
+// outside of queue groups and to one subscription of every matching group
+func (m *MemoryMessaging) Publish(ctx context.Context, msg *Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+
+	var receivers []*memorySubscription
+	groups := make(map[string][]*memorySubscription)
+	for _, sub := range m.subscriptions {
+		if !subjectMatches(sub.topic, msg.Subject) {
+			continue
+		}
+		if sub.queue == "" {
+			receivers = append(receivers, sub)
+			continue
+		}
+		key := sub.topic + " " + sub.queue
+		groups[key] = append(groups[key], sub)
+	}
+	for key, members := range groups {
+		n := atomic.AddUint64(m.queues[key], 1)
+		receivers = append(receivers, members[n%uint64(len(members))])
+	}
+
+	for _, sub := range receivers {
+		select {
+		case sub.messages <- copyMessage(msg):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// copyMessage returns a delivered copy of the message, so receivers do not
+// share it with the publisher
+func copyMessage(msg *Message) *Message {
+	delivered := &Message{
+		Subject:  msg.Subject,
+		Reply:    msg.Reply,
+		Header:   Header{},
+		Data:     append([]byte(nil), msg.Data...),
+		Metadata: Metadata{Deliveries: 1},
+	}
+	for key, value := range msg.Header {
+		delivered.Header[key] = value
+	}
+	return delivered
+}
+
+// Subscribe registers a handler for every message published on a matching topic
+func (m *MemoryMessaging) Subscribe(topic string, handler Handler) (Subscription, error) {
+	return m.subscribe(topic, "", handler)
+}
+
+// QueueSubscribe registers a handler sharing the messages on a matching topic
+// with the other subscriptions of the queue group, taking turns
+func (m *MemoryMessaging) QueueSubscribe(topic string, queue string, handler Handler) (Subscription, error) {
+	return m.subscribe(topic, queue, handler)
+}
+
+func (m *MemoryMessaging) subscribe(topic string, queue string, handler Handler) (Subscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil, ErrClosed
+	}
+
+	sub := &memorySubscription{
+		owner:    m,
+		topic:    topic,
+		queue:    queue,
+		messages: make(chan *Message, 256),
+		done:     make(chan struct{}),
+	}
+
+	go func() {
+		defer close(sub.done)
+		for msg := range sub.messages {
+			if atomic.LoadInt32(&sub.stopped) == 1 {
+				continue
+			}
+
+			ctx := ContextWithHeader(context.Background(), msg.Header)
+			if err := handler(ctx, msg); err != nil {
+				log.Printf("Failed to handle message on %s: %v", msg.Subject, err)
+			}
+		}
+	}()
+
+	if queue != "" {
+		key := topic + " " + queue
+		if m.queues[key] == nil {
+			m.queues[key] = new(uint64)
+		}
+	}
+
+	m.subscriptions = append(m.subscriptions, sub)
+	return sub, nil
+}
+
+// Request publishes the message with a reply subject of its own and waits
+// for the first reply
+func (m *MemoryMessaging) Request(ctx context.Context, msg *Message, timeout time.Duration) (*Message, error) {
+	inbox := make([]byte, 12)
+	if _, err := rand.Read(inbox); err != nil {
+		return nil, err
+	}
+
+	replies := make(chan *Message, 1)
+	sub, err := m.Subscribe("_INBOX."+hex.EncodeToString(inbox), func(ctx context.Context, reply *Message) error {
+		select {
+		case replies <- reply:
+		default:
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer sub.Unsubscribe()
+
+	request := *msg
+	request.Reply = "_INBOX." + hex.EncodeToString(inbox)
+	if err := m.Publish(ctx, &request); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case reply := <-replies:
+		return reply, nil
+	case <-timer.C:
+		return nil, ErrTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// remove takes the subscription out of the delivery and reports whether it
+// still was subscribed
+func (m *MemoryMessaging) remove(sub *memorySubscription) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, s := range m.subscriptions {
+		if s == sub {
+			m.subscriptions = append(m.subscriptions[:i], m.subscriptions[i+1:]...)
+			close(sub.messages)
+			return true
+		}
+	}
+	return false
+}
+
+// Unsubscribe stops the subscription, dropping the messages not handled yet
+func (s *memorySubscription) Unsubscribe() error {
+	atomic.StoreInt32(&s.stopped, 1)
+	s.owner.remove(s)
+	return nil
+}
+
+// Drain stops the subscription once the messages already received were handled
+func (s *memorySubscription) Drain() error {
+	s.owner.remove(s)
+	<-s.done
+	return nil
+}
+
+// Close stops every subscription after its pending messages were handled
+func (m *MemoryMessaging) Close() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	subscriptions := m.subscriptions
+	m.subscriptions = nil
+	m.mu.Unlock()
+
+	for _, sub := range subscriptions {
+		close(sub.messages)
+		<-sub.done
+	}
+
+	return nil
+}
+
+// subjectMatches reports whether a subject matches a subscription pattern
+// using the NATS wildcard rules: "*" matches a single token and ">" matches
+// one or more trailing tokens.
+func subjectMatches(pattern, subject string) bool {
+	patternTokens := strings.Split(pattern, ".")
+	subjectTokens := strings.Split(subject, ".")
+
+	for i, token := range patternTokens {
+		if token == ">" {
+			return len(subjectTokens) > i
+		}
+		if i >= len(subjectTokens) {
+			return false
+		}
+		if token != "*" && token != subjectTokens[i] {
+			return false
+		}
+	}
+
+	return len(patternTokens) == len(subjectTokens)
+}
@@ -0,0 +1,81 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrTimeout is returned when a request got no reply in time
+var ErrTimeout = errors.New("messaging: request timed out")
+
+// Headers carried by the messages
+const (
+	CorrelationIDHeader = "Correlation-Id" // Ties the messages caused by one request together
+	TraceParentHeader   = "traceparent"    // W3C trace context
+	TraceStateHeader    = "tracestate"     // W3C trace context
+	MessageIDHeader     = "Message-Id"     // Unique ID, publishing it again is a no-op where supported
+)
+
+// propagatedHeaders are the headers passed on from a request or a handled
+// message to the messages published while handling it
+var propagatedHeaders = []string{CorrelationIDHeader, TraceParentHeader, TraceStateHeader}
+
+// Header holds the headers of a message
+type Header map[string]string
+
+// Message is a message published or delivered on a subject
+type Message struct {
+	Subject  string
+	Reply    string // Subject the reply to a request goes to
+	Header   Header
+	Data     []byte
+	Metadata Metadata // Set on delivered messages
+}
+
+// Metadata describes the delivery of a message
+type Metadata struct {
+	Deliveries int       // How often the message was delivered, 1 the first time
+	Sequence   uint64    // Position in the stream, for stored messages
+	Timestamp  time.Time // When the message was stored, for stored messages
+}
+
+// NewMessage creates a message for the subject carrying the headers of the
+// context, such as the correlation ID
+func NewMessage(ctx context.Context, subject string, data []byte) *Message {
+	return &Message{
+		Subject: subject,
+		Header:  HeaderFromContext(ctx),
+		Data:    data,
+	}
+}
+
+type headerKey struct{}
+
+// ContextWithHeader returns a context carrying the correlation ID and trace
+// context of the header, to be passed on to the messages published with it
+func ContextWithHeader(ctx context.Context, header Header) context.Context {
+	propagated := Header{}
+	for _, key := range propagatedHeaders {
+		if value := header[key]; value != "" {
+			propagated[key] = value
+		}
+	}
+	return context.WithValue(ctx, headerKey{}, propagated)
+}
+
+// HeaderFromContext returns a copy of the headers the context carries
+func HeaderFromContext(ctx context.Context) Header {
+	header := Header{}
+	if propagated, ok := ctx.Value(headerKey{}).(Header); ok {
+		for key, value := range propagated {
+			header[key] = value
+		}
+	}
+	return header
+}
+
+// CorrelationID returns the correlation ID the context carries
+func CorrelationID(ctx context.Context) string {
+	return HeaderFromContext(ctx)[CorrelationIDHeader]
+}
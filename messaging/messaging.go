@@ -1,8 +1,35 @@
 package messaging
 
+import (
+	"context"
+	"time"
+)
+
 // Messaging represents the messaging system interface
 type Messaging interface {
-	Publish(topic string, data []byte) error
-	Subscribe(topic string, handler func(data []byte)) error
+	// Publish sends the message to its subject
+	Publish(ctx context.Context, msg *Message) error
+	// Subscribe delivers every message on a matching subject to the handler
+	Subscribe(subject string, handler Handler) (Subscription, error)
+	// QueueSubscribe delivers every message on a matching subject to one of
+	// the subscriptions of the queue group, spreading them across replicas
+	QueueSubscribe(subject string, queue string, handler Handler) (Subscription, error)
+	// Request publishes the message and waits for the first reply, failing
+	// with ErrTimeout when none arrives in time
+	Request(ctx context.Context, msg *Message, timeout time.Duration) (*Message, error)
 	Close() error
 }
+
+// Handler handles a delivered message. Implementations that store messages
+// deliver a message again when its handler returns an error, and cancel the
+// context once the subscription ends; the others log the error.
+type Handler func(ctx context.Context, msg *Message) error
+
+// Subscription is the handle of a subscription
+type Subscription interface {
+	// Unsubscribe stops the delivery of messages right away
+	Unsubscribe() error
+	// Drain stops the delivery of new messages and returns once the messages
+	// already received were handled
+	Drain() error
+}
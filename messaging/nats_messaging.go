@@ -1,6 +1,11 @@
 package messaging
 
 import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
 	"github.com/nats-io/nats.go"
 )
 
@@ -9,9 +14,15 @@ type NatsMessaging struct {
 	nc *nats.Conn
 }
 
-// NewNatsMessaging creates a new instance of NatsMessaging
-func NewNatsMessaging(url string) (Messaging, error) {
-	nc, err := nats.Connect(url)
+// NewNatsMessaging creates a new instance of NatsMessaging.
+// The timeout bounds how long connecting to the server may take.
+func NewNatsMessaging(url string, timeout time.Duration) (Messaging, error) {
+	var opts []nats.Option
+	if timeout > 0 {
+		opts = append(opts, nats.Timeout(timeout))
+	}
+
+	nc, err := nats.Connect(url, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -19,17 +30,49 @@ func NewNatsMessaging(url string) (Messaging, error) {
 	return &NatsMessaging{nc: nc}, nil
 }
 
-// Publish publishes a message to a NATS topic
-func (n *NatsMessaging) Publish(topic string, data []byte) error {
-	return n.nc.Publish(topic, data)
+// Publish publishes a message to a NATS subject.
+// Nothing is published once the context has been cancelled.
+func (n *NatsMessaging) Publish(ctx context.Context, msg *Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return n.nc.PublishMsg(toNatsMsg(msg))
+}
+
+// Subscribe subscribes to a NATS subject and registers a message handler.
+// Core NATS does not redeliver, so handler errors are only logged.
+func (n *NatsMessaging) Subscribe(subject string, handler Handler) (Subscription, error) {
+	sub, err := n.nc.Subscribe(subject, natsHandler(handler))
+	if err != nil {
+		return nil, err
+	}
+	return &natsSubscription{sub: sub}, nil
+}
+
+// QueueSubscribe subscribes to a NATS subject as a member of the queue group
+func (n *NatsMessaging) QueueSubscribe(subject string, queue string, handler Handler) (Subscription, error) {
+	sub, err := n.nc.QueueSubscribe(subject, queue, natsHandler(handler))
+	if err != nil {
+		return nil, err
+	}
+	return &natsSubscription{sub: sub}, nil
 }
 
-// Subscribe subscribes to a NATS topic and registers a message handler
-func (n *NatsMessaging) Subscribe(topic string, handler func(data []byte)) error {
-	_, err := n.nc.Subscribe(topic, func(msg *nats.Msg) {
-		handler(msg.Data)
-	})
-	return err
+// Request publishes the message with a NATS inbox as reply subject and waits
+// for the first reply
+func (n *NatsMessaging) Request(ctx context.Context, msg *Message, timeout time.Duration) (*Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	reply, err := n.nc.RequestMsgWithContext(ctx, toNatsMsg(msg))
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, nats.ErrTimeout) {
+		return nil, ErrTimeout
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return fromNatsMsg(reply), nil
 }
 
 // Close closes the NATS connection
@@ -37,3 +80,65 @@ func (n *NatsMessaging) Close() error {
 	n.nc.Close()
 	return nil
 }
+
+// natsHandler adapts a Handler to the NATS callbacks
+func natsHandler(handler Handler) nats.MsgHandler {
+	return func(m *nats.Msg) {
+		msg := fromNatsMsg(m)
+		msg.Metadata.Deliveries = 1
+
+		ctx := ContextWithHeader(context.Background(), msg.Header)
+		if err := handler(ctx, msg); err != nil {
+			log.Printf("Failed to handle message on %s: %v", msg.Subject, err)
+		}
+	}
+}
+
+// natsSubscription is the Subscription of a plain NATS subscription
+type natsSubscription struct {
+	sub *nats.Subscription
+}
+
+func (s *natsSubscription) Unsubscribe() error {
+	return s.sub.Unsubscribe()
+}
+
+// Drain waits until the messages already received were handled, which NATS
+// does in the background
+func (s *natsSubscription) Drain() error {
+	if err := s.sub.Drain(); err != nil {
+		return err
+	}
+	for s.sub.IsValid() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+// toNatsMsg converts a message into its NATS form
+func toNatsMsg(msg *Message) *nats.Msg {
+	m := nats.NewMsg(msg.Subject)
+	m.Reply = msg.Reply
+	m.Data = msg.Data
+	for key, value := range msg.Header {
+		m.Header.Set(key, value)
+	}
+	return m
+}
+
+// fromNatsMsg converts a received NATS message. Header keys keep the case
+// they were sent with.
+func fromNatsMsg(m *nats.Msg) *Message {
+	msg := &Message{
+		Subject: m.Subject,
+		Reply:   m.Reply,
+		Header:  Header{},
+		Data:    m.Data,
+	}
+	for key, values := range m.Header {
+		if len(values) > 0 {
+			msg.Header[key] = values[0]
+		}
+	}
+	return msg
+}
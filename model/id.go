@@ -0,0 +1,20 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"main.go/errs"
+)
+
+// ParseID converts a hex string into an ObjectID, reporting malformed input
+// as a validation error on the "id" field
+func ParseID(id string) (primitive.ObjectID, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return primitive.NilObjectID, errs.Validation(errs.CodeInvalidID, "invalid ID "+id, errs.FieldError{
+			Field:   "id",
+			Message: "must be a 24 character hexadecimal ObjectID",
+		})
+	}
+
+	return objID, nil
+}
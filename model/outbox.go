@@ -0,0 +1,22 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// OutboxEvent is a message waiting to be published. It is stored in the same
+// transaction as the change it announces, so neither exists without the other.
+type OutboxEvent struct {
+	ID            primitive.ObjectID `json:"_id" bson:"_id,omitempty"`
+	Subject       string             `json:"subject" bson:"subject"`
+	Key           string             `json:"key" bson:"key"`                           // Aggregate the event belongs to; events with the same key are published in order
+	Header        map[string]string  `json:"header,omitempty" bson:"header,omitempty"` // Message headers, such as the correlation ID of the request
+	Data          []byte             `json:"data" bson:"data"`
+	CreatedAt     time.Time          `json:"created_at" bson:"created_at"`
+	Attempts      int                `json:"attempts" bson:"attempts"`                         // Failed attempts to publish the event
+	NextAttemptAt time.Time          `json:"next_attempt_at" bson:"next_attempt_at"`           // The event is not published before
+	LastError     string             `json:"last_error,omitempty" bson:"last_error,omitempty"` // Why the last attempt failed
+	DeliveredAt   *time.Time         `json:"delivered_at,omitempty" bson:"delivered_at,omitempty"`
+}
@@ -0,0 +1,151 @@
+package model
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"main.go/errs"
+)
+
+const (
+	// DefaultPageLimit is the page size used when the client does not ask for one
+	DefaultPageLimit = 20
+	// MaxPageLimit is the largest page size a client may ask for
+	MaxPageLimit = 100
+)
+
+// PageRequest describes which slice of a collection should be returned
+type PageRequest struct {
+	Limit  int    // Maximum number of items to return
+	Cursor string // Opaque cursor taken from the NextCursor of a previous page
+	Sort   string // Field to sort by, prefixed with "-" for descending order
+}
+
+// SortField returns the field to sort by and whether the order is descending.
+// Results are sorted by "_id" when no sort field was requested.
+func (p PageRequest) SortField() (string, bool) {
+	if p.Sort == "" {
+		return "_id", false
+	}
+	if strings.HasPrefix(p.Sort, "-") {
+		return strings.TrimPrefix(p.Sort, "-"), true
+	}
+	return strings.TrimPrefix(p.Sort, "+"), false
+}
+
+// Validate checks that the sort field is one of the allowed fields and that
+// the cursor, if any, is well formed
+func (p PageRequest) Validate(sortFields []string) error {
+	field, _ := p.SortField()
+
+	allowed := false
+	for _, f := range sortFields {
+		if f == field {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return errs.Validation(errs.CodeInvalidQuery, fmt.Sprintf("cannot sort by %q", field), errs.FieldError{
+			Field:   "sort",
+			Message: "must be one of " + strings.Join(sortFields, ", ") + ", optionally prefixed with -",
+		})
+	}
+
+	if p.Cursor != "" {
+		if _, err := DecodeCursor(p.Cursor); err != nil {
+			return errs.Validation(errs.CodeInvalidQuery, "invalid cursor", errs.FieldError{
+				Field:   "cursor",
+				Message: "must be a next_cursor value returned by a previous page",
+			})
+		}
+	}
+
+	return nil
+}
+
+// PageLimit returns the requested limit clamped to the allowed range
+func (p PageRequest) PageLimit() int {
+	if p.Limit <= 0 {
+		return DefaultPageLimit
+	}
+	if p.Limit > MaxPageLimit {
+		return MaxPageLimit
+	}
+	return p.Limit
+}
+
+// Cursor is the decoded form of the opaque pagination cursor.
+// It remembers the ObjectID of the last returned document and, when sorting
+// by another field, the value of that field so the next page can resume after it.
+type Cursor struct {
+	ID    primitive.ObjectID `json:"id"`
+	Value interface{}        `json:"v,omitempty"`
+}
+
+// EncodeCursor turns a cursor into the opaque string handed out to clients
+func EncodeCursor(c Cursor) string {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor parses a cursor previously produced by EncodeCursor
+func DecodeCursor(s string) (Cursor, error) {
+	var c Cursor
+
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, fmt.Errorf("invalid cursor: %v", err)
+	}
+
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, fmt.Errorf("invalid cursor: %v", err)
+	}
+
+	if c.ID.IsZero() {
+		return c, fmt.Errorf("invalid cursor: missing id")
+	}
+
+	return c, nil
+}
+
+// PostFilter holds the field filters supported by the post listing
+type PostFilter struct {
+	AuthorID      primitive.ObjectID // Posts written by this user
+	Title         string             // Exact title match
+	TitleContains string             // Case-insensitive substring of the title
+	BodyContains  string             // Case-insensitive substring of the body
+	Deleted       bool               // List the posts in the trash instead of the others
+}
+
+// UserFilter holds the field filters supported by the user listing
+type UserFilter struct {
+	UserName         string // Username match, regardless of case
+	Email            string // Email match, regardless of case
+	FullNameContains string // Case-insensitive substring of the full name
+	Deleted          bool   // List the users in the trash instead of the others
+}
+
+// PostPage is a single page of posts
+type PostPage struct {
+	Data       []Post `json:"data"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// UserPage is a single page of users
+type UserPage struct {
+	Data       []User `json:"data"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// PostSortFields lists the fields posts can be sorted by
+var PostSortFields = []string{"_id", "title"}
+
+// UserSortFields lists the fields users can be sorted by
+var UserSortFields = []string{"_id", "username", "fullname", "email"}
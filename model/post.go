@@ -1,10 +1,64 @@
 package model
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"main.go/errs"
+)
+
+const (
+	// MaxPostTags is the most tags a post may have
+	MaxPostTags = 10
+	// MaxTagLength is the longest tag, in characters
+	MaxTagLength = 50
+)
+
+// PostPatchFields are the fields of a post that PATCH may change
+var PostPatchFields = []string{"title", "body", "tags"}
 
 // Post represents a single post
 type Post struct {
-	ID    primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
-	Title string             `json:"title"`
-	Body  string             `json:"body"`
+	ID        primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Title     string             `json:"title" validate:"required,max=200"`
+	Body      string             `json:"body" validate:"max=50000"`
+	Tags      []string           `json:"tags,omitempty" bson:"tags,omitempty"`           // Lowercase labels, see NormalizeTags
+	AuthorID  primitive.ObjectID `json:"author_id,omitempty" bson:"author_id,omitempty"` // User who wrote the post
+	Version   int64              `json:"version" bson:"version"`                         // Incremented on every change
+	CreatedAt time.Time          `json:"created_at" bson:"created_at,omitempty"`
+	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at,omitempty"`
+	DeletedAt *time.Time         `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"` // Set while the post is in the trash
+}
+
+// NormalizeTags trims and lowercases the tags and drops empty and repeated
+// ones, keeping the order. It fails for too many or too long tags.
+func NormalizeTags(tags []string) ([]string, error) {
+	var normalized []string
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if utf8.RuneCountInString(tag) > MaxTagLength {
+			return nil, errs.Validation(errs.CodeValidationFailed, "the request contains invalid fields", errs.FieldError{
+				Field:   "tags",
+				Message: fmt.Sprintf("must each be at most %d characters long", MaxTagLength),
+			})
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+
+	if len(normalized) > MaxPostTags {
+		return nil, errs.Validation(errs.CodeValidationFailed, "the request contains invalid fields", errs.FieldError{
+			Field:   "tags",
+			Message: fmt.Sprintf("must not contain more than %d tags", MaxPostTags),
+		})
+	}
+
+	return normalized, nil
 }
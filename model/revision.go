@@ -0,0 +1,30 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PostRevision is an immutable snapshot of a post as one of its changes left it
+type PostRevision struct {
+	PostID    primitive.ObjectID `json:"post_id" bson:"post_id"`
+	Number    int64              `json:"number" bson:"number"` // Version of the post the revision holds
+	Title     string             `json:"title" bson:"title"`
+	Body      string             `json:"body" bson:"body"`
+	Tags      []string           `json:"tags,omitempty" bson:"tags,omitempty"`
+	EditorID  primitive.ObjectID `json:"editor_id,omitempty" bson:"editor_id,omitempty"` // User who made the change
+	Fields    []string           `json:"fields" bson:"fields"`                           // Fields the change touched
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// PostRevisionDiff holds the line by line differences between two revisions
+// of a post in unified diff format, leaving out the fields that are equal
+type PostRevisionDiff struct {
+	PostID primitive.ObjectID `json:"post_id"`
+	From   int64              `json:"from"`
+	To     int64              `json:"to"`
+	Title  string             `json:"title,omitempty"`
+	Body   string             `json:"body,omitempty"`
+	Tags   string             `json:"tags,omitempty"` // One tag per line
+}
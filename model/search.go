@@ -0,0 +1,255 @@
+package model
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"main.go/errs"
+)
+
+// MaxSearchWindow is the furthest hit that offset pagination may reach, as
+// the search engines refuse to go further. A page reads from plus limit hits
+// and one more, telling whether there is a next page. Later hits are reached
+// with the cursor.
+const MaxSearchWindow = 10000
+
+// SearchScore sorts search results by relevance
+const SearchScore = "_score"
+
+const (
+	// DefaultSuggestLimit is the number of suggestions returned when the client does not ask for one
+	DefaultSuggestLimit = 5
+	// MaxSuggestLimit is the most suggestions a client may ask for
+	MaxSuggestLimit = 20
+	// MaxSuggestPrefix is the longest prefix, in characters, suggestions are made for
+	MaxSuggestPrefix = 100
+)
+
+const (
+	// DefaultRelatedLimit is the number of related posts returned when the client does not ask for one
+	DefaultRelatedLimit = 5
+	// MaxRelatedLimit is the most related posts a client may ask for
+	MaxRelatedLimit = 20
+)
+
+// SearchRequest describes a page of search results
+type SearchRequest struct {
+	Query  string
+	Limit  int      // Maximum number of hits to return
+	From   int      // Number of hits to skip; cannot be combined with a cursor
+	Cursor string   // Opaque cursor taken from the NextCursor of a previous page
+	Sort   []string // Fields to sort by, each prefixed with "-" for descending order
+}
+
+// SortFields returns the fields to sort by and whether each is sorted in
+// descending order. Results are sorted by relevance, best first, when no
+// sort field was requested.
+func (r SearchRequest) SortFields() ([]string, []bool) {
+	if len(r.Sort) == 0 {
+		return []string{SearchScore}, []bool{true}
+	}
+
+	fields := make([]string, len(r.Sort))
+	desc := make([]bool, len(r.Sort))
+	for i, field := range r.Sort {
+		desc[i] = strings.HasPrefix(field, "-")
+		fields[i] = strings.TrimLeft(field, "+-")
+	}
+	return fields, desc
+}
+
+// Validate checks the sort fields against the allowed fields, the window of
+// offset pagination and that the cursor, if any, is well formed
+func (r SearchRequest) Validate(sortFields []string) error {
+	fields, _ := r.SortFields()
+	for _, field := range fields {
+		allowed := false
+		for _, f := range sortFields {
+			if f == field {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return errs.Validation(errs.CodeInvalidQuery, fmt.Sprintf("cannot sort by %q", field), errs.FieldError{
+				Field:   "sort",
+				Message: "must be a comma separated list of " + strings.Join(sortFields, ", ") + ", each optionally prefixed with -",
+			})
+		}
+	}
+
+	if r.From+r.PageLimit()+1 > MaxSearchWindow {
+		return errs.Validation(errs.CodeInvalidQuery, "search window too large", errs.FieldError{
+			Field:   "from",
+			Message: fmt.Sprintf("from plus limit must be less than %d, use the cursor to page further", MaxSearchWindow),
+		})
+	}
+
+	if r.Cursor != "" {
+		if r.From > 0 {
+			return errs.Validation(errs.CodeInvalidQuery, "from cannot be combined with a cursor", errs.FieldError{
+				Field:   "from",
+				Message: "must be omitted when a cursor is given",
+			})
+		}
+		if _, err := DecodeSearchCursor(r.Cursor); err != nil {
+			return errs.Validation(errs.CodeInvalidQuery, "invalid cursor", errs.FieldError{
+				Field:   "cursor",
+				Message: "must be a next_cursor value returned by a previous page",
+			})
+		}
+	}
+
+	return nil
+}
+
+// PageLimit returns the requested limit clamped to the allowed range
+func (r SearchRequest) PageLimit() int {
+	return PageRequest{Limit: r.Limit}.PageLimit()
+}
+
+// EncodeSearchCursor turns the sort values of the last hit of a page into
+// the opaque string handed out to clients
+func EncodeSearchCursor(values []interface{}) string {
+	data, err := json.Marshal(values)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeSearchCursor parses a cursor previously produced by EncodeSearchCursor.
+// Numbers are kept as json.Number, so large ones survive the round trip.
+func DecodeSearchCursor(s string) ([]interface{}, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %v", err)
+	}
+
+	var values []interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&values); err != nil {
+		return nil, fmt.Errorf("invalid cursor: %v", err)
+	}
+
+	if len(values) == 0 {
+		return nil, fmt.Errorf("invalid cursor: no sort values")
+	}
+
+	return values, nil
+}
+
+// PostSearchHit is a post matching a search
+type PostSearchHit struct {
+	Post
+	Score      float64             `json:"_score"`
+	Highlights map[string][]string `json:"_highlights,omitempty"` // Matching snippets per field, terms wrapped in <em> tags
+}
+
+// UserSearchHit is a user matching a search
+type UserSearchHit struct {
+	User
+	Score      float64             `json:"_score"`
+	Highlights map[string][]string `json:"_highlights,omitempty"` // Matching snippets per field, terms wrapped in <em> tags
+}
+
+// PostSearchPage is a single page of post search results
+type PostSearchPage struct {
+	Data       []PostSearchHit          `json:"data"`
+	Total      int64                    `json:"total"` // Number of posts matching the query
+	NextCursor string                   `json:"next_cursor,omitempty"`
+	Facets     map[string][]FacetBucket `json:"facets"` // Counts of all matching posts, by the filter parameter narrowing them down
+}
+
+// FacetBucket counts the posts matching a search that have one value of a facet
+type FacetBucket struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// PostSearchFilter narrows a post search down to the facet values picked
+type PostSearchFilter struct {
+	AuthorID primitive.ObjectID // Posts written by this user
+	Tags     []string           // Posts having every one of these tags
+	Month    time.Time          // Posts created in the month starting at this time
+	Created  string             // Posts created within one of PostCreatedRanges
+}
+
+// PostCreatedRanges are the periods posts are counted in by how recently they
+// were created, from the newest
+var PostCreatedRanges = []string{"past_week", "past_month", "past_year", "older"}
+
+// UserSearchPage is a single page of user search results
+type UserSearchPage struct {
+	Data       []UserSearchHit `json:"data"`
+	Total      int64           `json:"total"` // Number of users matching the query
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+// PostSearchSortFields lists the fields post search results can be sorted by
+var PostSearchSortFields = []string{SearchScore, "title", "created_at", "updated_at"}
+
+// UserSearchSortFields lists the fields user search results can be sorted by
+var UserSearchSortFields = []string{SearchScore, "username", "fullname", "created_at"}
+
+// SuggestRequest asks for completions of what a user typed
+type SuggestRequest struct {
+	Prefix string
+	Limit  int // Maximum number of suggestions to return
+}
+
+// Validate checks that there is a prefix and that it is not too long
+func (r SuggestRequest) Validate() error {
+	if strings.TrimSpace(r.Prefix) == "" {
+		return errs.Validation(errs.CodeInvalidQuery, "missing prefix", errs.FieldError{
+			Field:   "q",
+			Message: "must not be empty",
+		})
+	}
+	if len([]rune(r.Prefix)) > MaxSuggestPrefix {
+		return errs.Validation(errs.CodeInvalidQuery, "prefix too long", errs.FieldError{
+			Field:   "q",
+			Message: fmt.Sprintf("must not be longer than %d characters", MaxSuggestPrefix),
+		})
+	}
+	return nil
+}
+
+// SuggestLimit returns the requested limit clamped to the allowed range
+func (r SuggestRequest) SuggestLimit() int {
+	if r.Limit <= 0 {
+		return DefaultSuggestLimit
+	}
+	if r.Limit > MaxSuggestLimit {
+		return MaxSuggestLimit
+	}
+	return r.Limit
+}
+
+// Suggestion completes what a user typed with the value of a post or user
+type Suggestion struct {
+	ID   string `json:"_id"`  // Post or user the text was taken from
+	Text string `json:"text"` // Title of the post or username of the user
+}
+
+// RelatedRequest asks for the posts related to a post
+type RelatedRequest struct {
+	Limit int // Maximum number of posts to return
+}
+
+// RelatedLimit returns the requested limit clamped to the allowed range
+func (r RelatedRequest) RelatedLimit() int {
+	if r.Limit <= 0 {
+		return DefaultRelatedLimit
+	}
+	if r.Limit > MaxRelatedLimit {
+		return MaxRelatedLimit
+	}
+	return r.Limit
+}
@@ -1,11 +1,38 @@
 package model
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Roles defined by the default authorization policy
+const (
+	RoleAdmin  = "admin"  // May do everything
+	RoleEditor = "editor" // May moderate the posts of everybody
+	RoleReader = "reader" // May manage their own posts and account
+)
+
+// UserPatchFields are the fields of a user that PATCH may change
+var UserPatchFields = []string{"fullname", "username", "email"}
 
 // User represents a user
 type User struct {
-	ID       primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
-	FullName string             `json:"fullname" bson:"fullname"`
-	UserName string             `json:"username" bson:"username"`
-	Email    string             `json:"email" bson:"email"`
+	ID           primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	FullName     string             `json:"fullname" bson:"fullname" validate:"max=100"`
+	UserName     string             `json:"username" bson:"username" validate:"required,min=3,max=32,username"`
+	Email        string             `json:"email" bson:"email" validate:"required,email,max=254"`
+	Role         string             `json:"role,omitempty" bson:"role,omitempty"`
+	PasswordHash string             `json:"-" bson:"password_hash,omitempty"` // Never serialized to clients
+	Version      int64              `json:"version" bson:"version"`           // Incremented on every change
+	CreatedAt    time.Time          `json:"created_at" bson:"created_at,omitempty"`
+	UpdatedAt    time.Time          `json:"updated_at" bson:"updated_at,omitempty"`
+	DeletedAt    *time.Time         `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"` // Set while the user is in the trash
+}
+
+// NormalizeEmail lowercases the email address, so addresses differing only in
+// case belong to a single user
+func NormalizeEmail(email string) string {
+	return strings.ToLower(email)
 }
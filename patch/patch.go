@@ -0,0 +1,284 @@
+// Package patch applies RFC 7396 JSON Merge Patch and RFC 6902 JSON Patch
+// documents to the JSON representation of a model.
+package patch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"main.go/errs"
+)
+
+// Media types of the supported patch formats
+const (
+	MergePatchType = "application/merge-patch+json"
+	JSONPatchType  = "application/json-patch+json"
+)
+
+// Patch is a patch document together with its format
+type Patch struct {
+	MediaType string // MergePatchType or JSONPatchType
+	Document  []byte
+}
+
+// operation is a single step of a JSON Patch document
+type operation struct {
+	Op    string           `json:"op"`
+	Path  string           `json:"path"`
+	From  string           `json:"from"`
+	Value *json.RawMessage `json:"value"`
+}
+
+// Apply patches v, a pointer to a struct, and returns the JSON names of the
+// top-level fields that changed, sorted. Changing a field that is not allowed
+// fails. v is decoded afresh from the patched document, so fields the patch
+// removed end up empty and fields without a JSON representation are cleared.
+func (p Patch) Apply(v interface{}, allowed []string) ([]string, error) {
+	original, err := toDocument(v)
+	if err != nil {
+		return nil, err
+	}
+	target, err := toDocument(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var patched interface{}
+	switch p.MediaType {
+	case MergePatchType:
+		patched, err = applyMergePatch(target, p.Document)
+	case JSONPatchType:
+		patched, err = applyJSONPatch(target, p.Document)
+	default:
+		return nil, errs.UnsupportedMediaType(errs.CodeUnsupportedMediaType,
+			fmt.Sprintf("PATCH requires %s or %s", MergePatchType, JSONPatchType))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	document, ok := patched.(map[string]interface{})
+	if !ok {
+		return nil, invalid("the patched document must be a JSON object")
+	}
+
+	changed := changedFields(original, document)
+	if err := checkAllowed(changed, allowed); err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+
+	fresh := reflect.New(reflect.TypeOf(v).Elem())
+	if err := json.Unmarshal(data, fresh.Interface()); err != nil {
+		return nil, invalid("the patched document does not have the expected types: " + err.Error())
+	}
+	reflect.ValueOf(v).Elem().Set(fresh.Elem())
+
+	return changed, nil
+}
+
+// applyMergePatch merges the patch into the target as described in RFC 7396
+func applyMergePatch(target interface{}, document []byte) (interface{}, error) {
+	var patch interface{}
+	if err := json.Unmarshal(document, &patch); err != nil {
+		return nil, invalid("the merge patch is not valid JSON: " + err.Error())
+	}
+
+	return mergePatch(target, patch), nil
+}
+
+func mergePatch(target, patch interface{}) interface{} {
+	members, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	result, ok := target.(map[string]interface{})
+	if !ok {
+		result = make(map[string]interface{})
+	}
+
+	for name, value := range members {
+		if value == nil {
+			delete(result, name)
+		} else {
+			result[name] = mergePatch(result[name], value)
+		}
+	}
+
+	return result
+}
+
+// applyJSONPatch runs the operations of an RFC 6902 document in order. The
+// document is applied entirely or not at all.
+func applyJSONPatch(target interface{}, document []byte) (interface{}, error) {
+	var operations []operation
+	decoder := json.NewDecoder(bytes.NewReader(document))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&operations); err != nil {
+		return nil, invalid("the JSON patch is not a valid array of operations: " + err.Error())
+	}
+
+	for i, op := range operations {
+		var err error
+		target, err = applyOperation(target, op)
+		if err != nil {
+			// Failed tests are errors of their own; everything else is a bad patch
+			if _, ok := err.(*errs.Error); ok {
+				return nil, err
+			}
+			return nil, invalid(fmt.Sprintf("operation %d (%s %s) failed: %v", i, op.Op, op.Path, err))
+		}
+	}
+
+	return target, nil
+}
+
+func applyOperation(target interface{}, op operation) (interface{}, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, fmt.Errorf("value is required")
+		}
+		var value interface{}
+		if err := json.Unmarshal(*op.Value, &value); err != nil {
+			return nil, err
+		}
+
+		switch op.Op {
+		case "add":
+			return put(target, path, value, false)
+		case "replace":
+			return put(target, path, value, true)
+		default:
+			current, err := get(target, path)
+			if err != nil {
+				return nil, err
+			}
+			if !reflect.DeepEqual(current, value) {
+				return nil, errs.Conflict(errs.CodePatchTestFailed, fmt.Sprintf("the test of %s failed", op.Path))
+			}
+			return target, nil
+		}
+
+	case "remove":
+		return remove(target, path)
+
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		value, err := get(target, from)
+		if err != nil {
+			return nil, err
+		}
+
+		if op.Op == "copy" {
+			// Copies must not share nested objects with the original
+			value, err = deepCopy(value)
+			if err != nil {
+				return nil, err
+			}
+			return put(target, path, value, false)
+		}
+
+		if strings.HasPrefix(op.Path+"/", op.From+"/") && op.Path != op.From {
+			return nil, fmt.Errorf("cannot move %s into one of its children", op.From)
+		}
+		target, err = remove(target, from)
+		if err != nil {
+			return nil, err
+		}
+		return put(target, path, value, false)
+
+	default:
+		return nil, fmt.Errorf("unknown operation %q", op.Op)
+	}
+}
+
+// toDocument returns the generic JSON representation of v
+func toDocument(v interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var document map[string]interface{}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+
+	return document, nil
+}
+
+func deepCopy(value interface{}) (interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var copied interface{}
+	err = json.Unmarshal(data, &copied)
+	return copied, err
+}
+
+// changedFields lists the top-level members that differ between the documents
+func changedFields(before, after map[string]interface{}) []string {
+	var changed []string
+	for name, value := range after {
+		if !reflect.DeepEqual(before[name], value) {
+			changed = append(changed, name)
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+
+	sort.Strings(changed)
+	return changed
+}
+
+// checkAllowed rejects changes to fields that may not be patched
+func checkAllowed(changed, allowed []string) error {
+	var fields []errs.FieldError
+	for _, name := range changed {
+		if !contains(allowed, name) {
+			fields = append(fields, errs.FieldError{Field: name, Message: "cannot be changed"})
+		}
+	}
+
+	if len(fields) > 0 {
+		return errs.Validation(errs.CodeInvalidPatch, "the patch changes fields that cannot be changed", fields...)
+	}
+
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func invalid(message string) error {
+	return errs.Validation(errs.CodeInvalidPatch, message)
+}
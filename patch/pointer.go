@@ -0,0 +1,157 @@
+package patch
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parsePointer splits an RFC 6901 JSON Pointer into its unescaped reference tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("path %q must start with /", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+
+	return tokens, nil
+}
+
+// arrayIndex parses an array index token; size is the highest index allowed
+func arrayIndex(token string, size int) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i > size || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	return i, nil
+}
+
+// get returns the value at the location of the tokens
+func get(node interface{}, tokens []string) (interface{}, error) {
+	for _, token := range tokens {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			child, ok := n[token]
+			if !ok {
+				return nil, fmt.Errorf("member %q does not exist", token)
+			}
+			node = child
+		case []interface{}:
+			i, err := arrayIndex(token, len(n)-1)
+			if err != nil {
+				return nil, err
+			}
+			node = n[i]
+		default:
+			return nil, fmt.Errorf("cannot descend into a scalar at %q", token)
+		}
+	}
+
+	return node, nil
+}
+
+// put stores the value at the location of the tokens and returns the updated
+// node. With replace set the location must exist already; otherwise array
+// elements are inserted, and "-" appends to an array.
+func put(node interface{}, tokens []string, value interface{}, replace bool) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	token, rest := tokens[0], tokens[1:]
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		child, ok := n[token]
+		if !ok && (replace || len(rest) > 0) {
+			return nil, fmt.Errorf("member %q does not exist", token)
+		}
+
+		updated, err := put(child, rest, value, replace)
+		if err != nil {
+			return nil, err
+		}
+		n[token] = updated
+		return n, nil
+
+	case []interface{}:
+		if len(rest) == 0 && !replace {
+			if token == "-" {
+				return append(n, value), nil
+			}
+			i, err := arrayIndex(token, len(n))
+			if err != nil {
+				return nil, err
+			}
+			n = append(n, nil)
+			copy(n[i+1:], n[i:])
+			n[i] = value
+			return n, nil
+		}
+
+		i, err := arrayIndex(token, len(n)-1)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := put(n[i], rest, value, replace)
+		if err != nil {
+			return nil, err
+		}
+		n[i] = updated
+		return n, nil
+
+	default:
+		return nil, fmt.Errorf("cannot descend into a scalar at %q", token)
+	}
+}
+
+// remove deletes the value at the location of the tokens and returns the updated node
+func remove(node interface{}, tokens []string) (interface{}, error) {
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("cannot remove the whole document")
+	}
+	token, rest := tokens[0], tokens[1:]
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		child, ok := n[token]
+		if !ok {
+			return nil, fmt.Errorf("member %q does not exist", token)
+		}
+		if len(rest) == 0 {
+			delete(n, token)
+			return n, nil
+		}
+
+		updated, err := remove(child, rest)
+		if err != nil {
+			return nil, err
+		}
+		n[token] = updated
+		return n, nil
+
+	case []interface{}:
+		i, err := arrayIndex(token, len(n)-1)
+		if err != nil {
+			return nil, err
+		}
+		if len(rest) == 0 {
+			return append(n[:i], n[i+1:]...), nil
+		}
+
+		updated, err := remove(n[i], rest)
+		if err != nil {
+			return nil, err
+		}
+		n[i] = updated
+		return n, nil
+
+	default:
+		return nil, fmt.Errorf("cannot descend into a scalar at %q", token)
+	}
+}
@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	database "main.go/database/models"
+	"main.go/errs"
+)
+
+var (
+	// ErrPostNotFound is returned when no post has the requested ID
+	ErrPostNotFound = errs.NotFound(errs.CodePostNotFound, "post not found")
+	// ErrUserNotFound is returned when no user has the requested ID or username
+	ErrUserNotFound = errs.NotFound(errs.CodeUserNotFound, "user not found")
+	// ErrRevisionNotFound is returned when a post has no revision with the requested number
+	ErrRevisionNotFound = errs.NotFound(errs.CodeRevisionNotFound, "revision not found")
+	// ErrUsernameTaken is returned when another user already has the username
+	ErrUsernameTaken = errs.Conflict(errs.CodeUsernameTaken, "the username is already taken")
+	// ErrEmailTaken is returned when another user already has the email address
+	ErrEmailTaken = errs.Conflict(errs.CodeEmailTaken, "the email address is already in use")
+	// ErrAlreadyExists is returned for any other duplicate key
+	ErrAlreadyExists = errs.Conflict(errs.CodeAlreadyExists, "the resource already exists")
+	// ErrVersionMismatch is returned when a write expected another version than the stored one
+	ErrVersionMismatch = errs.PreconditionFailed(errs.CodeVersionMismatch, "the resource was changed since the given version")
+)
+
+// duplicateIndex extracts the name of the violated index from a duplicate key error
+var duplicateIndex = regexp.MustCompile(`index: (\S+)`)
+
+// translate turns storage errors into domain errors. Missing documents become
+// the given not-found error and duplicate keys a conflict named after the
+// violated unique index. Stale versions fail the precondition; anything else is passed on unchanged.
+func translate(err error, notFound *errs.Error) error {
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return notFound.Wrap(err)
+	case mongo.IsDuplicateKeyError(err):
+		return duplicate(err)
+	case errors.Is(err, database.ErrVersionMismatch):
+		return ErrVersionMismatch.Wrap(err)
+	}
+	return err
+}
+
+// duplicate maps a duplicate key error to the conflict of the violated index
+func duplicate(err error) error {
+	var index string
+	if match := duplicateIndex.FindStringSubmatch(err.Error()); match != nil {
+		index = match[1]
+	}
+
+	switch {
+	case strings.HasPrefix(index, "username"):
+		return ErrUsernameTaken.Wrap(err)
+	case strings.HasPrefix(index, "email"):
+		return ErrEmailTaken.Wrap(err)
+	}
+	return ErrAlreadyExists.Wrap(err)
+}
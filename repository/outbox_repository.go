@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	database "main.go/database/models"
+	"main.go/model"
+)
+
+// OutboxRepository handles the outbox data access and the transactions the
+// events are stored in
+type OutboxRepository struct {
+	db         database.OutboxDatabase
+	transactor database.Transactor
+}
+
+// NewOutboxRepository creates a new OutboxRepository
+func NewOutboxRepository(db database.OutboxDatabase, transactor database.Transactor) *OutboxRepository {
+	return &OutboxRepository{
+		db:         db,
+		transactor: transactor,
+	}
+}
+
+// WithTransaction runs fn in a transaction, see database.Transactor
+func (r *OutboxRepository) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return r.transactor.WithTransaction(ctx, fn)
+}
+
+// AddEvent stores an event for the subject, ordered after the earlier events with the key
+func (r *OutboxRepository) AddEvent(ctx context.Context, subject string, key string, header map[string]string, data []byte) error {
+	return r.db.AddEvent(ctx, model.OutboxEvent{
+		Subject: subject,
+		Key:     key,
+		Header:  header,
+		Data:    data,
+	})
+}
+
+// GetPendingEvents returns up to limit undelivered events, oldest first,
+// following the given event unless it is nil
+func (r *OutboxRepository) GetPendingEvents(ctx context.Context, after *model.OutboxEvent, limit int) ([]model.OutboxEvent, error) {
+	return r.db.GetPendingEvents(ctx, after, limit)
+}
+
+// MarkDelivered records that the event was published
+func (r *OutboxRepository) MarkDelivered(ctx context.Context, id primitive.ObjectID, deliveredAt time.Time) error {
+	return r.db.MarkDelivered(ctx, id, deliveredAt)
+}
+
+// MarkFailed records a failed attempt to publish the event and when to retry it
+func (r *OutboxRepository) MarkFailed(ctx context.Context, id primitive.ObjectID, attempts int, nextAttemptAt time.Time, lastError string) error {
+	return r.db.MarkFailed(ctx, id, attempts, nextAttemptAt, lastError)
+}
+
+// DeleteDelivered removes the events delivered before the given time
+func (r *OutboxRepository) DeleteDelivered(ctx context.Context, deliveredBefore time.Time) (int64, error) {
+	return r.db.DeleteDelivered(ctx, deliveredBefore)
+}
+
+// AcquireLease makes the owner the holder of the named lease for the ttl,
+// unless another owner holds it, and reports whether it does
+func (r *OutboxRepository) AcquireLease(ctx context.Context, name string, owner string, ttl time.Duration) (bool, error) {
+	return r.db.AcquireLease(ctx, name, owner, ttl)
+}
@@ -1,9 +1,15 @@
 package repository
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	database "main.go/database/models"
 	"main.go/model"
 	"main.go/search"
@@ -23,113 +29,363 @@ func NewPostRepository(db database.PostDatabase, searchEngine search.SearchEngin
 	}
 }
 
-// GetPosts returns all posts
-func (r *PostRepository) GetPosts() ([]model.Post, error) {
-	return r.db.GetPosts()
+// GetPosts returns a page of posts matching the filter
+func (r *PostRepository) GetPosts(ctx context.Context, filter model.PostFilter, page model.PageRequest) (model.PostPage, error) {
+	return r.db.GetPosts(ctx, filter, page)
 }
 
 // GetPostByID returns a post by ID
-func (r *PostRepository) GetPostByID(id primitive.ObjectID) (model.Post, error) {
-	return r.db.GetPostByID(id)
+func (r *PostRepository) GetPostByID(ctx context.Context, id primitive.ObjectID) (model.Post, error) {
+	post, err := r.db.GetPostByID(ctx, id)
+	return post, translate(err, ErrPostNotFound)
 }
 
-func (r *PostRepository) GetLatestInsertedPost() (model.Post, error) {
-	return r.db.GetLatestInsertedPost()
+// GetPostByIDFromDB returns a post by ID as stored, bypassing the cache, for
+// changes and checks that must not act on a stale copy
+func (r *PostRepository) GetPostByIDFromDB(ctx context.Context, id primitive.ObjectID) (model.Post, error) {
+	post, err := r.db.GetPostByIDFromDB(ctx, id)
+	return post, translate(err, ErrPostNotFound)
 }
 
-func (r *PostRepository) AddPost(post model.Post) (model.Post, error) {
-	// First, add the post to the database
-	newPost, err := r.db.AddPost(post)
-	if err != nil {
-		return newPost, err
-	}
+func (r *PostRepository) GetLatestInsertedPost(ctx context.Context) (model.Post, error) {
+	return r.db.GetLatestInsertedPost(ctx)
+}
 
-	// Get the latest inserted post from the database
-	latestPost, err := r.GetLatestInsertedPost()
+// AddPost stores a new post. It is indexed for search once the post.added
+// event reaches the worker, see ReindexPost.
+func (r *PostRepository) AddPost(ctx context.Context, post model.Post) (model.Post, error) {
+	newPost, err := r.db.AddPost(ctx, post)
+	return newPost, translate(err, ErrPostNotFound)
+}
+
+// UpdatePost stores the title and body of the post and returns the stored post
+func (r *PostRepository) UpdatePost(ctx context.Context, post model.Post) (model.Post, error) {
+	updatedPost, err := r.db.UpdatePost(ctx, post)
 	if err != nil {
-		// Handle the error if necessary
-		log.Println("Failed to get the latest inserted post from the database:", err)
-		return newPost, nil
+		return model.Post{}, translate(err, ErrPostNotFound)
 	}
+	return updatedPost, nil
+}
 
-	// Next, index the new post data in ElasticSearch with the provided "_id"
-	indexName := "posts" // The name of the Elasticsearch index where post data is stored.
-	err = r.searchEngine.IndexDocument(indexName, latestPost.ID.Hex(), newPost)
+// PatchPost stores the named fields of the post and returns the stored post
+func (r *PostRepository) PatchPost(ctx context.Context, post model.Post, fields []string) (model.Post, error) {
+	patchedPost, err := r.db.PatchPost(ctx, post, fields)
 	if err != nil {
-		log.Println("Failed to index post in ElasticSearch:", err)
-	} else {
-		log.Println("Post indexed successfully in ElasticSearch")
+		return model.Post{}, translate(err, ErrPostNotFound)
 	}
+	return patchedPost, nil
+}
 
-	return newPost, nil
+// DeletePost deletes a post by ID if it still has the version, or any version when it is zero
+func (r *PostRepository) DeletePost(ctx context.Context, id primitive.ObjectID, version int64) error {
+	err := r.db.DeletePost(ctx, id, version)
+	return translate(err, ErrPostNotFound)
 }
 
-func (r *PostRepository) UpdatePost(post model.Post) error {
-	// First, update the post in the database
-	updatedPost, err := r.db.UpdatePost(post)
+// ReindexPost brings the cache and the search index in line with the stored
+// post: the cached copies are dropped and the post is indexed again, or
+// removed from the index when it no longer exists or is in the trash. It
+// only depends on the stored state, so running it again, or for events that
+// arrive out of order, is harmless.
+func (r *PostRepository) ReindexPost(ctx context.Context, id primitive.ObjectID) error {
+	err := r.db.InvalidateCache(ctx, id)
 	if err != nil {
 		return err
 	}
 
-	// Next, update the post data in Elasticsearch
 	indexName := "posts" // The name of the Elasticsearch index where post data is stored.
-	err = r.searchEngine.IndexDocument(indexName, updatedPost.ID.Hex(), updatedPost)
+	post, err := r.db.GetPostByIDFromDB(ctx, id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return r.searchEngine.DeleteDocument(ctx, indexName, id.Hex())
+	}
 	if err != nil {
-		log.Println("Failed to update post data in ElasticSearch:", err)
-	} else {
-		log.Println("Post data updated successfully in ElasticSearch")
+		return err
 	}
 
-	return nil
+	return r.searchEngine.IndexDocument(ctx, indexName, post.ID.Hex(), post)
 }
 
-func (r *PostRepository) PatchPost(post model.Post) (model.Post, error) {
-	// First, patch the post in the database
-	patchedPost, err := r.db.PatchPost(post)
-	if err != nil {
-		return model.Post{}, err
+// GetAllPostsByAuthor returns every post of an author, reading page by page
+func (r *PostRepository) GetAllPostsByAuthor(ctx context.Context, authorID primitive.ObjectID) ([]model.Post, error) {
+	var posts []model.Post
+	err := r.eachPost(ctx, model.PostFilter{AuthorID: authorID}, func(post model.Post) error {
+		posts = append(posts, post)
+		return nil
+	})
+	return posts, err
+}
+
+// eachPost passes every post matching the filter to fn, reading page by page
+// from the database rather than the cache
+func (r *PostRepository) eachPost(ctx context.Context, filter model.PostFilter, fn func(post model.Post) error) error {
+	page := model.PageRequest{Limit: model.MaxPageLimit}
+	for {
+		result, err := r.db.GetPostsFromDB(ctx, filter, page)
+		if err != nil {
+			return err
+		}
+
+		for _, post := range result.Data {
+			if err := fn(post); err != nil {
+				return err
+			}
+		}
+
+		if result.NextCursor == "" {
+			return nil
+		}
+		page.Cursor = result.NextCursor
 	}
+}
 
-	// Next, update the post data in Elasticsearch
-	indexName := "posts" // The name of the Elasticsearch index where post data is stored.
-	err = r.searchEngine.IndexDocument(indexName, patchedPost.ID.Hex(), patchedPost)
+// RebuildIndex copies every post into a new search index and points the
+// "posts" alias at it, see rebuildIndex
+func (r *PostRepository) RebuildIndex(ctx context.Context) (IndexRebuild, error) {
+	return rebuildIndex(ctx, r.searchEngine, r.indexSource())
+}
+
+// VerifyIndex compares the search index with the stored posts and, when
+// repairing, syncs the posts that differ
+func (r *PostRepository) VerifyIndex(ctx context.Context, repair bool) (IndexReport, error) {
+	return verifyIndex(ctx, r.searchEngine, r.indexSource(), repair)
+}
+
+// IndexOutdated reports whether the posts index has to be rebuilt to take
+// the current mappings
+func (r *PostRepository) IndexOutdated(ctx context.Context) (bool, error) {
+	return indexOutdated(ctx, r.searchEngine, r.indexSource().alias)
+}
+
+// indexSource describes the posts to the index rebuilds and checks
+func (r *PostRepository) indexSource() indexSource {
+	return indexSource{
+		alias: "posts", // The name of the Elasticsearch index where post data is stored.
+		each: func(ctx context.Context, fn func(id primitive.ObjectID, version int64, doc interface{}) error) error {
+			return r.eachPost(ctx, model.PostFilter{}, func(post model.Post) error {
+				return fn(post.ID, post.Version, post)
+			})
+		},
+		reindex: r.ReindexPost,
+	}
+}
+
+// ReassignPosts moves the posts of an author to another user, or orphans them
+// when the new author is the zero ObjectID
+func (r *PostRepository) ReassignPosts(ctx context.Context, from primitive.ObjectID, to primitive.ObjectID) error {
+	return r.db.ReassignPosts(ctx, from, to)
+}
+
+// GetDeletedPostByID returns a post in the trash by ID
+func (r *PostRepository) GetDeletedPostByID(ctx context.Context, id primitive.ObjectID) (model.Post, error) {
+	post, err := r.db.GetDeletedPostByID(ctx, id)
+	return post, translate(err, ErrPostNotFound)
+}
+
+// RestorePost takes a post out of the trash
+func (r *PostRepository) RestorePost(ctx context.Context, id primitive.ObjectID) (model.Post, error) {
+	restoredPost, err := r.db.RestorePost(ctx, id)
 	if err != nil {
-		log.Println("Failed to update post data in ElasticSearch:", err)
-	} else {
-		log.Println("Post data updated successfully in ElasticSearch")
+		return model.Post{}, translate(err, ErrPostNotFound)
 	}
+	return restoredPost, nil
+}
 
-	return patchedPost, nil
+// PurgePosts permanently removes the posts deleted before the given time.
+// They left the search index when they were deleted.
+func (r *PostRepository) PurgePosts(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	return r.db.PurgePosts(ctx, deletedBefore)
 }
 
-func (r *PostRepository) DeletePost(id primitive.ObjectID) error {
-	// First, delete the post from the database
-	err := r.db.DeletePost(id)
+// SearchPosts returns a page of the posts matching the query and the filter,
+// decoded from the indexed documents, along with the facets of all of them. A
+// query that does not parse fails validation. The index is updated
+// asynchronously by the worker, so the posts are as they were when last
+// indexed.
+func (r *PostRepository) SearchPosts(ctx context.Context, request model.SearchRequest, filter model.PostSearchFilter) (model.PostSearchPage, error) {
+	engineRequest, err := searchRequest(request, postQueryFields, postSearchFields, "title", "body")
 	if err != nil {
-		return err
+		return model.PostSearchPage{}, err
 	}
 
-	// Next, remove the post data from ElasticSearch
+	ranges := postCreatedRanges(time.Now())
+	engineRequest.Filters = postSearchFilters(filter, ranges)
+	engineRequest.Aggregations = postFacets(ranges)
+
 	indexName := "posts" // The name of the Elasticsearch index where post data is stored.
-	err = r.searchEngine.DeleteDocument(indexName, id.Hex())
+	results, err := r.searchEngine.Search(ctx, indexName, engineRequest)
 	if err != nil {
-		log.Println("Failed to remove post data from ElasticSearch:", err)
-	} else {
-		log.Println("Post data removed successfully from ElasticSearch")
+		log.Println("Error searching for posts in ElasticSearch:", err)
+		return model.PostSearchPage{}, err
+	}
+
+	hits, next := pageHits(results.Hits, request.PageLimit())
+	page := model.PostSearchPage{
+		Data:       make([]model.PostSearchHit, 0, len(hits)),
+		Total:      results.Total,
+		NextCursor: next,
+		Facets:     make(map[string][]model.FacetBucket, len(engineRequest.Aggregations)),
+	}
+	for name := range engineRequest.Aggregations {
+		buckets := results.Aggregations[name]
+		page.Facets[name] = make([]model.FacetBucket, 0, len(buckets))
+		for _, bucket := range buckets {
+			page.Facets[name] = append(page.Facets[name], model.FacetBucket{Value: bucket.Key, Count: bucket.Count})
+		}
 	}
+	for _, hit := range hits {
+		post, err := r.hydratePost(ctx, hit)
+		if errors.Is(err, ErrPostNotFound) {
+			// Deleted since it was indexed, the worker is about to remove it
+			continue
+		}
+		if err != nil {
+			return model.PostSearchPage{}, err
+		}
 
-	return nil
+		page.Data = append(page.Data, model.PostSearchHit{
+			Post:       post,
+			Score:      hit.Score,
+			Highlights: hit.Highlights,
+		})
+	}
+
+	log.Printf("Found %d search results for query: %s\n", results.Total, request.Query)
+	return page, nil
 }
 
-// SearchPosts performs a search query on the post data and returns the results.
-func (r *PostRepository) SearchPosts(query string) ([]search.SearchResult, error) {
+// SuggestPosts returns the titles starting with the prefix, for completing
+// what a user types
+func (r *PostRepository) SuggestPosts(ctx context.Context, prefix string, limit int) ([]model.Suggestion, error) {
 	indexName := "posts" // The name of the Elasticsearch index where post data is stored.
-	searchResults, err := r.searchEngine.Search(indexName, query)
+	suggestions, err := r.searchEngine.Suggest(ctx, indexName, "title.suggest", prefix, limit)
 	if err != nil {
-		log.Println("Error searching for posts in ElasticSearch:", err)
+		log.Println("Error suggesting titles in ElasticSearch:", err)
 		return nil, err
 	}
 
-	log.Printf("Found %d search results for query: %s\n", len(searchResults), query)
-	return searchResults, nil
+	result := make([]model.Suggestion, 0, len(suggestions))
+	for _, suggestion := range suggestions {
+		result = append(result, model.Suggestion{ID: suggestion.ID, Text: suggestion.Text})
+	}
+	return result, nil
+}
+
+// RelatedPostIDs returns the IDs of the posts whose title and body are most
+// like those of the post, best first. A post that is not indexed yet has none.
+func (r *PostRepository) RelatedPostIDs(ctx context.Context, id primitive.ObjectID, limit int) ([]primitive.ObjectID, error) {
+	indexName := "posts" // The name of the Elasticsearch index where post data is stored.
+	hits, err := r.searchEngine.MoreLikeThis(ctx, indexName, id.Hex(), []string{"title", "body"}, limit)
+	if err != nil {
+		log.Println("Error finding related posts in ElasticSearch:", err)
+		return nil, err
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(hits))
+	for _, hit := range hits {
+		hitID, err := primitive.ObjectIDFromHex(hit.ID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid post ID %q in the search index", hit.ID)
+		}
+		ids = append(ids, hitID)
+	}
+	return ids, nil
+}
+
+// postQueryFields are the fields post search queries may search
+var postQueryFields = search.QueryFields{
+	Default: []string{"title", "body"},
+	Named: map[string][]string{
+		"title":     {"title"},
+		"body":      {"body"},
+		"author_id": {"author_id"},
+		"tag":       {"tags"},
+	},
+}
+
+// postFacetSize is the most authors and tags the facets of a post search count
+const postFacetSize = 20
+
+// postFacets are the facets of post searches, named after the filter
+// parameters that drill down into them
+func postFacets(ranges []search.Range) map[string]search.Aggregation {
+	return map[string]search.Aggregation{
+		"author_id": search.TermsAggregation{Field: "author_id", Size: postFacetSize},
+		"tag":       search.TermsAggregation{Field: "tags", Size: postFacetSize},
+		"month":     search.DateHistogramAggregation{Field: "created_at", Interval: "month"},
+		"created":   search.RangeAggregation{Field: "created_at", Ranges: ranges},
+	}
+}
+
+// postCreatedRanges returns the ranges of creation dates, relative to now,
+// that model.PostCreatedRanges name
+func postCreatedRanges(now time.Time) []search.Range {
+	now = now.UTC()
+	weekAgo := now.AddDate(0, 0, -7).Format(time.RFC3339)
+	monthAgo := now.AddDate(0, -1, 0).Format(time.RFC3339)
+	yearAgo := now.AddDate(-1, 0, 0).Format(time.RFC3339)
+
+	return []search.Range{
+		{Key: "past_week", From: weekAgo},
+		{Key: "past_month", From: monthAgo},
+		{Key: "past_year", From: yearAgo},
+		{Key: "older", To: yearAgo},
+	}
+}
+
+// postSearchFilters translates the facet values picked into the filters of
+// the search
+func postSearchFilters(filter model.PostSearchFilter, ranges []search.Range) []search.Query {
+	var filters []search.Query
+
+	if !filter.AuthorID.IsZero() {
+		filters = append(filters, search.ExactQuery{Field: "author_id", Values: []string{filter.AuthorID.Hex()}})
+	}
+
+	for _, tag := range filter.Tags {
+		filters = append(filters, search.ExactQuery{Field: "tags", Values: []string{tag}})
+	}
+
+	if !filter.Month.IsZero() {
+		month := filter.Month.UTC()
+		filters = append(filters, search.RangeQuery{
+			Field: "created_at",
+			From:  month.Format(time.RFC3339),
+			To:    month.AddDate(0, 1, 0).Format(time.RFC3339),
+		})
+	}
+
+	for _, r := range ranges {
+		if r.Key == filter.Created {
+			filters = append(filters, search.RangeQuery{Field: "created_at", From: r.From, To: r.To})
+		}
+	}
+
+	return filters
+}
+
+// postSearchFields maps the sort fields of post searches to the indexed fields
+// they sort by, where these differ
+var postSearchFields = map[string]string{
+	"title": "title.raw",
+}
+
+// hydratePost decodes the post of a search hit from its source, or reads it from
+// the database when the hit came without one
+func (r *PostRepository) hydratePost(ctx context.Context, hit search.SearchResult) (model.Post, error) {
+	id, err := primitive.ObjectIDFromHex(hit.ID)
+	if err != nil {
+		return model.Post{}, fmt.Errorf("invalid post id %q in the search index: %v", hit.ID, err)
+	}
+
+	if len(hit.Source) == 0 {
+		return r.GetPostByID(ctx, id)
+	}
+
+	var post model.Post
+	if err := json.Unmarshal(hit.Source, &post); err != nil {
+		return model.Post{}, fmt.Errorf("invalid post %s in the search index: %v", hit.ID, err)
+	}
+	post.ID = id
+
+	return post, nil
 }
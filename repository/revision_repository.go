@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	database "main.go/database/models"
+	"main.go/model"
+)
+
+// PostRevisionRepository handles the post revision data access
+type PostRevisionRepository struct {
+	db database.PostRevisionDatabase
+}
+
+// NewPostRevisionRepository creates a new PostRevisionRepository
+func NewPostRevisionRepository(db database.PostRevisionDatabase) *PostRevisionRepository {
+	return &PostRevisionRepository{
+		db: db,
+	}
+}
+
+// AddPostRevision stores a revision, failing with ErrAlreadyExists when the
+// post already has one with the number
+func (r *PostRevisionRepository) AddPostRevision(ctx context.Context, revision model.PostRevision) error {
+	err := r.db.AddPostRevision(ctx, revision)
+	return translate(err, ErrRevisionNotFound)
+}
+
+// GetPostRevisions returns the revisions of a post, oldest first
+func (r *PostRevisionRepository) GetPostRevisions(ctx context.Context, postID primitive.ObjectID) ([]model.PostRevision, error) {
+	return r.db.GetPostRevisions(ctx, postID)
+}
+
+// GetPostRevision returns the revision of a post with the number
+func (r *PostRevisionRepository) GetPostRevision(ctx context.Context, postID primitive.ObjectID, number int64) (model.PostRevision, error) {
+	revision, err := r.db.GetPostRevision(ctx, postID, number)
+	return revision, translate(err, ErrRevisionNotFound)
+}
@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"main.go/errs"
+	"main.go/model"
+	"main.go/search"
+)
+
+// searchRequest translates a page of search results into the request for the
+// search engine. The query may search the query fields and results are sorted
+// by the indexed fields the sort fields map to. It asks for one hit more than
+// the limit to learn whether another page follows.
+func searchRequest(request model.SearchRequest, queryFields search.QueryFields, sortFields map[string]string, highlight ...string) (search.SearchRequest, error) {
+	query, err := search.ParseQuery(request.Query, queryFields)
+	if err != nil {
+		return search.SearchRequest{}, errs.Validation(errs.CodeInvalidSearchQuery, err.Error(), errs.FieldError{
+			Field:   "q",
+			Message: err.Error(),
+		})
+	}
+
+	fields, desc := request.SortFields()
+
+	searchRequest := search.SearchRequest{
+		Query:     query,
+		From:      request.From,
+		Size:      request.PageLimit() + 1,
+		Highlight: highlight,
+	}
+	for i, field := range fields {
+		if indexed, ok := sortFields[field]; ok {
+			field = indexed
+		}
+		searchRequest.Sort = append(searchRequest.Sort, search.SortField{Field: field, Desc: desc[i]})
+	}
+
+	if request.Cursor != "" {
+		// The request was validated, the cursor is well formed
+		searchRequest.After, _ = model.DecodeSearchCursor(request.Cursor)
+	}
+
+	return searchRequest, nil
+}
+
+// pageHits trims the hits to the limit and returns the cursor of the next
+// page, if there is one
+func pageHits(hits []search.SearchResult, limit int) ([]search.SearchResult, string) {
+	if len(hits) <= limit {
+		return hits, ""
+	}
+	hits = hits[:limit]
+	return hits, model.EncodeSearchCursor(hits[limit-1].Sort)
+}
@@ -0,0 +1,209 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"main.go/search"
+)
+
+// bulkBatchSize is how many documents a rebuild sends per bulk request
+const bulkBatchSize = 500
+
+// IndexRebuild describes a rebuilt search index
+type IndexRebuild struct {
+	Alias     string   // Name the index is searched by
+	Index     string   // Versioned index the alias points to now
+	Documents int      // Documents copied from the database
+	Repaired  int      // Documents changed during the rebuild and synced after the swap
+	Removed   []string // Indices the alias pointed to before, which were deleted
+}
+
+// IndexReport lists the differences between a search index and the database
+type IndexReport struct {
+	Index    string
+	Checked  int      // Documents in the database
+	Missing  []string // IDs in the database but not in the index
+	Stale    []string // IDs indexed with another version than the stored one
+	Orphaned []string // IDs in the index but not in the database, or in the trash
+	Repaired int      // Differences fixed, when repairing
+}
+
+// Consistent reports whether the index matches the database
+func (r IndexReport) Consistent() bool {
+	return len(r.Missing) == 0 && len(r.Stale) == 0 && len(r.Orphaned) == 0
+}
+
+// indexSource is what rebuilds and checks of a search index need to know
+// about the entities in it
+type indexSource struct {
+	alias string
+	// each passes every stored entity, except the ones in the trash, to fn
+	each func(ctx context.Context, fn func(id primitive.ObjectID, version int64, doc interface{}) error) error
+	// reindex syncs the index entry of one entity with the database
+	reindex func(ctx context.Context, id primitive.ObjectID) error
+}
+
+// rebuildIndex copies every entity into a new index named after the alias
+// and the current time, to the millisecond, and then swaps the alias over to it. Changes made
+// during the copy went to the old index; they are carried over by repairing
+// the new index right after the swap. The indices the alias pointed to
+// before are deleted.
+func rebuildIndex(ctx context.Context, engine search.SearchEngine, source indexSource) (IndexRebuild, error) {
+	rebuild := IndexRebuild{
+		Alias: source.alias,
+		Index: fmt.Sprintf("%s_%s", source.alias, strings.Replace(time.Now().UTC().Format("20060102150405.000"), ".", "", 1)),
+	}
+
+	err := engine.CreateIndex(ctx, rebuild.Index)
+	if err != nil {
+		return IndexRebuild{}, fmt.Errorf("failed to create index %s: %v", rebuild.Index, err)
+	}
+
+	err = copyDocuments(ctx, engine, source, rebuild.Index, &rebuild.Documents)
+	if err != nil {
+		// Nothing points at the new index yet
+		if err := engine.DeleteIndex(context.Background(), rebuild.Index); err != nil {
+			log.Printf("Failed to delete the incomplete index %s: %v", rebuild.Index, err)
+		}
+		return IndexRebuild{}, err
+	}
+
+	previous, err := engine.SwapAlias(ctx, source.alias, rebuild.Index)
+	if err != nil {
+		return IndexRebuild{}, fmt.Errorf("failed to point %s at %s: %v", source.alias, rebuild.Index, err)
+	}
+
+	report, err := verifyIndex(ctx, engine, source, true)
+	if err != nil {
+		return IndexRebuild{}, err
+	}
+	rebuild.Repaired = report.Repaired
+
+	for _, index := range previous {
+		if err := engine.DeleteIndex(ctx, index); err != nil {
+			log.Printf("Failed to delete the previous index %s: %v", index, err)
+			continue
+		}
+		rebuild.Removed = append(rebuild.Removed, index)
+	}
+
+	return rebuild, nil
+}
+
+// indexOutdated reports whether the index the alias points to was created
+// with an older definition than the current one, or without any
+func indexOutdated(ctx context.Context, engine search.SearchEngine, alias string) (bool, error) {
+	definition, ok := search.LookupIndexDefinition(alias)
+	if !ok {
+		return false, fmt.Errorf("no definition of index %s", alias)
+	}
+
+	version, err := engine.MappingVersion(ctx, alias)
+	if err != nil {
+		return false, err
+	}
+
+	return version < definition.Version, nil
+}
+
+// copyDocuments indexes every entity into the index with bulk requests
+func copyDocuments(ctx context.Context, engine search.SearchEngine, source indexSource, index string, count *int) error {
+	batch := make([]search.Document, 0, bulkBatchSize)
+	flush := func() error {
+		if err := engine.BulkIndex(ctx, index, batch); err != nil {
+			return err
+		}
+		*count += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	err := source.each(ctx, func(id primitive.ObjectID, version int64, doc interface{}) error {
+		batch = append(batch, search.Document{ID: id.Hex(), Data: doc})
+		if len(batch) < bulkBatchSize {
+			return nil
+		}
+		return flush()
+	})
+	if err != nil {
+		return err
+	}
+
+	return flush()
+}
+
+// verifyIndex compares the version of every indexed document with the
+// stored entity. Repairing syncs every difference found, each on its own,
+// so an entity changed in the meantime is indexed as it is now.
+func verifyIndex(ctx context.Context, engine search.SearchEngine, source indexSource, repair bool) (IndexReport, error) {
+	report := IndexReport{Index: source.alias}
+
+	indexed := make(map[string]int64)
+	err := engine.ScanDocuments(ctx, source.alias, func(id string, raw json.RawMessage) error {
+		var doc struct {
+			Version int64 `json:"version"`
+		}
+		if err := json.Unmarshal(raw, &doc); err != nil {
+			return fmt.Errorf("invalid document %s in %s: %v", id, source.alias, err)
+		}
+		indexed[id] = doc.Version
+		return nil
+	})
+	if err != nil {
+		return IndexReport{}, err
+	}
+
+	err = source.each(ctx, func(id primitive.ObjectID, version int64, doc interface{}) error {
+		report.Checked++
+
+		indexedVersion, ok := indexed[id.Hex()]
+		switch {
+		case !ok:
+			report.Missing = append(report.Missing, id.Hex())
+		case indexedVersion != version:
+			report.Stale = append(report.Stale, id.Hex())
+		}
+
+		delete(indexed, id.Hex())
+		return nil
+	})
+	if err != nil {
+		return IndexReport{}, err
+	}
+
+	for id := range indexed {
+		report.Orphaned = append(report.Orphaned, id)
+	}
+
+	if !repair {
+		return report, nil
+	}
+
+	for _, ids := range [][]string{report.Missing, report.Stale, report.Orphaned} {
+		for _, id := range ids {
+			err := repairDocument(ctx, engine, source, id)
+			if err != nil {
+				return report, fmt.Errorf("failed to repair %s in %s: %v", id, source.alias, err)
+			}
+			report.Repaired++
+		}
+	}
+
+	return report, nil
+}
+
+// repairDocument syncs the document of an entity, or removes a document
+// whose ID names no entity at all
+func repairDocument(ctx context.Context, engine search.SearchEngine, source indexSource, id string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return engine.DeleteDocument(ctx, source.alias, id)
+	}
+	return source.reindex(ctx, objID)
+}
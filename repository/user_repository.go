@@ -1,10 +1,15 @@
 package repository
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	database "main.go/database/models"
 	"main.go/model"
 	"main.go/search"
@@ -24,127 +29,288 @@ func NewUserRepository(db database.UserDatabase, searchEngine search.SearchEngin
 	}
 }
 
-// GetUsers returns all users
-func (r *UserRepository) GetUsers() ([]model.User, error) {
-	return r.db.GetUsers()
+// GetUsers returns a page of users matching the filter
+func (r *UserRepository) GetUsers(ctx context.Context, filter model.UserFilter, page model.PageRequest) (model.UserPage, error) {
+	return r.db.GetUsers(ctx, filter, page)
 }
 
 // GetUserByID returns a user by ID
-func (r *UserRepository) GetUserByID(id string) (model.User, error) {
+func (r *UserRepository) GetUserByID(ctx context.Context, id string) (model.User, error) {
+	objID, err := model.ParseID(id)
+	if err != nil {
+		return model.User{}, err
+	}
+
+	user, err := r.db.GetUserByID(ctx, objID)
+	return user, translate(err, ErrUserNotFound)
+}
 
-	// Convert the string ID to an ObjectId
-	objID, err := primitive.ObjectIDFromHex(id)
+// GetUserByIDFromDB returns a user by ID as stored, bypassing the cache, for
+// changes and checks that must not act on a stale copy
+func (r *UserRepository) GetUserByIDFromDB(ctx context.Context, id string) (model.User, error) {
+	objID, err := model.ParseID(id)
 	if err != nil {
-		return model.User{}, fmt.Errorf("invalid object ID format: %v", err)
+		return model.User{}, err
 	}
 
-	return r.db.GetUserByID(objID)
+	user, err := r.db.GetUserByIDFromDB(ctx, objID)
+	return user, translate(err, ErrUserNotFound)
+}
+
+// GetUserByUserName returns a user, including the password hash, by username
+func (r *UserRepository) GetUserByUserName(ctx context.Context, username string) (model.User, error) {
+	user, err := r.db.GetUserByUserName(ctx, username)
+	return user, translate(err, ErrUserNotFound)
 }
 
-func (r *UserRepository) GetLatestInsertedUser() (model.User, error) {
-	return r.db.GetLatestInsertedUser()
+func (r *UserRepository) GetLatestInsertedUser(ctx context.Context) (model.User, error) {
+	return r.db.GetLatestInsertedUser(ctx)
 }
 
-func (r *UserRepository) AddUser(user model.User) (model.User, error) {
-	// First, add the user to the database
-	newUser, err := r.db.AddUser(user)
+// AddUser stores a new user. It is indexed for search once the user.added
+// event reaches the worker, see ReindexUser.
+func (r *UserRepository) AddUser(ctx context.Context, user model.User) (model.User, error) {
+	newUser, err := r.db.AddUser(ctx, user)
+	return newUser, translate(err, ErrUserNotFound)
+}
+
+// UpdateUser stores the user and returns the stored user
+func (r *UserRepository) UpdateUser(ctx context.Context, user model.User) (model.User, error) {
+	updatedUser, err := r.db.UpdateUser(ctx, user)
 	if err != nil {
-		return newUser, err
+		return model.User{}, translate(err, ErrUserNotFound)
 	}
+	return updatedUser, nil
+}
 
-	// Get the latest inserted user from the database
-	latestUser, err := r.GetLatestInsertedUser()
+// PatchUser stores the named fields of the user and returns the stored user
+func (r *UserRepository) PatchUser(ctx context.Context, user model.User, fields []string) (model.User, error) {
+	patchedUser, err := r.db.PatchUser(ctx, user, fields)
 	if err != nil {
-		// Handle the error if necessary
-		log.Println("Failed to get the latest inserted user from the database:", err)
-		return newUser, nil
+		return model.User{}, translate(err, ErrUserNotFound)
 	}
+	return patchedUser, nil
+}
 
-	// Next, index the new user data in ElasticSearch with the provided "_id"
-	indexName := "users" // The name of the Elasticsearch index where user data is stored.
-	err = r.searchEngine.IndexDocument(indexName, latestUser.ID.Hex(), newUser)
+// DeleteUser deletes a user by ID if it still has the version, or any version when it is zero
+func (r *UserRepository) DeleteUser(ctx context.Context, id string, version int64) error {
+	objID, err := model.ParseID(id)
 	if err != nil {
-		log.Println("Failed to index user in ElasticSearch:", err)
-	} else {
-		log.Println("User indexed successfully in ElasticSearch")
+		return err
 	}
 
-	return newUser, nil
+	err = r.db.DeleteUser(ctx, objID, version)
+	return translate(err, ErrUserNotFound)
 }
 
-func (r *UserRepository) UpdateUser(user model.User) error {
-	// First, update the user in the database
-	updatedUser, err := r.db.UpdateUser(user)
+// ReindexUser brings the cache and the search index in line with the stored
+// user, like PostRepository.ReindexPost
+func (r *UserRepository) ReindexUser(ctx context.Context, id primitive.ObjectID) error {
+	err := r.db.InvalidateCache(ctx, id)
 	if err != nil {
 		return err
 	}
 
-	// Next, update the user data in Elasticsearch
 	indexName := "users" // The name of the Elasticsearch index where user data is stored.
-	err = r.searchEngine.IndexDocument(indexName, updatedUser.ID.Hex(), updatedUser)
+	user, err := r.db.GetUserByIDFromDB(ctx, id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return r.searchEngine.DeleteDocument(ctx, indexName, id.Hex())
+	}
 	if err != nil {
-		log.Println("Failed to update user data in ElasticSearch:", err)
-	} else {
-		log.Println("User data updated successfully in ElasticSearch")
+		return err
 	}
 
-	return nil
+	return r.searchEngine.IndexDocument(ctx, indexName, user.ID.Hex(), user)
 }
 
-func (r *UserRepository) PatchUser(user model.User) (model.User, error) {
-	// First, patch the user in the database
-	patchedUser, err := r.db.PatchUser(user)
-	if err != nil {
-		return model.User{}, err
+// eachUser passes every user to fn, reading page by page from the database
+// rather than the cache
+func (r *UserRepository) eachUser(ctx context.Context, fn func(user model.User) error) error {
+	page := model.PageRequest{Limit: model.MaxPageLimit}
+	for {
+		result, err := r.db.GetUsersFromDB(ctx, model.UserFilter{}, page)
+		if err != nil {
+			return err
+		}
+
+		for _, user := range result.Data {
+			if err := fn(user); err != nil {
+				return err
+			}
+		}
+
+		if result.NextCursor == "" {
+			return nil
+		}
+		page.Cursor = result.NextCursor
 	}
+}
 
-	// Next, update the user data in Elasticsearch
-	indexName := "users" // The name of the Elasticsearch index where user data is stored.
-	err = r.searchEngine.IndexDocument(indexName, patchedUser.ID.Hex(), patchedUser)
-	if err != nil {
-		log.Println("Failed to update user data in ElasticSearch:", err)
-	} else {
-		log.Println("User data updated successfully in ElasticSearch")
+// RebuildIndex copies every user into a new search index and points the
+// "users" alias at it, see rebuildIndex
+func (r *UserRepository) RebuildIndex(ctx context.Context) (IndexRebuild, error) {
+	return rebuildIndex(ctx, r.searchEngine, r.indexSource())
+}
+
+// VerifyIndex compares the search index with the stored users and, when
+// repairing, syncs the users that differ
+func (r *UserRepository) VerifyIndex(ctx context.Context, repair bool) (IndexReport, error) {
+	return verifyIndex(ctx, r.searchEngine, r.indexSource(), repair)
+}
+
+// IndexOutdated reports whether the users index has to be rebuilt to take
+// the current mappings
+func (r *UserRepository) IndexOutdated(ctx context.Context) (bool, error) {
+	return indexOutdated(ctx, r.searchEngine, r.indexSource().alias)
+}
+
+// indexSource describes the users to the index rebuilds and checks
+func (r *UserRepository) indexSource() indexSource {
+	return indexSource{
+		alias: "users", // The name of the Elasticsearch index where user data is stored.
+		each: func(ctx context.Context, fn func(id primitive.ObjectID, version int64, doc interface{}) error) error {
+			return r.eachUser(ctx, func(user model.User) error {
+				return fn(user.ID, user.Version, user)
+			})
+		},
+		reindex: r.ReindexUser,
 	}
+}
 
-	return patchedUser, nil
+// GetDeletedUserByID returns a user in the trash by ID
+func (r *UserRepository) GetDeletedUserByID(ctx context.Context, id primitive.ObjectID) (model.User, error) {
+	user, err := r.db.GetDeletedUserByID(ctx, id)
+	return user, translate(err, ErrUserNotFound)
 }
 
-// DeleteUser deletes a user by ID
-func (r *UserRepository) DeleteUser(id string) error {
-	// Convert the string ID to an ObjectId
-	objID, err := primitive.ObjectIDFromHex(id)
+// RestoreUser takes a user out of the trash
+func (r *UserRepository) RestoreUser(ctx context.Context, id primitive.ObjectID) (model.User, error) {
+	restoredUser, err := r.db.RestoreUser(ctx, id)
 	if err != nil {
-		return fmt.Errorf("invalid object ID format: %v", err)
+		return model.User{}, translate(err, ErrUserNotFound)
+	}
+	return restoredUser, nil
+}
+
+// PurgeUsers permanently removes the users deleted before the given time.
+// They left the search index when they were deleted.
+func (r *UserRepository) PurgeUsers(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	return r.db.PurgeUsers(ctx, deletedBefore)
+}
+
+// SearchUsers returns a page of the users matching the query, decoded from the
+// indexed documents. The query may only search the email addresses when
+// searchEmail is set; a query that does not parse, also one naming the email
+// field otherwise, fails validation. The index is updated asynchronously by
+// the worker, so the users are as they were when last indexed.
+func (r *UserRepository) SearchUsers(ctx context.Context, request model.SearchRequest, searchEmail bool) (model.UserSearchPage, error) {
+	queryFields := userQueryFields
+	if !searchEmail {
+		queryFields = userPublicQueryFields
 	}
 
-	// First, delete the user from the database
-	err = r.db.DeleteUser(objID)
+	engineRequest, err := searchRequest(request, queryFields, userSearchFields, "username", "fullname")
 	if err != nil {
-		return err
+		return model.UserSearchPage{}, err
 	}
 
-	// Next, remove the user data from ElasticSearch
 	indexName := "users" // The name of the Elasticsearch index where user data is stored.
-	err = r.searchEngine.DeleteDocument(indexName, id)
+	results, err := r.searchEngine.Search(ctx, indexName, engineRequest)
 	if err != nil {
-		log.Println("Failed to remove user data from ElasticSearch:", err)
-	} else {
-		log.Println("User data removed successfully from ElasticSearch")
+		log.Println("Error searching for users in ElasticSearch:", err)
+		return model.UserSearchPage{}, err
+	}
+
+	hits, next := pageHits(results.Hits, request.PageLimit())
+	page := model.UserSearchPage{
+		Data:       make([]model.UserSearchHit, 0, len(hits)),
+		Total:      results.Total,
+		NextCursor: next,
 	}
+	for _, hit := range hits {
+		user, err := r.hydrateUser(ctx, hit)
+		if errors.Is(err, ErrUserNotFound) {
+			// Deleted since it was indexed, the worker is about to remove it
+			continue
+		}
+		if err != nil {
+			return model.UserSearchPage{}, err
+		}
 
-	return nil
+		page.Data = append(page.Data, model.UserSearchHit{
+			User:       user,
+			Score:      hit.Score,
+			Highlights: hit.Highlights,
+		})
+	}
+
+	log.Printf("Found %d search results for query: %s\n", results.Total, request.Query)
+	return page, nil
 }
 
-// SearchUsers performs a search query on the user data and returns the results.
-func (r *UserRepository) SearchUsers(query string) ([]search.SearchResult, error) {
+// SuggestUsers returns the usernames starting with the prefix, for completing
+// what a user types
+func (r *UserRepository) SuggestUsers(ctx context.Context, prefix string, limit int) ([]model.Suggestion, error) {
 	indexName := "users" // The name of the Elasticsearch index where user data is stored.
-	searchResults, err := r.searchEngine.Search(indexName, query)
+	suggestions, err := r.searchEngine.Suggest(ctx, indexName, "username.suggest", prefix, limit)
 	if err != nil {
-		log.Println("Error searching for users in ElasticSearch:", err)
+		log.Println("Error suggesting usernames in ElasticSearch:", err)
 		return nil, err
 	}
 
-	log.Printf("Found %d search results for query: %s\n", len(searchResults), query)
-	return searchResults, nil
+	result := make([]model.Suggestion, 0, len(suggestions))
+	for _, suggestion := range suggestions {
+		result = append(result, model.Suggestion{ID: suggestion.ID, Text: suggestion.Text})
+	}
+	return result, nil
+}
+
+// userQueryFields are the fields user search queries may search
+var userQueryFields = search.QueryFields{
+	Default: []string{"username", "fullname"},
+	Named: map[string][]string{
+		"username": {"username"},
+		"fullname": {"fullname"},
+		"email":    {"email"},
+		"role":     {"role"},
+	},
+}
+
+// userPublicQueryFields are the userQueryFields without the email address,
+// for callers not allowed to read it
+var userPublicQueryFields = search.QueryFields{
+	Default: userQueryFields.Default,
+	Named: map[string][]string{
+		"username": {"username"},
+		"fullname": {"fullname"},
+		"role":     {"role"},
+	},
+}
+
+// userSearchFields maps the sort fields of user searches to the indexed fields
+// they sort by, where these differ
+var userSearchFields = map[string]string{
+	"username": "username.raw",
+	"fullname": "fullname.raw",
+}
+
+// hydrateUser decodes the user of a search hit from its source, or reads it from
+// the database when the hit came without one
+func (r *UserRepository) hydrateUser(ctx context.Context, hit search.SearchResult) (model.User, error) {
+	id, err := primitive.ObjectIDFromHex(hit.ID)
+	if err != nil {
+		return model.User{}, fmt.Errorf("invalid user id %q in the search index: %v", hit.ID, err)
+	}
+
+	if len(hit.Source) == 0 {
+		return r.GetUserByID(ctx, hit.ID)
+	}
+
+	var user model.User
+	if err := json.Unmarshal(hit.Source, &user); err != nil {
+		return model.User{}, fmt.Errorf("invalid user %s in the search index: %v", hit.ID, err)
+	}
+	user.ID = id
+
+	return user, nil
 }
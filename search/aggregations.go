@@ -0,0 +1,70 @@
+package search
+
+import "fmt"
+
+// Aggregation groups the documents matching a search into buckets and counts
+// the documents in each
+type Aggregation interface {
+	aggregation()
+}
+
+// TermsAggregation has a bucket per value of a keyword field, the most
+// frequent values first. Every value of a list field counts.
+type TermsAggregation struct {
+	Field string
+	Size  int // Most buckets to return
+}
+
+// DateHistogramAggregation has a bucket per calendar interval of a date
+// field, in chronological order. Intervals without documents are left out.
+type DateHistogramAggregation struct {
+	Field    string
+	Interval string // "day", "month" or "year"
+}
+
+// RangeAggregation has a bucket per range of a numeric or date field, in the
+// order of the ranges. The ranges may overlap.
+type RangeAggregation struct {
+	Field  string
+	Ranges []Range
+}
+
+// Range is a named range of values. From is included and To is not; a nil
+// bound leaves the range open. Dates are given as RFC 3339 strings.
+type Range struct {
+	Key  string
+	From interface{}
+	To   interface{}
+}
+
+func (TermsAggregation) aggregation()         {}
+func (DateHistogramAggregation) aggregation() {}
+func (RangeAggregation) aggregation()         {}
+
+// Bucket is a group of the documents of an aggregation
+type Bucket struct {
+	Key   string // Value, formatted interval or range key
+	Count int64  // Number of matching documents in the bucket
+}
+
+// dateInterval is how the buckets of a calendar interval are keyed
+type dateInterval struct {
+	format string // Elasticsearch date format
+	layout string // The same as a Go layout
+}
+
+// dateIntervals are the calendar intervals of date histograms
+var dateIntervals = map[string]dateInterval{
+	"day":   {format: "yyyy-MM-dd", layout: "2006-01-02"},
+	"month": {format: "yyyy-MM", layout: "2006-01"},
+	"year":  {format: "yyyy", layout: "2006"},
+}
+
+// lookupDateInterval returns the formats of a calendar interval
+func lookupDateInterval(interval string) (dateInterval, error) {
+	i, ok := dateIntervals[interval]
+	if !ok {
+		return dateInterval{}, fmt.Errorf("unknown date histogram interval %q", interval)
+	}
+	return i, nil
+}
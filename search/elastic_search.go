@@ -4,19 +4,27 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"reflect"
+	"strings"
+	"time"
 
 	"github.com/olivere/elastic/v7"
 )
 
+// scanPageSize is how many documents ScanDocuments reads per request
+const scanPageSize = 500
+
 // ElasticSearchEngine is the ElasticSearch implementation of the SearchEngine interface.
 type ElasticSearchEngine struct {
-	client *elastic.Client
+	client  *elastic.Client
+	timeout time.Duration
 }
 
 // NewElasticSearchEngine creates a new instance of ElasticSearchEngine.
 // The function receives the Elasticsearch URL, username, and password to create the Elasticsearch client.
-func NewElasticSearchEngine(url, username, password string) (*ElasticSearchEngine, error) {
+// Every request is bounded by the given timeout in addition to the deadline of the caller's context.
+func NewElasticSearchEngine(url, username, password string, timeout time.Duration) (*ElasticSearchEngine, error) {
 	// Create the Elasticsearch client
 	client, err := elastic.NewClient(
 		elastic.SetURL(url),
@@ -27,12 +35,31 @@ func NewElasticSearchEngine(url, username, password string) (*ElasticSearchEngin
 		return nil, err
 	}
 
-	return &ElasticSearchEngine{client: client}, nil
+	return &ElasticSearchEngine{client: client, timeout: timeout}, nil
 }
 
-func (e *ElasticSearchEngine) IndexDocument(index string, id string, data interface{}) error {
-	ctx := context.Background()
+func (e *ElasticSearchEngine) IndexDocument(ctx context.Context, index string, id string, data interface{}) error {
+	ctx, cancel := withTimeout(ctx, e.timeout)
+	defer cancel()
+
+	docData, err := documentFields(id, data)
+	if err != nil {
+		return err
+	}
+
+	// Use the provided "id" as the Elasticsearch document ID
+	_, err = e.client.Index().
+		Index(index).
+		Id(id).
+		BodyJson(docData).
+		Do(ctx)
+
+	return err
+}
 
+// documentFields converts a struct into the fields of its document, named
+// after their JSON tags
+func documentFields(id string, data interface{}) (map[string]interface{}, error) {
 	// Create a map to store the data fields for indexing
 	docData := make(map[string]interface{})
 
@@ -44,7 +71,7 @@ func (e *ElasticSearchEngine) IndexDocument(index string, id string, data interf
 
 	// Check if the value is a struct
 	if value.Kind() != reflect.Struct {
-		return fmt.Errorf("data must be a struct")
+		return nil, fmt.Errorf("data must be a struct")
 	}
 
 	// Iterate over the fields of the struct and extract the field names and values
@@ -52,63 +79,467 @@ func (e *ElasticSearchEngine) IndexDocument(index string, id string, data interf
 		field := value.Type().Field(i)
 		fieldValue := value.Field(i).Interface()
 		// Use the JSON tag as the Elasticsearch field name, if available
-		jsonTag := field.Tag.Get("json")
-		if jsonTag == "" {
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" || name == "_id" {
+			// Fields hidden from JSON, like password hashes, are never indexed,
+			// and the ID is the document ID, stored as "id" below
+			continue
+		}
+		if name == "" {
 			// If no JSON tag is specified, use the field name as the Elasticsearch field name
-			jsonTag = field.Name
+			name = field.Name
 		}
-		docData[jsonTag] = fieldValue
+		docData[name] = fieldValue
 	}
 
 	// Add the "id" field to the document data
 	docData["id"] = id
 
-	// Use the provided "id" as the Elasticsearch document ID
-	_, err := e.client.Index().
-		Index(index).
-		Id(id).
-		BodyJson(docData).
-		Do(ctx)
-
-	return err
+	return docData, nil
 }
 
 // DeleteDocument removes a document from the Elasticsearch index by its ID.
-func (e *ElasticSearchEngine) DeleteDocument(index, docID string) error {
-	ctx := context.Background()
+// A document that is not in the index counts as removed.
+func (e *ElasticSearchEngine) DeleteDocument(ctx context.Context, index, docID string) error {
+	ctx, cancel := withTimeout(ctx, e.timeout)
+	defer cancel()
 
 	_, err := e.client.Delete().
 		Index(index).
 		Id(docID).
 		Do(ctx)
+	if elastic.IsNotFound(err) {
+		return nil
+	}
 
 	return err
 }
 
-// Search performs a search query on the Elasticsearch index and returns the results.
-func (e *ElasticSearchEngine) Search(index, query string) ([]SearchResult, error) {
-	ctx := context.Background()
+// Search runs the query on the Elasticsearch index as a bool query. Scores are
+// tracked even when sorting by another field, and the "id" field breaks ties
+// so search_after resumes at the right hit.
+func (e *ElasticSearchEngine) Search(ctx context.Context, index string, request SearchRequest) (SearchResults, error) {
+	ctx, cancel := withTimeout(ctx, e.timeout)
+	defer cancel()
+
+	query := elasticQuery(request.Query)
+	if len(request.Filters) > 0 {
+		boolQuery := elastic.NewBoolQuery().Must(query)
+		for _, filter := range request.Filters {
+			boolQuery.Filter(elasticQuery(filter))
+		}
+		query = boolQuery
+	}
+
+	service := e.client.Search(index).
+		Query(query).
+		Size(request.Size).
+		TrackTotalHits(true).
+		TrackScores(true)
+
+	for _, field := range request.Sort {
+		if field.Field == ScoreField {
+			service = service.SortBy(elastic.NewScoreSort().Order(!field.Desc))
+			continue
+		}
+		service = service.SortBy(elastic.NewFieldSort(field.Field).Order(!field.Desc))
+	}
+	if len(request.Sort) == 0 {
+		service = service.SortBy(elastic.NewScoreSort())
+	}
+	service = service.SortBy(elastic.NewFieldSort("id"))
+
+	if len(request.After) > 0 {
+		service = service.SearchAfter(request.After...)
+	} else if request.From > 0 {
+		service = service.From(request.From)
+	}
+
+	if len(request.Highlight) > 0 {
+		highlight := elastic.NewHighlight()
+		for _, field := range request.Highlight {
+			highlight = highlight.Fields(elastic.NewHighlighterField(field))
+		}
+		service = service.Highlight(highlight)
+	}
+
+	for name, aggregation := range request.Aggregations {
+		agg, err := elasticAggregation(aggregation)
+		if err != nil {
+			return SearchResults{}, err
+		}
+		service = service.Aggregation(name, agg)
+	}
+
+	result, err := service.Do(ctx)
+	if err != nil {
+		return SearchResults{}, err
+	}
+
+	// Extract the search results
+	results := SearchResults{Total: result.TotalHits()}
+	if len(request.Aggregations) > 0 {
+		results.Aggregations = make(map[string][]Bucket, len(request.Aggregations))
+		for name, aggregation := range request.Aggregations {
+			results.Aggregations[name] = elasticBuckets(result.Aggregations, name, aggregation)
+		}
+	}
+	for _, hit := range result.Hits.Hits {
+		sr := SearchResult{
+			ID:         hit.Id,
+			Source:     hit.Source,
+			Highlights: hit.Highlight,
+			Sort:       hit.Sort,
+		}
+		if hit.Score != nil {
+			sr.Score = *hit.Score
+		}
+		results.Hits = append(results.Hits, sr)
+	}
+
+	return results, nil
+}
+
+// Suggest asks the completion suggester of the field. The completion fields
+// are the "suggest" multi-fields of the mappings, so field names one of them,
+// like "title.suggest".
+func (e *ElasticSearchEngine) Suggest(ctx context.Context, index string, field string, prefix string, limit int) ([]Suggestion, error) {
+	ctx, cancel := withTimeout(ctx, e.timeout)
+	defer cancel()
+
+	suggester := elastic.NewCompletionSuggester("suggest").
+		Field(field).
+		Prefix(prefix).
+		Size(limit).
+		SkipDuplicates(true)
 
-	// Perform the search query
 	result, err := e.client.Search(index).
-		Query(elastic.NewQueryStringQuery(query)).
+		Suggester(suggester).
+		FetchSource(false).
+		Size(0).
 		Do(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	// Extract the search results
-	var searchResults []SearchResult
+	var suggestions []Suggestion
+	for _, suggestion := range result.Suggest["suggest"] {
+		for _, option := range suggestion.Options {
+			suggestions = append(suggestions, Suggestion{ID: option.Id, Text: option.Text})
+		}
+	}
+
+	return suggestions, nil
+}
+
+// MoreLikeThis finds the documents similar to the indexed document with a
+// more_like_this query. Terms count from their first occurrence, so short
+// posts and small indices still find related documents.
+func (e *ElasticSearchEngine) MoreLikeThis(ctx context.Context, index string, id string, fields []string, size int) ([]SearchResult, error) {
+	ctx, cancel := withTimeout(ctx, e.timeout)
+	defer cancel()
+
+	like := elastic.NewMoreLikeThisQuery().
+		Field(fields...).
+		LikeItems(elastic.NewMoreLikeThisQueryItem().Index(index).Id(id)).
+		MinTermFreq(1).
+		MinDocFreq(1)
+
+	// The document itself is excluded by ID as well, since more_like_this
+	// does not recognize it when the index is an alias
+	query := elastic.NewBoolQuery().
+		Must(like).
+		MustNot(elastic.NewIdsQuery().Ids(id))
+
+	result, err := e.client.Search(index).
+		Query(query).
+		Size(size).
+		Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var hits []SearchResult
 	for _, hit := range result.Hits.Hits {
-		var sr SearchResult
-		err := json.Unmarshal(hit.Source, &sr)
+		sr := SearchResult{
+			ID:     hit.Id,
+			Source: hit.Source,
+		}
+		if hit.Score != nil {
+			sr.Score = *hit.Score
+		}
+		hits = append(hits, sr)
+	}
+
+	return hits, nil
+}
+
+// elasticQuery translates a parsed query into the Elasticsearch query DSL.
+// Terms are matched through the analyzers of the fields, prefixes as phrase
+// prefixes, so a prefix is matched against the analyzed words as well.
+func elasticQuery(query Query) elastic.Query {
+	switch q := query.(type) {
+	case TermQuery:
+		if q.Prefix {
+			return elastic.NewMultiMatchQuery(q.Term, q.Fields...).Type("phrase_prefix")
+		}
+		return elastic.NewMultiMatchQuery(q.Term, q.Fields...).Operator("and")
+	case PhraseQuery:
+		return elastic.NewMultiMatchQuery(q.Phrase, q.Fields...).Type("phrase")
+	case ExactQuery:
+		return elastic.NewTermsQueryFromStrings(q.Field, q.Values...)
+	case RangeQuery:
+		rangeQuery := elastic.NewRangeQuery(q.Field)
+		if q.From != nil {
+			rangeQuery.Gte(q.From)
+		}
+		if q.To != nil {
+			rangeQuery.Lt(q.To)
+		}
+		return rangeQuery
+	case BoolQuery:
+		boolQuery := elastic.NewBoolQuery()
+		for _, clause := range q.Must {
+			boolQuery.Must(elasticQuery(clause))
+		}
+		for _, clause := range q.Should {
+			boolQuery.Should(elasticQuery(clause))
+		}
+		for _, clause := range q.MustNot {
+			boolQuery.MustNot(elasticQuery(clause))
+		}
+		if len(q.Should) > 0 {
+			boolQuery.MinimumNumberShouldMatch(1)
+		}
+		return boolQuery
+	}
+	return elastic.NewMatchAllQuery()
+}
+
+// elasticAggregation translates an aggregation into the Elasticsearch
+// aggregation DSL. Date histograms use calendar intervals in UTC.
+func elasticAggregation(aggregation Aggregation) (elastic.Aggregation, error) {
+	switch a := aggregation.(type) {
+	case TermsAggregation:
+		return elastic.NewTermsAggregation().Field(a.Field).Size(a.Size), nil
+	case DateHistogramAggregation:
+		interval, err := lookupDateInterval(a.Interval)
 		if err != nil {
 			return nil, err
 		}
-		searchResults = append(searchResults, sr)
+		return elastic.NewDateHistogramAggregation().
+			Field(a.Field).
+			CalendarInterval(a.Interval).
+			Format(interval.format).
+			MinDocCount(1), nil
+	case RangeAggregation:
+		rangeAggregation := elastic.NewRangeAggregation().Field(a.Field)
+		for _, r := range a.Ranges {
+			rangeAggregation = rangeAggregation.AddRangeWithKey(r.Key, r.From, r.To)
+		}
+		return rangeAggregation, nil
+	}
+	return nil, fmt.Errorf("unsupported aggregation %T", aggregation)
+}
+
+// elasticBuckets reads the buckets of an aggregation from the response
+func elasticBuckets(aggregations elastic.Aggregations, name string, aggregation Aggregation) []Bucket {
+	var buckets []Bucket
+	switch aggregation.(type) {
+	case TermsAggregation:
+		if items, ok := aggregations.Terms(name); ok {
+			for _, item := range items.Buckets {
+				buckets = append(buckets, Bucket{Key: fmt.Sprint(item.Key), Count: item.DocCount})
+			}
+		}
+	case DateHistogramAggregation:
+		if items, ok := aggregations.DateHistogram(name); ok {
+			for _, item := range items.Buckets {
+				key := fmt.Sprint(item.Key)
+				if item.KeyAsString != nil {
+					key = *item.KeyAsString
+				}
+				buckets = append(buckets, Bucket{Key: key, Count: item.DocCount})
+			}
+		}
+	case RangeAggregation:
+		if items, ok := aggregations.Range(name); ok {
+			for _, item := range items.Buckets {
+				buckets = append(buckets, Bucket{Key: item.Key, Count: item.DocCount})
+			}
+		}
+	}
+	return buckets
+}
+
+// CreateIndex creates an empty index.
+func (e *ElasticSearchEngine) CreateIndex(ctx context.Context, index string) error {
+	ctx, cancel := withTimeout(ctx, e.timeout)
+	defer cancel()
+
+	_, err := e.client.CreateIndex(index).Do(ctx)
+	return err
+}
+
+// DeleteIndex removes an index and its documents.
+func (e *ElasticSearchEngine) DeleteIndex(ctx context.Context, index string) error {
+	ctx, cancel := withTimeout(ctx, e.timeout)
+	defer cancel()
+
+	_, err := e.client.DeleteIndex(index).Do(ctx)
+	return err
+}
+
+// BulkIndex indexes the documents with a single bulk request.
+func (e *ElasticSearchEngine) BulkIndex(ctx context.Context, index string, docs []Document) error {
+	if len(docs) == 0 {
+		return nil
+	}
+
+	ctx, cancel := withTimeout(ctx, e.timeout)
+	defer cancel()
+
+	bulk := e.client.Bulk().Index(index)
+	for _, doc := range docs {
+		docData, err := documentFields(doc.ID, doc.Data)
+		if err != nil {
+			return err
+		}
+		bulk.Add(elastic.NewBulkIndexRequest().Id(doc.ID).Doc(docData))
+	}
+
+	result, err := bulk.Do(ctx)
+	if err != nil {
+		return err
+	}
+
+	if failed := result.Failed(); len(failed) > 0 {
+		reason := "unknown error"
+		if failed[0].Error != nil {
+			reason = failed[0].Error.Reason
+		}
+		return fmt.Errorf("failed to index %d of %d documents, the first %s: %s", len(failed), len(docs), failed[0].Id, reason)
+	}
+
+	return nil
+}
+
+// SwapAlias moves the alias to the index with a single update of the aliases,
+// which Elasticsearch applies atomically.
+func (e *ElasticSearchEngine) SwapAlias(ctx context.Context, alias string, index string) ([]string, error) {
+	ctx, cancel := withTimeout(ctx, e.timeout)
+	defer cancel()
+
+	// Getting the alias returns the indices it points to, or the index itself
+	current, err := e.client.IndexGet(alias).Do(ctx)
+	if err != nil && !elastic.IsNotFound(err) {
+		return nil, err
 	}
 
-	return searchResults, nil
+	var previous []string
+	actions := []elastic.AliasAction{elastic.NewAliasAddAction(alias).Index(index)}
+	for name := range current {
+		switch name {
+		case index:
+		case alias:
+			actions = append(actions, elastic.NewAliasRemoveIndexAction(name))
+		default:
+			actions = append(actions, elastic.NewAliasRemoveAction(alias).Index(name))
+			previous = append(previous, name)
+		}
+	}
+
+	_, err = e.client.Alias().Action(actions...).Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return previous, nil
+}
+
+// ScanDocuments reads the documents with the scroll API, a page at a time.
+// The timeout applies to every page.
+func (e *ElasticSearchEngine) ScanDocuments(ctx context.Context, index string, fn func(id string, source json.RawMessage) error) error {
+	scroll := e.client.Scroll(index).Size(scanPageSize)
+	defer scroll.Clear(context.Background())
+
+	for {
+		pageCtx, cancel := withTimeout(ctx, e.timeout)
+		result, err := scroll.Do(pageCtx)
+		cancel()
+
+		if err == io.EOF || elastic.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, hit := range result.Hits.Hits {
+			if err := fn(hit.Id, hit.Source); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// PutIndexTemplates creates or updates a composable index template per
+// definition, named after the index.
+func (e *ElasticSearchEngine) PutIndexTemplates(ctx context.Context, definitions []IndexDefinition) error {
+	ctx, cancel := withTimeout(ctx, e.timeout)
+	defer cancel()
+
+	for _, definition := range definitions {
+		_, err := e.client.IndexPutIndexTemplate(definition.Name).
+			BodyJson(definition.Template()).
+			Do(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to put the index template of %s: %v", definition.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// MappingVersion reads the definition version from the _meta of the mappings.
+// Should an alias point to several indices, the oldest version is returned.
+func (e *ElasticSearchEngine) MappingVersion(ctx context.Context, index string) (int, error) {
+	ctx, cancel := withTimeout(ctx, e.timeout)
+	defer cancel()
+
+	indices, err := e.client.IndexGet(index).Feature("_mappings").Do(ctx)
+	if elastic.IsNotFound(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	version := -1
+	for _, info := range indices {
+		v := 0
+		if meta, ok := info.Mappings["_meta"].(map[string]interface{}); ok {
+			if n, ok := meta[MappingVersionKey].(float64); ok {
+				v = int(n)
+			}
+		}
+		if version < 0 || v < version {
+			version = v
+		}
+	}
+	if version < 0 {
+		return 0, nil
+	}
+
+	return version, nil
+}
+
+// withTimeout bounds the context by the configured per-request timeout
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
 }
 
 // Add more methods as needed based on your Elasticsearch requirements.
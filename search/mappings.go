@@ -0,0 +1,172 @@
+package search
+
+import "strings"
+
+// IndexDefinition declares the settings and mappings of a search index.
+// The index is used through an alias of its name, pointing at a versioned
+// index named <name>_<suffix>; both names get the definition from an index
+// template. The version is stored in the _meta of the mappings. Bump it on
+// every change that existing indices cannot take, so they are rebuilt.
+type IndexDefinition struct {
+	Name     string
+	Version  int
+	Settings map[string]interface{}
+	Mappings map[string]interface{}
+}
+
+// MappingVersionKey is the key of the definition version in the _meta of the mappings
+const MappingVersionKey = "mapping_version"
+
+// Patterns returns the index names the definition applies to
+func (d IndexDefinition) Patterns() []string {
+	return []string{d.Name, d.Name + "_*"}
+}
+
+// Matches reports whether the definition applies to the index
+func (d IndexDefinition) Matches(index string) bool {
+	return index == d.Name || strings.HasPrefix(index, d.Name+"_")
+}
+
+// Template returns the body of the composable index template of the definition
+func (d IndexDefinition) Template() map[string]interface{} {
+	mappings := map[string]interface{}{
+		"_meta": map[string]interface{}{MappingVersionKey: d.Version},
+	}
+	for key, value := range d.Mappings {
+		mappings[key] = value
+	}
+
+	return map[string]interface{}{
+		"index_patterns": d.Patterns(),
+		"template": map[string]interface{}{
+			"settings": d.Settings,
+			"mappings": mappings,
+		},
+	}
+}
+
+// indexDefinitions are the definitions of every index the repositories use
+var indexDefinitions = []IndexDefinition{
+	{
+		Name:    "posts",
+		Version: 3,
+		Settings: map[string]interface{}{
+			"analysis": map[string]interface{}{
+				"normalizer": lowercaseNormalizer,
+			},
+		},
+		Mappings: map[string]interface{}{
+			// Fields missing below are kept in the source but not indexed
+			"dynamic": false,
+			"properties": map[string]interface{}{
+				"id": keywordField,
+				"title": map[string]interface{}{
+					"type":     "text",
+					"analyzer": "english",
+					"fields": map[string]interface{}{
+						"exact":   map[string]interface{}{"type": "text", "analyzer": "standard"},
+						"raw":     map[string]interface{}{"type": "keyword", "normalizer": "lowercase_keyword", "ignore_above": 256},
+						"suggest": completionField,
+					},
+				},
+				"body": map[string]interface{}{
+					"type":     "text",
+					"analyzer": "english",
+					"fields": map[string]interface{}{
+						"exact": map[string]interface{}{"type": "text", "analyzer": "standard"},
+					},
+				},
+				"tags":       map[string]interface{}{"type": "keyword", "normalizer": "lowercase_keyword"},
+				"author_id":  keywordField,
+				"version":    longField,
+				"created_at": dateField,
+				"updated_at": dateField,
+				"deleted_at": dateField,
+			},
+		},
+	},
+	{
+		Name:    "users",
+		Version: 2,
+		Settings: map[string]interface{}{
+			"analysis": map[string]interface{}{
+				"normalizer": lowercaseNormalizer,
+				"filter": map[string]interface{}{
+					"username_edge_ngram": map[string]interface{}{
+						"type":     "edge_ngram",
+						"min_gram": 1,
+						"max_gram": 32,
+					},
+				},
+				"analyzer": map[string]interface{}{
+					// Indexes every prefix of a username, so partial usernames match
+					"username_prefix": map[string]interface{}{
+						"type":      "custom",
+						"tokenizer": "keyword",
+						"filter":    []string{"lowercase", "username_edge_ngram"},
+					},
+					"username": map[string]interface{}{
+						"type":      "custom",
+						"tokenizer": "keyword",
+						"filter":    []string{"lowercase"},
+					},
+				},
+			},
+		},
+		Mappings: map[string]interface{}{
+			"dynamic": false,
+			"properties": map[string]interface{}{
+				"id": keywordField,
+				"username": map[string]interface{}{
+					"type":            "text",
+					"analyzer":        "username_prefix",
+					"search_analyzer": "username",
+					"fields": map[string]interface{}{
+						"raw":     map[string]interface{}{"type": "keyword", "normalizer": "lowercase_keyword"},
+						"suggest": completionField,
+					},
+				},
+				"fullname": map[string]interface{}{
+					"type":     "text",
+					"analyzer": "standard",
+					"fields": map[string]interface{}{
+						"raw": map[string]interface{}{"type": "keyword", "normalizer": "lowercase_keyword", "ignore_above": 256},
+					},
+				},
+				"email":      map[string]interface{}{"type": "keyword", "normalizer": "lowercase_keyword"},
+				"role":       keywordField,
+				"version":    longField,
+				"created_at": dateField,
+				"updated_at": dateField,
+				"deleted_at": dateField,
+			},
+		},
+	},
+}
+
+// Mappings shared by the definitions
+var (
+	keywordField = map[string]interface{}{"type": "keyword"}
+	longField    = map[string]interface{}{"type": "long"}
+	dateField    = map[string]interface{}{"type": "date"}
+	// Serves the suggestions of Suggest
+	completionField     = map[string]interface{}{"type": "completion"}
+	lowercaseNormalizer = map[string]interface{}{
+		"lowercase_keyword": map[string]interface{}{"type": "custom", "filter": []string{"lowercase"}},
+	}
+)
+
+// IndexDefinitions returns the definitions of every index
+func IndexDefinitions() []IndexDefinition {
+	return append([]IndexDefinition(nil), indexDefinitions...)
+}
+
+// LookupIndexDefinition returns the definition applying to the index or alias
+func LookupIndexDefinition(index string) (IndexDefinition, bool) {
+	for _, definition := range indexDefinitions {
+		if definition.Matches(index) {
+			return definition, true
+		}
+	}
+	return IndexDefinition{}, false
+}
@@ -0,0 +1,865 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// MemorySearchEngine is a thread-safe in-memory implementation of the SearchEngine interface.
+// Text is split into lowercase words of letters and digits, a rough take on
+// the analyzers used with Elasticsearch, and the score of a document is the
+// number of terms and phrases of the query it matches.
+type MemorySearchEngine struct {
+	mu      sync.RWMutex
+	indices map[string]map[string]map[string]interface{}
+	aliases map[string]string // Alias to the index it points to
+
+	templates []IndexDefinition
+	versions  map[string]int // Definition version each index was created with
+}
+
+// NewMemorySearchEngine creates a new instance of MemorySearchEngine.
+func NewMemorySearchEngine() *MemorySearchEngine {
+	return &MemorySearchEngine{
+		indices:  make(map[string]map[string]map[string]interface{}),
+		aliases:  make(map[string]string),
+		versions: make(map[string]int),
+	}
+}
+
+// createIndex adds an empty index, taking the version of the template applying to it
+func (e *MemorySearchEngine) createIndex(index string) {
+	e.indices[index] = make(map[string]map[string]interface{})
+	for _, definition := range e.templates {
+		if definition.Matches(index) {
+			e.versions[index] = definition.Version
+		}
+	}
+}
+
+// resolve returns the index an alias points to, or the name itself
+func (e *MemorySearchEngine) resolve(name string) string {
+	if index, ok := e.aliases[name]; ok {
+		return index
+	}
+	return name
+}
+
+// IndexDocument stores the JSON representation of the data under the given ID.
+func (e *MemorySearchEngine) IndexDocument(ctx context.Context, index string, id string, data interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	docData, err := memoryDocument(id, data)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	index = e.resolve(index)
+	if e.indices[index] == nil {
+		e.createIndex(index)
+	}
+	e.indices[index][id] = docData
+
+	return nil
+}
+
+// memoryDocument converts the data into the stored document, a map of its JSON fields
+func memoryDocument(id string, data interface{}) (map[string]interface{}, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	docData := make(map[string]interface{})
+	if err := json.Unmarshal(raw, &docData); err != nil {
+		return nil, fmt.Errorf("data must be a struct")
+	}
+
+	// Add the "id" field to the document data
+	docData["id"] = id
+
+	return docData, nil
+}
+
+// DeleteDocument removes a document from the index by its ID, if it is there.
+func (e *MemorySearchEngine) DeleteDocument(ctx context.Context, index, docID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	delete(e.indices[e.resolve(index)], docID)
+
+	return nil
+}
+
+// Search returns a page of the documents matching the query. A sort field
+// naming a multi-field, such as "title.raw", sorts by its field, with strings
+// compared regardless of case, and highlights wrap the matching words of the
+// whole field.
+func (e *MemorySearchEngine) Search(ctx context.Context, index string, request SearchRequest) (SearchResults, error) {
+	if err := ctx.Err(); err != nil {
+		return SearchResults{}, err
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	docs, ok := e.indices[e.resolve(index)]
+	if !ok {
+		return SearchResults{}, fmt.Errorf("index %s not found", index)
+	}
+
+	sortFields := request.Sort
+	if len(sortFields) == 0 {
+		sortFields = []SortField{{Field: ScoreField, Desc: true}}
+	}
+
+	terms := positiveTerms(request.Query, nil)
+
+	var hits []SearchResult
+	var matches []map[string]interface{}
+	for id, doc := range docs {
+		matched, score := evaluate(doc, request.Query)
+		for _, filter := range request.Filters {
+			if ok, _ := evaluate(doc, filter); !ok {
+				matched = false
+			}
+		}
+		if !matched {
+			continue
+		}
+		matches = append(matches, doc)
+
+		source, err := json.Marshal(doc)
+		if err != nil {
+			return SearchResults{}, err
+		}
+
+		hits = append(hits, SearchResult{
+			ID:         id,
+			Score:      score,
+			Source:     source,
+			Highlights: highlight(doc, terms, request.Highlight),
+			Sort:       sortValues(doc, score, sortFields),
+		})
+	}
+
+	var aggregations map[string][]Bucket
+	if len(request.Aggregations) > 0 {
+		aggregations = make(map[string][]Bucket, len(request.Aggregations))
+		for name, aggregation := range request.Aggregations {
+			buckets, err := aggregate(matches, aggregation)
+			if err != nil {
+				return SearchResults{}, err
+			}
+			aggregations[name] = buckets
+		}
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		return compareSortValues(hits[i].Sort, hits[j].Sort, sortFields) < 0
+	})
+
+	start := request.From
+	if len(request.After) > 0 {
+		start = sort.Search(len(hits), func(i int) bool {
+			return compareSortValues(hits[i].Sort, request.After, sortFields) > 0
+		})
+	}
+	if start > len(hits) {
+		start = len(hits)
+	}
+	end := start + request.Size
+	if end > len(hits) {
+		end = len(hits)
+	}
+
+	return SearchResults{Total: int64(len(hits)), Hits: hits[start:end], Aggregations: aggregations}, nil
+}
+
+// aggregate counts the documents in the buckets of the aggregation
+func aggregate(docs []map[string]interface{}, aggregation Aggregation) ([]Bucket, error) {
+	var buckets []Bucket
+	switch a := aggregation.(type) {
+	case TermsAggregation:
+		counts := make(map[string]int64)
+		for _, doc := range docs {
+			for _, value := range fieldValues(doc, a.Field) {
+				counts[value]++
+			}
+		}
+		buckets = countedBuckets(counts)
+		sort.Slice(buckets, func(i, j int) bool {
+			if buckets[i].Count != buckets[j].Count {
+				return buckets[i].Count > buckets[j].Count
+			}
+			return buckets[i].Key < buckets[j].Key
+		})
+		if a.Size > 0 && len(buckets) > a.Size {
+			buckets = buckets[:a.Size]
+		}
+
+	case DateHistogramAggregation:
+		interval, err := lookupDateInterval(a.Interval)
+		if err != nil {
+			return nil, err
+		}
+		counts := make(map[string]int64)
+		for _, doc := range docs {
+			if t, ok := toTime(doc[a.Field]); ok {
+				counts[t.UTC().Format(interval.layout)]++
+			}
+		}
+		// The keys are zero-padded, so they sort chronologically
+		buckets = countedBuckets(counts)
+		sort.Slice(buckets, func(i, j int) bool {
+			return buckets[i].Key < buckets[j].Key
+		})
+
+	case RangeAggregation:
+		for _, r := range a.Ranges {
+			bucket := Bucket{Key: r.Key}
+			for _, doc := range docs {
+				if inRange(doc[a.Field], r.From, r.To) {
+					bucket.Count++
+				}
+			}
+			buckets = append(buckets, bucket)
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported aggregation %T", aggregation)
+	}
+
+	return buckets, nil
+}
+
+func countedBuckets(counts map[string]int64) []Bucket {
+	buckets := make([]Bucket, 0, len(counts))
+	for key, count := range counts {
+		buckets = append(buckets, Bucket{Key: key, Count: count})
+	}
+	return buckets
+}
+
+// fieldValues returns the distinct values of a keyword field or list field
+func fieldValues(doc map[string]interface{}, field string) []string {
+	switch value := doc[field].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var values []string
+		seen := make(map[string]bool)
+		for _, item := range value {
+			if text, ok := item.(string); ok && !seen[text] {
+				seen[text] = true
+				values = append(values, text)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// inRange reports whether the value lies in the range. Values and bounds
+// that all parse as RFC 3339 dates are compared as dates.
+func inRange(value interface{}, from interface{}, to interface{}) bool {
+	if value == nil {
+		return false
+	}
+	if from != nil && compareRangeValues(value, from) < 0 {
+		return false
+	}
+	if to != nil && compareRangeValues(value, to) >= 0 {
+		return false
+	}
+	return true
+}
+
+func compareRangeValues(a, b interface{}) int {
+	x, xok := toTime(a)
+	y, yok := toTime(b)
+	if xok && yok {
+		switch {
+		case x.Before(y):
+			return -1
+		case x.After(y):
+			return 1
+		}
+		return 0
+	}
+	return compareValues(a, b)
+}
+
+func toTime(value interface{}) (time.Time, bool) {
+	text, ok := value.(string)
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, text)
+	return t, err == nil
+}
+
+// MoreLikeThis scores the other documents by the terms of the fields they
+// share with the document, each weighted by how rare it is in the index.
+func (e *MemorySearchEngine) MoreLikeThis(ctx context.Context, index string, id string, fields []string, size int) ([]SearchResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	docs, ok := e.indices[e.resolve(index)]
+	if !ok {
+		return nil, fmt.Errorf("index %s not found", index)
+	}
+
+	source, ok := docs[id]
+	if !ok {
+		return nil, nil
+	}
+
+	terms := make(map[string]bool)
+	for _, field := range fields {
+		for _, word := range fieldWords(source, field) {
+			terms[word] = true
+		}
+	}
+
+	// Count the documents containing each term
+	docTerms := make(map[string]map[string]bool, len(docs))
+	frequencies := make(map[string]int)
+	for docID, doc := range docs {
+		words := make(map[string]bool)
+		for _, field := range fields {
+			for _, word := range fieldWords(doc, field) {
+				if terms[word] && !words[word] {
+					words[word] = true
+					frequencies[word]++
+				}
+			}
+		}
+		docTerms[docID] = words
+	}
+
+	var hits []SearchResult
+	for docID, words := range docTerms {
+		if docID == id || len(words) == 0 {
+			continue
+		}
+
+		score := 0.0
+		for word := range words {
+			score += math.Log(1 + float64(len(docs))/float64(frequencies[word]))
+		}
+
+		data, err := json.Marshal(docs[docID])
+		if err != nil {
+			return nil, err
+		}
+		hits = append(hits, SearchResult{ID: docID, Score: score, Source: data})
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID < hits[j].ID
+	})
+	if len(hits) > size {
+		hits = hits[:size]
+	}
+
+	return hits, nil
+}
+
+// Suggest returns the distinct values of the field starting with the prefix,
+// in alphabetical order. Like with sorting, a multi-field such as
+// "title.suggest" stands for its field.
+func (e *MemorySearchEngine) Suggest(ctx context.Context, index string, field string, prefix string, limit int) ([]Suggestion, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	docs, ok := e.indices[e.resolve(index)]
+	if !ok {
+		return nil, fmt.Errorf("index %s not found", index)
+	}
+
+	field = strings.SplitN(field, ".", 2)[0]
+	prefix = strings.ToLower(prefix)
+
+	seen := make(map[string]bool)
+	var suggestions []Suggestion
+	for id, doc := range docs {
+		text, ok := doc[field].(string)
+		if !ok || !strings.HasPrefix(strings.ToLower(text), prefix) || seen[text] {
+			continue
+		}
+		seen[text] = true
+		suggestions = append(suggestions, Suggestion{ID: id, Text: text})
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Text != suggestions[j].Text {
+			return suggestions[i].Text < suggestions[j].Text
+		}
+		return suggestions[i].ID < suggestions[j].ID
+	})
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+
+	return suggestions, nil
+}
+
+// sortValues returns the values a document is sorted by, followed by its ID
+func sortValues(doc map[string]interface{}, score float64, fields []SortField) []interface{} {
+	values := make([]interface{}, 0, len(fields)+1)
+	for _, field := range fields {
+		if field.Field == ScoreField {
+			values = append(values, score)
+			continue
+		}
+
+		value := doc[strings.SplitN(field.Field, ".", 2)[0]]
+		if text, ok := value.(string); ok {
+			value = strings.ToLower(text)
+		}
+		values = append(values, value)
+	}
+	return append(values, doc["id"])
+}
+
+// compareSortValues compares the sort values of two hits in the order of the
+// fields. Missing values sort last either way.
+func compareSortValues(a, b []interface{}, fields []SortField) int {
+	for i := range a {
+		if i >= len(b) {
+			return 1
+		}
+
+		switch {
+		case a[i] == nil && b[i] == nil:
+			continue
+		case a[i] == nil:
+			return 1
+		case b[i] == nil:
+			return -1
+		}
+
+		c := compareValues(a[i], b[i])
+		if i < len(fields) && fields[i].Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// compareValues compares two numbers, which may have been decoded from a
+// cursor, or the text of any other values
+func compareValues(a, b interface{}) int {
+	x, xok := toFloat(a)
+	y, yok := toFloat(b)
+	if xok && yok {
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// highlight wraps the words matching the terms in <em> tags, returning the
+// fields where any word matched
+func highlight(doc map[string]interface{}, terms []TermQuery, fields []string) map[string][]string {
+	highlights := make(map[string][]string)
+	for _, field := range fields {
+		text, ok := doc[field].(string)
+		if !ok {
+			continue
+		}
+
+		matched := false
+		words := strings.Fields(text)
+		for i, word := range words {
+			if highlighted(word, field, terms) {
+				words[i] = "<em>" + word + "</em>"
+				matched = true
+			}
+		}
+		if matched {
+			highlights[field] = []string{strings.Join(words, " ")}
+		}
+	}
+
+	if len(highlights) == 0 {
+		return nil
+	}
+	return highlights
+}
+
+// highlighted reports whether a word of the field matches any word of the terms
+func highlighted(word string, field string, terms []TermQuery) bool {
+	for _, term := range terms {
+		if !searches(term.Fields, field) {
+			continue
+		}
+		termWords := analyze(term.Term)
+		for _, w := range analyze(word) {
+			for i, t := range termWords {
+				if w == t || (term.Prefix && i == len(termWords)-1 && strings.HasPrefix(w, t)) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// CreateIndex creates an empty index
+func (e *MemorySearchEngine) CreateIndex(ctx context.Context, index string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.indices[index]; ok {
+		return fmt.Errorf("index %s already exists", index)
+	}
+	e.createIndex(index)
+
+	return nil
+}
+
+// DeleteIndex removes an index and its documents
+func (e *MemorySearchEngine) DeleteIndex(ctx context.Context, index string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	delete(e.indices, index)
+	delete(e.versions, index)
+	for alias, target := range e.aliases {
+		if target == index {
+			delete(e.aliases, alias)
+		}
+	}
+
+	return nil
+}
+
+// BulkIndex stores the documents in the index at once.
+func (e *MemorySearchEngine) BulkIndex(ctx context.Context, index string, docs []Document) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	converted := make(map[string]map[string]interface{}, len(docs))
+	for _, doc := range docs {
+		docData, err := memoryDocument(doc.ID, doc.Data)
+		if err != nil {
+			return err
+		}
+		converted[doc.ID] = docData
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	index = e.resolve(index)
+	if e.indices[index] == nil {
+		e.createIndex(index)
+	}
+	for id, docData := range converted {
+		e.indices[index][id] = docData
+	}
+
+	return nil
+}
+
+// SwapAlias points the alias at the index, replacing an index of the alias's name
+func (e *MemorySearchEngine) SwapAlias(ctx context.Context, alias string, index string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.indices[index]; !ok {
+		return nil, fmt.Errorf("index %s not found", index)
+	}
+
+	var previous []string
+	if target, ok := e.aliases[alias]; ok && target != index {
+		previous = append(previous, target)
+	}
+	delete(e.indices, alias)
+	delete(e.versions, alias)
+	e.aliases[alias] = index
+
+	return previous, nil
+}
+
+// ScanDocuments passes every document of the index to fn, in no particular order.
+func (e *MemorySearchEngine) ScanDocuments(ctx context.Context, index string, fn func(id string, source json.RawMessage) error) error {
+	e.mu.RLock()
+	docs := make(map[string]json.RawMessage, len(e.indices[e.resolve(index)]))
+	for id, docData := range e.indices[e.resolve(index)] {
+		raw, err := json.Marshal(docData)
+		if err != nil {
+			e.mu.RUnlock()
+			return err
+		}
+		docs[id] = raw
+	}
+	e.mu.RUnlock()
+
+	for id, source := range docs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(id, source); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PutIndexTemplates remembers the definitions. Documents are not analyzed,
+// so only their versions matter.
+func (e *MemorySearchEngine) PutIndexTemplates(ctx context.Context, definitions []IndexDefinition) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.templates = append([]IndexDefinition(nil), definitions...)
+	return nil
+}
+
+// MappingVersion returns the definition version the index was created with
+func (e *MemorySearchEngine) MappingVersion(ctx context.Context, index string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.versions[e.resolve(index)], nil
+}
+
+// evaluate reports whether the document matches the query, along with its
+// score: the number of terms and phrases it matches
+func evaluate(doc map[string]interface{}, query Query) (bool, float64) {
+	switch q := query.(type) {
+	case TermQuery:
+		for _, field := range q.Fields {
+			if containsTerm(fieldWords(doc, field), analyze(q.Term), q.Prefix) {
+				return true, 1
+			}
+		}
+		return false, 0
+
+	case PhraseQuery:
+		for _, field := range q.Fields {
+			if containsPhrase(fieldWords(doc, field), analyze(q.Phrase)) {
+				return true, 1
+			}
+		}
+		return false, 0
+
+	case ExactQuery:
+		for _, value := range fieldValues(doc, q.Field) {
+			for _, v := range q.Values {
+				if value == v {
+					return true, 1
+				}
+			}
+		}
+		return false, 0
+
+	case RangeQuery:
+		if !inRange(doc[q.Field], q.From, q.To) {
+			return false, 0
+		}
+		return true, 1
+
+	case BoolQuery:
+		score := 0.0
+		for _, clause := range q.Must {
+			matched, s := evaluate(doc, clause)
+			if !matched {
+				return false, 0
+			}
+			score += s
+		}
+
+		should := len(q.Should) == 0
+		for _, clause := range q.Should {
+			if matched, s := evaluate(doc, clause); matched {
+				should = true
+				score += s
+			}
+		}
+		if !should {
+			return false, 0
+		}
+
+		for _, clause := range q.MustNot {
+			if matched, _ := evaluate(doc, clause); matched {
+				return false, 0
+			}
+		}
+		return true, score
+	}
+
+	return true, 1
+}
+
+// positiveTerms collects the terms and phrases of the query that are not
+// negated, phrases as terms of all their words, to highlight them
+func positiveTerms(query Query, terms []TermQuery) []TermQuery {
+	switch q := query.(type) {
+	case TermQuery:
+		terms = append(terms, q)
+	case PhraseQuery:
+		for _, word := range analyze(q.Phrase) {
+			terms = append(terms, TermQuery{Fields: q.Fields, Term: word})
+		}
+	case BoolQuery:
+		for _, clause := range append(q.Must, q.Should...) {
+			terms = positiveTerms(clause, terms)
+		}
+	}
+	return terms
+}
+
+// analyze splits text into lowercase words of letters and digits, a rough
+// take on the standard analyzer of Elasticsearch
+func analyze(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// fieldWords returns the words of a text field or of a list of texts. A
+// multi-field, such as "title.exact", has the words of its field.
+func fieldWords(doc map[string]interface{}, field string) []string {
+	switch value := doc[strings.SplitN(field, ".", 2)[0]].(type) {
+	case string:
+		return analyze(value)
+	case []interface{}:
+		var words []string
+		for _, item := range value {
+			if text, ok := item.(string); ok {
+				words = append(words, analyze(text)...)
+			}
+		}
+		return words
+	}
+	return nil
+}
+
+// containsTerm reports whether the words contain every word of the term, the
+// last one as a prefix when prefix is set
+func containsTerm(words []string, term []string, prefix bool) bool {
+	if len(term) == 0 {
+		return false
+	}
+
+	for i, t := range term {
+		found := false
+		for _, w := range words {
+			if w == t || (prefix && i == len(term)-1 && strings.HasPrefix(w, t)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// containsPhrase reports whether the words contain the words of the phrase in order
+func containsPhrase(words []string, phrase []string) bool {
+	if len(phrase) == 0 {
+		return false
+	}
+
+	for i := 0; i+len(phrase) <= len(words); i++ {
+		matched := true
+		for j, p := range phrase {
+			if words[i+j] != p {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// searches reports whether the fields of a term include the field
+func searches(fields []string, field string) bool {
+	for _, f := range fields {
+		if strings.SplitN(f, ".", 2)[0] == field {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,371 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+const (
+	// MaxQueryLength is the longest query, in characters, ParseQuery accepts
+	MaxQueryLength = 1000
+	// maxQueryDepth is how deeply ParseQuery lets groups nest
+	maxQueryDepth = 16
+)
+
+// Query is a parsed search query, see ParseQuery. The search engines translate
+// it into their own queries, so clients never reach their query syntax.
+type Query interface {
+	query()
+}
+
+// MatchAllQuery matches every document
+type MatchAllQuery struct{}
+
+// TermQuery matches the documents where any of the fields contains the term.
+// A term the engine splits into several words needs all of them.
+type TermQuery struct {
+	Fields []string
+	Term   string
+	Prefix bool // Match the words the term is a prefix of as well
+}
+
+// PhraseQuery matches the documents where any of the fields contains the
+// words of the phrase in order
+type PhraseQuery struct {
+	Fields []string
+	Phrase string
+}
+
+// BoolQuery combines queries. A document matches when it matches every Must
+// query, at least one of the Should queries if there are any, and none of
+// the MustNot queries.
+type BoolQuery struct {
+	Must    []Query
+	Should  []Query
+	MustNot []Query
+}
+
+// ExactQuery matches the documents where a keyword field, or any value of a
+// list field, equals one of the values. Queries never parse into it; it
+// filters on values taken from elsewhere, like IDs.
+type ExactQuery struct {
+	Field  string
+	Values []string
+}
+
+// RangeQuery matches the documents where a numeric or date field lies in
+// the range. From is included and To is not; a nil bound leaves the range
+// open. Dates are given as RFC 3339 strings.
+type RangeQuery struct {
+	Field string
+	From  interface{}
+	To    interface{}
+}
+
+func (MatchAllQuery) query() {}
+func (TermQuery) query()     {}
+func (PhraseQuery) query()   {}
+func (BoolQuery) query()     {}
+func (ExactQuery) query()    {}
+func (RangeQuery) query()    {}
+
+// QueryFields describes the fields a query may search
+type QueryFields struct {
+	Default []string            // Indexed fields searched by terms naming no field
+	Named   map[string][]string // Field names terms may use, to the indexed fields they search
+}
+
+// SyntaxError describes why a query could not be parsed
+type SyntaxError struct {
+	Position int // Character the error was found at, starting at 1
+	Message  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at character %d", e.Message, e.Position)
+}
+
+// ParseQuery parses a search query written in the query language of the API:
+//
+//	golang tips           documents containing both terms
+//	"golang tips"         documents containing the phrase
+//	gol*                  terms starting with "gol"
+//	title:golang          the term in the title, and likewise title:"golang tips"
+//	golang OR rust        either term
+//	golang AND NOT rust   the first term but not the second, as does golang -rust
+//	(golang OR rust) tips groups
+//
+// Terms next to each other must all match. NOT binds tighter than AND, which
+// binds tighter than OR. Only the fields named in fields can be searched. An
+// empty query matches every document.
+func ParseQuery(input string, fields QueryFields) (Query, error) {
+	if len([]rune(input)) > MaxQueryLength {
+		return nil, &SyntaxError{Position: MaxQueryLength + 1, Message: fmt.Sprintf("query longer than %d characters", MaxQueryLength)}
+	}
+
+	tokens, err := lexQuery(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 1 {
+		return MatchAllQuery{}, nil
+	}
+
+	p := &queryParser{tokens: tokens, fields: fields}
+	query, err := p.parseOr(0)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEnd {
+		return nil, p.unexpected(t)
+	}
+
+	return query, nil
+}
+
+type tokenKind int
+
+const (
+	tokenEnd tokenKind = iota
+	tokenWord
+	tokenPhrase
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenOpen
+	tokenClose
+)
+
+type queryToken struct {
+	kind     tokenKind
+	field    string // Field the word or phrase was prefixed with
+	text     string
+	position int
+}
+
+// lexQuery splits the query into tokens, ending with a tokenEnd
+func lexQuery(input string) ([]queryToken, error) {
+	runes := []rune(input)
+
+	var tokens []queryToken
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, queryToken{kind: tokenOpen, text: "(", position: i + 1})
+			i++
+		case r == ')':
+			tokens = append(tokens, queryToken{kind: tokenClose, text: ")", position: i + 1})
+			i++
+		case r == '-' && (i == 0 || !isWordRune(runes[i-1])):
+			tokens = append(tokens, queryToken{kind: tokenNot, text: "-", position: i + 1})
+			i++
+		case r == '"':
+			phrase, next, err := lexPhrase(runes, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, queryToken{kind: tokenPhrase, text: phrase, position: i + 1})
+			i = next
+		default:
+			start := i
+			for i < len(runes) && isWordRune(runes[i]) {
+				i++
+			}
+			word := string(runes[start:i])
+
+			token := queryToken{kind: tokenWord, text: word, position: start + 1}
+			switch word {
+			case "AND":
+				token.kind = tokenAnd
+			case "OR":
+				token.kind = tokenOr
+			case "NOT":
+				token.kind = tokenNot
+			}
+
+			if colon := strings.Index(word, ":"); colon > 0 {
+				token.field, token.text = word[:colon], word[colon+1:]
+				if token.text == "" && i < len(runes) && runes[i] == '"' {
+					phrase, next, err := lexPhrase(runes, i)
+					if err != nil {
+						return nil, err
+					}
+					token.kind, token.text = tokenPhrase, phrase
+					i = next
+				}
+			}
+			tokens = append(tokens, token)
+		}
+	}
+
+	return append(tokens, queryToken{kind: tokenEnd, position: len(runes) + 1}), nil
+}
+
+// lexPhrase reads the quoted phrase starting at runes[start] and returns it
+// along with the index following the closing quote
+func lexPhrase(runes []rune, start int) (string, int, error) {
+	for i := start + 1; i < len(runes); i++ {
+		if runes[i] == '"' {
+			return string(runes[start+1 : i]), i + 1, nil
+		}
+	}
+	return "", 0, &SyntaxError{Position: start + 1, Message: "unterminated phrase"}
+}
+
+func isWordRune(r rune) bool {
+	return !unicode.IsSpace(r) && r != '(' && r != ')' && r != '"'
+}
+
+// queryParser is a recursive descent parser over the tokens of a query
+type queryParser struct {
+	tokens []queryToken
+	next   int
+	fields QueryFields
+}
+
+func (p *queryParser) peek() queryToken {
+	return p.tokens[p.next]
+}
+
+func (p *queryParser) take() queryToken {
+	t := p.tokens[p.next]
+	if t.kind != tokenEnd {
+		p.next++
+	}
+	return t
+}
+
+func (p *queryParser) unexpected(t queryToken) error {
+	if t.kind == tokenEnd {
+		return &SyntaxError{Position: t.position, Message: "unexpected end of query"}
+	}
+	return &SyntaxError{Position: t.position, Message: fmt.Sprintf("unexpected %q", t.text)}
+}
+
+// parseOr parses clauses separated by OR
+func (p *queryParser) parseOr(depth int) (Query, error) {
+	var clauses []Query
+	for {
+		clause, err := p.parseAnd(depth)
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, clause)
+
+		if p.peek().kind != tokenOr {
+			break
+		}
+		p.take()
+	}
+
+	if len(clauses) == 1 {
+		return clauses[0], nil
+	}
+	return BoolQuery{Should: clauses}, nil
+}
+
+// parseAnd parses clauses separated by AND or standing next to each other
+func (p *queryParser) parseAnd(depth int) (Query, error) {
+	var must, mustNot []Query
+	for {
+		negated, clause, err := p.parseNot(depth)
+		if err != nil {
+			return nil, err
+		}
+		if negated {
+			mustNot = append(mustNot, clause)
+		} else {
+			must = append(must, clause)
+		}
+
+		next := p.peek().kind
+		if next == tokenAnd {
+			p.take()
+			continue
+		}
+		if next == tokenOr || next == tokenClose || next == tokenEnd {
+			break
+		}
+	}
+
+	if len(must) == 1 && len(mustNot) == 0 {
+		return must[0], nil
+	}
+	return BoolQuery{Must: must, MustNot: mustNot}, nil
+}
+
+// parseNot parses a clause, reporting whether it was negated
+func (p *queryParser) parseNot(depth int) (bool, Query, error) {
+	negated := false
+	if p.peek().kind == tokenNot {
+		p.take()
+		negated = true
+	}
+
+	clause, err := p.parseClause(depth)
+	return negated, clause, err
+}
+
+// parseClause parses a term, a phrase or a group
+func (p *queryParser) parseClause(depth int) (Query, error) {
+	t := p.take()
+	switch t.kind {
+	case tokenOpen:
+		if depth >= maxQueryDepth {
+			return nil, &SyntaxError{Position: t.position, Message: fmt.Sprintf("groups nested deeper than %d levels", maxQueryDepth)}
+		}
+		query, err := p.parseOr(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.take(); closing.kind != tokenClose {
+			if closing.kind == tokenEnd {
+				return nil, &SyntaxError{Position: t.position, Message: "unclosed group"}
+			}
+			return nil, p.unexpected(closing)
+		}
+		return query, nil
+
+	case tokenWord, tokenPhrase:
+		fields, err := p.resolve(t)
+		if err != nil {
+			return nil, err
+		}
+
+		if t.kind == tokenPhrase {
+			if strings.TrimSpace(t.text) == "" {
+				return nil, &SyntaxError{Position: t.position, Message: "empty phrase"}
+			}
+			return PhraseQuery{Fields: fields, Phrase: t.text}, nil
+		}
+
+		term := TermQuery{Fields: fields, Term: strings.TrimSuffix(t.text, "*")}
+		term.Prefix = term.Term != t.text
+		switch {
+		case term.Term == "":
+			return nil, &SyntaxError{Position: t.position, Message: "missing term"}
+		case strings.Contains(term.Term, "*"):
+			return nil, &SyntaxError{Position: t.position, Message: "wildcards are only allowed at the end of a term"}
+		}
+		return term, nil
+	}
+
+	return nil, p.unexpected(t)
+}
+
+// resolve returns the indexed fields a word or phrase searches
+func (p *queryParser) resolve(t queryToken) ([]string, error) {
+	if t.field == "" {
+		return p.fields.Default, nil
+	}
+
+	fields, ok := p.fields.Named[t.field]
+	if !ok {
+		return nil, &SyntaxError{Position: t.position, Message: fmt.Sprintf("unknown field %q", t.field)}
+	}
+	return fields, nil
+}
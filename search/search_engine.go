@@ -1,27 +1,115 @@
 package search
 
+import (
+	"context"
+	"encoding/json"
+)
+
+// SortField orders search results by a field of the documents
+type SortField struct {
+	Field string // Field to sort by, or ScoreField for the relevance
+	Desc  bool   // Sort in descending order
+}
+
+// ScoreField sorts by relevance when used as a SortField
+const ScoreField = "_score"
+
+// SearchRequest describes a page of search results
+type SearchRequest struct {
+	Query     Query         // Documents to find, every document when nil
+	From      int           // Number of hits to skip, ignored when After is set
+	Size      int           // Maximum number of hits to return
+	After     []interface{} // Sort values of the last hit of the previous page, to resume after it
+	Sort      []SortField   // Order of the hits, by relevance when empty. The document ID breaks ties.
+	Highlight []string      // Fields to return highlighted snippets of
+
+	// Filters restrict the documents matching the query without changing
+	// their scores. The aggregations count the documents left.
+	Filters      []Query
+	Aggregations map[string]Aggregation // Aggregations by the name their buckets are returned under
+}
+
 // SearchResult represents a single result from the search engine.
 type SearchResult struct {
-	ID    string  // Unique identifier of the document
-	Score float64 // Relevance score of the document
-	// Add other fields as needed based on your search requirements
+	ID         string              // Unique identifier of the document
+	Score      float64             // Relevance score of the document
+	Source     json.RawMessage     // Fields of the document as it was indexed
+	Highlights map[string][]string // Snippets of the matching fields, terms wrapped in <em> tags
+	Sort       []interface{}       // Sort values of the hit, to pass as After for the next page
+}
+
+// SearchResults is a page of search results
+type SearchResults struct {
+	Total int64          // Number of documents matching the query
+	Hits  []SearchResult // Results of the page, in order
+
+	Aggregations map[string][]Bucket // Buckets of the requested aggregations, by name
+}
+
+// Suggestion is a completion of a prefix, taken from a document
+type Suggestion struct {
+	ID   string // Document the text was taken from
+	Text string // Value of the field
+}
+
+// Document is a document to index, with the ID it is stored under
+type Document struct {
+	ID   string
+	Data interface{} // Struct whose JSON fields become the fields of the document
 }
 
 // SearchEngine is an interface that defines the methods to interact with the search engine.
 type SearchEngine interface {
 	// IndexDocument indexes a document in the search engine.
-	IndexDocument(index string, id string, data interface{}) error
+	IndexDocument(ctx context.Context, index string, id string, data interface{}) error
 
 	// IndexDocumentUpdate indexes a document in the search engine.
 	// IndexDocumentUpdate(index, docID string, data interface{}) error
 
 	// DeleteDocument removes a document from the search engine by its ID.
-	DeleteDocument(index string, docID string) error
+	// Deleting a document that is not indexed is not an error.
+	DeleteDocument(ctx context.Context, index string, docID string) error
+
+	// Search returns a page of the documents matching the query, along with
+	// the number of documents matching it.
+	Search(ctx context.Context, index string, request SearchRequest) (SearchResults, error)
+
+	// MoreLikeThis returns up to size documents of the index whose fields
+	// share the most telling terms with those of the document, best first.
+	// The document itself is left out, and one that is not indexed has none.
+	MoreLikeThis(ctx context.Context, index string, id string, fields []string, size int) ([]SearchResult, error)
+
+	// Suggest returns up to limit distinct values of the field starting with
+	// the prefix, regardless of case, for completing what a user types.
+	Suggest(ctx context.Context, index string, field string, prefix string, limit int) ([]Suggestion, error)
+
+	// CreateIndex creates an empty index.
+	CreateIndex(ctx context.Context, index string) error
+
+	// DeleteIndex removes an index and its documents.
+	DeleteIndex(ctx context.Context, index string) error
+
+	// BulkIndex indexes the documents in as few requests as possible. It fails
+	// when any of them could not be indexed.
+	BulkIndex(ctx context.Context, index string, docs []Document) error
+
+	// SwapAlias points the alias at the index in one atomic step and returns
+	// the indices it pointed to before. An index named like the alias, left
+	// from before aliases were used, is removed in the same step.
+	SwapAlias(ctx context.Context, alias string, index string) ([]string, error)
+
+	// ScanDocuments passes the ID and source of every document in the index,
+	// or the index an alias points to, to fn. A missing index has no documents.
+	ScanDocuments(ctx context.Context, index string, fn func(id string, source json.RawMessage) error) error
+
+	// PutIndexTemplates creates or updates the index templates of the
+	// definitions, which apply to the indices created afterwards.
+	PutIndexTemplates(ctx context.Context, definitions []IndexDefinition) error
 
-	// Search performs a search query on the search engine and returns the results.
-	// The `query` parameter can be a string representing the search query or a more complex data structure
-	// representing the query depending on your specific search requirements.
-	Search(index string, query string) ([]SearchResult, error)
+	// MappingVersion returns the definition version the index, or the index an
+	// alias points to, was created with. It is 0 for a missing index and for
+	// one created without a template.
+	MappingVersion(ctx context.Context, index string) (int, error)
 
 	// Add more methods as needed based on your search engine requirements.
 }
@@ -0,0 +1,201 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"main.go/auth"
+	"main.go/cache"
+	"main.go/model"
+	"main.go/repository"
+	"main.go/validate"
+)
+
+// revokedPrefix prefixes the cache keys of revoked refresh tokens
+const revokedPrefix = "auth:revoked:"
+
+// AuthService handles registration, login and the token lifecycle
+type AuthService struct {
+	userRepository *repository.UserRepository
+	userService    *UserService
+	tokens         *auth.TokenIssuer
+	cache          cache.Cacher
+}
+
+// NewAuthService creates a new AuthService
+func NewAuthService(userRepository *repository.UserRepository, userService *UserService, tokens *auth.TokenIssuer, cache cache.Cacher) *AuthService {
+	return &AuthService{
+		userRepository: userRepository,
+		userService:    userService,
+		tokens:         tokens,
+		cache:          cache,
+	}
+}
+
+// Register creates a new user with a hashed password. Registered users never
+// get a role; admins hand out roles, and the first admin is created with
+// CreateAdmin.
+func (s *AuthService) Register(ctx context.Context, user model.User, password string) (model.User, error) {
+	user.Role = ""
+	return s.createUser(ctx, user, password)
+}
+
+// CreateAdmin creates a new user with the admin role, for bootstrapping a
+// deployment from the command line
+func (s *AuthService) CreateAdmin(ctx context.Context, user model.User, password string) (model.User, error) {
+	user.Role = model.RoleAdmin
+	return s.createUser(ctx, user, password)
+}
+
+// createUser stores the user with the hash of the password
+func (s *AuthService) createUser(ctx context.Context, user model.User, password string) (model.User, error) {
+	if err := validate.Struct(user); err != nil {
+		return model.User{}, err
+	}
+
+	// Make sure the username is not taken yet
+	_, err := s.userRepository.GetUserByUserName(ctx, user.UserName)
+	if err == nil {
+		return model.User{}, repository.ErrUsernameTaken
+	}
+	if !errors.Is(err, repository.ErrUserNotFound) {
+		return model.User{}, err
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return model.User{}, err
+	}
+	user.PasswordHash = hash
+
+	// Go through the user service so the user is indexed and announced like any other
+	return s.userService.addUser(ctx, user)
+}
+
+// Login checks the credentials and issues a new token pair
+func (s *AuthService) Login(ctx context.Context, username, password string) (auth.TokenPair, error) {
+	user, err := s.userRepository.GetUserByUserName(ctx, username)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return auth.TokenPair{}, auth.ErrInvalidCredentials
+		}
+		return auth.TokenPair{}, err
+	}
+
+	if err := auth.CheckPassword(user.PasswordHash, password); err != nil {
+		return auth.TokenPair{}, err
+	}
+
+	return s.tokens.Issue(user)
+}
+
+// Refresh exchanges a valid refresh token for a new token pair.
+// The old refresh token is consumed, so every refresh token can be used once,
+// also when the same token is refreshed concurrently.
+func (s *AuthService) Refresh(ctx context.Context, refreshToken string) (auth.TokenPair, error) {
+	claims, err := s.parseRefreshToken(ctx, refreshToken)
+	if err != nil {
+		return auth.TokenPair{}, err
+	}
+
+	user, err := s.lookupUser(ctx, claims.Subject)
+	if err != nil {
+		return auth.TokenPair{}, err
+	}
+
+	if err := s.consume(ctx, claims); err != nil {
+		return auth.TokenPair{}, err
+	}
+
+	return s.tokens.Issue(user)
+}
+
+// Logout revokes the refresh token
+func (s *AuthService) Logout(ctx context.Context, refreshToken string) error {
+	claims, err := s.parseRefreshToken(ctx, refreshToken)
+	if err != nil {
+		return err
+	}
+
+	return s.revoke(ctx, claims)
+}
+
+// Authenticate validates an access token and returns the user it was issued to
+func (s *AuthService) Authenticate(ctx context.Context, accessToken string) (model.User, error) {
+	claims, err := s.tokens.Parse(accessToken, auth.AccessToken)
+	if err != nil {
+		return model.User{}, err
+	}
+
+	return s.lookupUser(ctx, claims.Subject)
+}
+
+// parseRefreshToken validates a refresh token and checks it was not revoked
+func (s *AuthService) parseRefreshToken(ctx context.Context, refreshToken string) (auth.Claims, error) {
+	claims, err := s.tokens.Parse(refreshToken, auth.RefreshToken)
+	if err != nil {
+		return auth.Claims{}, err
+	}
+
+	var revoked bool
+	if err := s.cache.Get(ctx, revokedPrefix+claims.ID, &revoked); err == nil && revoked {
+		return auth.Claims{}, auth.ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+// revoke remembers the refresh token as revoked until it would expire anyway
+func (s *AuthService) revoke(ctx context.Context, claims auth.Claims) error {
+	ttl := time.Until(claims.ExpiresAt.Time)
+	if ttl <= 0 {
+		return nil
+	}
+
+	if err := s.cache.Set(ctx, revokedPrefix+claims.ID, true, ttl); err != nil {
+		return fmt.Errorf("failed to revoke refresh token: %v", err)
+	}
+
+	return nil
+}
+
+// consume revokes the refresh token in one atomic step, failing when it was
+// revoked in the meantime, as happens when it is used twice at once
+func (s *AuthService) consume(ctx context.Context, claims auth.Claims) error {
+	ttl := time.Until(claims.ExpiresAt.Time)
+	if ttl <= 0 {
+		return auth.ErrInvalidToken
+	}
+
+	consumed, err := s.cache.SetIfAbsent(ctx, revokedPrefix+claims.ID, true, ttl)
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh token: %v", err)
+	}
+	if !consumed {
+		return auth.ErrInvalidToken
+	}
+
+	return nil
+}
+
+// lookupUser loads the subject of a token, which must still exist, as stored,
+// so a changed role or a deletion takes effect right away
+func (s *AuthService) lookupUser(ctx context.Context, subject string) (model.User, error) {
+	id, err := primitive.ObjectIDFromHex(subject)
+	if err != nil {
+		return model.User{}, auth.ErrInvalidToken
+	}
+
+	user, err := s.userRepository.GetUserByIDFromDB(ctx, id.Hex())
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return model.User{}, auth.ErrInvalidToken
+		}
+		return model.User{}, err
+	}
+
+	return user, nil
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+)
+
+// EventSchema is the registered JSON schema of the data of an event type.
+// The version increases whenever the schema changes; consumers accept the
+// events of the versions they know.
+type EventSchema struct {
+	Type    string                 `json:"type"`
+	Version int                    `json:"version"`
+	URI     string                 `json:"uri"` // Sent as the dataschema of the events
+	Schema  map[string]interface{} `json:"schema"`
+
+	// Required are the top-level data fields every event of the type carries
+	Required []string `json:"-"`
+}
+
+// eventSchemas is the registry of every event type the services publish
+var eventSchemas = map[string]EventSchema{}
+
+func init() {
+	post := entitySchema("Post", map[string]interface{}{
+		"_id":        objectIDSchema,
+		"title":      stringSchema,
+		"body":       stringSchema,
+		"tags":       map[string]interface{}{"type": "array", "items": stringSchema},
+		"author_id":  objectIDSchema,
+		"version":    integerSchema,
+		"created_at": timeSchema,
+		"updated_at": timeSchema,
+		"deleted_at": timeSchema,
+	}, "_id", "title", "version")
+
+	user := entitySchema("User", map[string]interface{}{
+		"_id":        objectIDSchema,
+		"fullname":   stringSchema,
+		"username":   stringSchema,
+		"email":      stringSchema,
+		"role":       stringSchema,
+		"version":    integerSchema,
+		"created_at": timeSchema,
+		"updated_at": timeSchema,
+		"deleted_at": timeSchema,
+	}, "_id", "username", "version")
+
+	// Version 2 of the post events added the tags
+	registerChangeSchemas("post", 2, post)
+	registerChangeSchemas("user", 1, user)
+}
+
+// registerChangeSchemas registers the added, updated, deleted and restored
+// events of an entity, which differ in the snapshots they require
+func registerChangeSchemas(entity string, version int, snapshot map[string]interface{}) {
+	registerEventSchema(entity+".added", version, snapshot, "after", "fields")
+	registerEventSchema(entity+".updated", version, snapshot, "before", "after", "fields")
+	registerEventSchema(entity+".deleted", version, snapshot, "before", "fields")
+	registerEventSchema(entity+".restored", version, snapshot, "after", "fields")
+}
+
+// registerEventSchema adds the schema of a PostChange or UserChange with the
+// given snapshot schema and required fields to the registry
+func registerEventSchema(eventType string, version int, snapshot map[string]interface{}, required ...string) {
+	uri := fmt.Sprintf("urn:gocrud:schema:%s:%d", eventType, version)
+
+	eventSchemas[eventType] = EventSchema{
+		Type:    eventType,
+		Version: version,
+		URI:     uri,
+		Schema: map[string]interface{}{
+			"$schema": "https://json-schema.org/draft/2020-12/schema",
+			"$id":     uri,
+			"title":   eventType,
+			"type":    "object",
+			"properties": map[string]interface{}{
+				"before": snapshot,
+				"after":  snapshot,
+				"fields": map[string]interface{}{"type": "array", "items": stringSchema},
+			},
+			"required": required,
+		},
+		Required: required,
+	}
+}
+
+// entitySchema describes the JSON form of a post or user
+func entitySchema(title string, properties map[string]interface{}, required ...string) map[string]interface{} {
+	return map[string]interface{}{
+		"title":      title,
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
+
+// Schemas of the scalar values in the snapshots
+var (
+	stringSchema   = map[string]interface{}{"type": "string"}
+	integerSchema  = map[string]interface{}{"type": "integer"}
+	timeSchema     = map[string]interface{}{"type": "string", "format": "date-time"}
+	objectIDSchema = map[string]interface{}{"type": "string", "pattern": "^[0-9a-f]{24}$"}
+)
+
+// LookupEventSchema returns the registered schema of the event type
+func LookupEventSchema(eventType string) (EventSchema, bool) {
+	schema, ok := eventSchemas[eventType]
+	return schema, ok
+}
+
+// EventSchemas returns every registered schema, ordered by event type
+func EventSchemas() []EventSchema {
+	schemas := make([]EventSchema, 0, len(eventSchemas))
+	for _, schema := range eventSchemas {
+		schemas = append(schemas, schema)
+	}
+
+	sort.Slice(schemas, func(i, j int) bool {
+		return schemas[i].Type < schemas[j].Type
+	})
+
+	return schemas
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"main.go/model"
+)
+
+// CloudEventsVersion is the version of the CloudEvents specification the envelopes follow
+const CloudEventsVersion = "1.0"
+
+// Event is the envelope of every published message, a CloudEvent in the
+// structured JSON format. Its type names the subject it is published on and
+// the schema of its data, and its subject is the ID of the entity.
+type Event struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype"`
+	DataSchema      string          `json:"dataschema,omitempty"`
+	SchemaVersion   int             `json:"schemaversion"` // Extension attribute, the version of the data schema
+	Data            json.RawMessage `json:"data"`
+}
+
+// PostChange is the data of the post.* events. Added and restored posts
+// only come with the post after the change and deleted ones only with the
+// post before it. Fields lists what changed.
+type PostChange struct {
+	Before *model.Post `json:"before,omitempty"`
+	After  *model.Post `json:"after,omitempty"`
+	Fields []string    `json:"fields"`
+}
+
+// UserChange is the data of the user.* events, like PostChange
+type UserChange struct {
+	Before *model.User `json:"before,omitempty"`
+	After  *model.User `json:"after,omitempty"`
+	Fields []string    `json:"fields"`
+}
+
+// NewEvent wraps the data in the envelope of the event type, which must be in
+// the schema registry. The source identifies the collection of the subject.
+func NewEvent(eventType string, source string, subject string, data interface{}) (Event, error) {
+	schema, ok := LookupEventSchema(eventType)
+	if !ok {
+		return Event{}, fmt.Errorf("unknown event type %q", eventType)
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return Event{}, err
+	}
+
+	return Event{
+		SpecVersion:     CloudEventsVersion,
+		ID:              primitive.NewObjectID().Hex(),
+		Source:          source,
+		Type:            eventType,
+		Subject:         subject,
+		Time:            time.Now().UTC(),
+		DataContentType: "application/json",
+		DataSchema:      schema.URI,
+		SchemaVersion:   schema.Version,
+		Data:            raw,
+	}, nil
+}
+
+// PublishEvent adds the event to the outbox, keyed by its subject so the
+// events about one entity keep their order
+func (s *MessagingService) PublishEvent(ctx context.Context, event Event) error {
+	raw, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	return s.Publish(ctx, event.Type, event.Subject, raw)
+}
+
+// DecodeEvent parses a published message. It fails for envelopes missing a
+// required attribute, for types not in the schema registry, for schema
+// versions newer than the registered one and for data lacking a field the
+// schema requires.
+func DecodeEvent(raw []byte) (Event, error) {
+	var event Event
+	if err := json.Unmarshal(raw, &event); err != nil {
+		return Event{}, fmt.Errorf("invalid event: %v", err)
+	}
+
+	if event.SpecVersion != CloudEventsVersion {
+		return Event{}, fmt.Errorf("unsupported CloudEvents version %q", event.SpecVersion)
+	}
+	if event.ID == "" || event.Source == "" || event.Type == "" {
+		return Event{}, fmt.Errorf("invalid event: id, source and type are required")
+	}
+
+	schema, ok := LookupEventSchema(event.Type)
+	if !ok {
+		return Event{}, fmt.Errorf("unknown event type %q", event.Type)
+	}
+	if event.SchemaVersion > schema.Version {
+		return Event{}, fmt.Errorf("unsupported schema version %d of %s, expected at most %d", event.SchemaVersion, event.Type, schema.Version)
+	}
+
+	var data map[string]json.RawMessage
+	if err := json.Unmarshal(event.Data, &data); err != nil {
+		return Event{}, fmt.Errorf("invalid data of %s: %v", event.Type, err)
+	}
+	for _, field := range schema.Required {
+		if value, ok := data[field]; !ok || string(value) == "null" {
+			return Event{}, fmt.Errorf("invalid data of %s: %s is required", event.Type, field)
+		}
+	}
+
+	return event, nil
+}
+
+// DecodeData decodes the data of the event, such as a PostChange or UserChange
+func (e Event) DecodeData(v interface{}) error {
+	return json.Unmarshal(e.Data, v)
+}
@@ -1,31 +1,61 @@
 package service
 
 import (
+	"context"
+	"time"
+
 	"main.go/messaging"
+	"main.go/repository"
 )
 
-// MessagingService handles the messaging functionality
+// MessagingService handles the messaging functionality. Messages are not
+// sent right away but stored in the outbox, from where the OutboxRelay
+// publishes them.
 type MessagingService struct {
 	messaging messaging.Messaging
+	outbox    *repository.OutboxRepository
 }
 
 // NewMessagingService creates a new MessagingService
-func NewMessagingService(messaging messaging.Messaging) *MessagingService {
+func NewMessagingService(messaging messaging.Messaging, outbox *repository.OutboxRepository) *MessagingService {
 	return &MessagingService{
 		messaging: messaging,
+		outbox:    outbox,
 	}
 }
 
-// Publish publishes a message using the messaging system
-func (s *MessagingService) Publish(topic string, data []byte) error {
-	return s.messaging.Publish(topic, data)
+// Publish adds a message to the outbox. Messages with the same key, the ID
+// of the entity they are about, are published in the order they were added.
+// Within Transaction the message is only stored if the transaction commits.
+// The message carries the correlation ID and trace context of the context.
+func (s *MessagingService) Publish(ctx context.Context, topic string, key string, data []byte) error {
+	return s.outbox.AddEvent(ctx, topic, key, messaging.HeaderFromContext(ctx), data)
+}
+
+// Transaction runs fn in a database transaction, so the changes it makes and
+// the messages it publishes are stored together or not at all
+func (s *MessagingService) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return s.outbox.WithTransaction(ctx, fn)
 }
 
 // Subscribe subscribes to a topic and registers a message handler
-func (s *MessagingService) Subscribe(topic string, handler func(data []byte)) error {
+func (s *MessagingService) Subscribe(topic string, handler messaging.Handler) (messaging.Subscription, error) {
 	return s.messaging.Subscribe(topic, handler)
 }
 
+// QueueSubscribe subscribes to a topic as a member of the queue group, so the
+// replicas of a service share the messages instead of each handling them all
+func (s *MessagingService) QueueSubscribe(topic string, queue string, handler messaging.Handler) (messaging.Subscription, error) {
+	return s.messaging.QueueSubscribe(topic, queue, handler)
+}
+
+// Request sends a message right away, bypassing the outbox, and waits for
+// the reply. The message carries the correlation ID and trace context of the
+// context.
+func (s *MessagingService) Request(ctx context.Context, topic string, data []byte, timeout time.Duration) (*messaging.Message, error) {
+	return s.messaging.Request(ctx, messaging.NewMessage(ctx, topic, data), timeout)
+}
+
 // Close closes the messaging connection
 func (s *MessagingService) Close() error {
 	return s.messaging.Close()
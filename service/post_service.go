@@ -1,156 +1,465 @@
 package service
 
 import (
-	"fmt"
-	"log"
+	"context"
+	"errors"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"main.go/auth"
+	"main.go/authz"
 	"main.go/model"
+	"main.go/patch"
 	"main.go/repository"
-	"main.go/search"
+	"main.go/validate"
 )
 
+// maxPatchAttempts limits how often an unconditional patch is reapplied when
+// the document keeps changing underneath it
+const maxPatchAttempts = 3
+
 type PostService struct {
-	postRepository *repository.PostRepository
-	messaging      *MessagingService
+	postRepository     *repository.PostRepository
+	revisionRepository *repository.PostRevisionRepository
+	messaging          *MessagingService
+	authorizer         *authz.Authorizer
+	suggestions        *SuggestionCache
+	related            *RelatedPostCache
 }
 
-func NewPostService(postRepository *repository.PostRepository, messaging *MessagingService) *PostService {
+func NewPostService(postRepository *repository.PostRepository, revisionRepository *repository.PostRevisionRepository, messaging *MessagingService, authorizer *authz.Authorizer, suggestions *SuggestionCache, related *RelatedPostCache) *PostService {
 	return &PostService{
-		postRepository: postRepository,
-		messaging:      messaging,
+		postRepository:     postRepository,
+		revisionRepository: revisionRepository,
+		messaging:          messaging,
+		authorizer:         authorizer,
+		suggestions:        suggestions,
+		related:            related,
 	}
 }
 
-func (s *PostService) GetPosts() ([]model.Post, error) {
-	return s.postRepository.GetPosts()
+func (s *PostService) GetPosts(ctx context.Context, filter model.PostFilter, page model.PageRequest) (model.PostPage, error) {
+	if err := s.authorizer.Check(ctx, "posts:read"); err != nil {
+		return model.PostPage{}, err
+	}
+
+	return s.postRepository.GetPosts(ctx, filter, page)
 }
 
-func (s *PostService) GetPostByID(id string) (model.Post, error) {
-	// Convert the string ID to a primitive.ObjectID
-	objID, err := primitive.ObjectIDFromHex(id)
+func (s *PostService) GetPostByID(ctx context.Context, id string) (model.Post, error) {
+	objID, err := model.ParseID(id)
 	if err != nil {
-		return model.Post{}, fmt.Errorf("invalid object ID format: %v", err)
+		return model.Post{}, err
+	}
+
+	if err := s.authorizer.Check(ctx, "posts:read"); err != nil {
+		return model.Post{}, err
 	}
 
 	// Directly call the repository method to get post by ID
-	return s.postRepository.GetPostByID(objID)
+	return s.postRepository.GetPostByID(ctx, objID)
 }
 
-func (s *PostService) AddPost(post model.Post) (model.Post, error) {
-	addedPost, err := s.postRepository.AddPost(post)
-	if err != nil {
+func (s *PostService) AddPost(ctx context.Context, post model.Post) (model.Post, error) {
+	if err := s.authorizer.Check(ctx, "posts:create"); err != nil {
 		return model.Post{}, err
 	}
 
-	// Get the latest inserted post from the database
-	latestPost, err := s.postRepository.GetLatestInsertedPost()
+	if err := validate.Struct(post); err != nil {
+		return model.Post{}, err
+	}
+	tags, err := model.NormalizeTags(post.Tags)
 	if err != nil {
-		// Handle the error if necessary
-		log.Println("Failed to get the latest inserted post from the database:", err)
-		return latestPost, nil
+		return model.Post{}, err
 	}
+	post.Tags = tags
 
-	// Convert the primitive.ObjectID to a string
-	postID := latestPost.ID.Hex()
+	// The caller becomes the author of the post
+	author, ok := auth.UserFromContext(ctx)
+	if !ok {
+		return model.Post{}, auth.ErrUnauthenticated
+	}
+	post.AuthorID = author.ID
 
-	// Publish a message indicating a new post has been added
-	err = s.messaging.Publish("post.added", []byte(postID))
+	// Store the post together with the message announcing it
+	var addedPost model.Post
+	err = s.messaging.Transaction(ctx, func(ctx context.Context) error {
+		var err error
+		addedPost, err = s.postRepository.AddPost(ctx, post)
+		if err != nil {
+			return err
+		}
+		fields := changedPostFields(model.Post{}, addedPost)
+		if err := s.recordRevision(ctx, addedPost, fields); err != nil {
+			return err
+		}
+		return s.publish(ctx, "post.added", PostChange{
+			After:  &addedPost,
+			Fields: fields,
+		})
+	})
 	if err != nil {
-		// Log the error if publishing fails
-		fmt.Printf("Failed to publish post.added message: %v\n", err)
-	} else {
-		// Log success if publishing is successful
-		fmt.Printf("Successfully published post.added message for post %s\n", postID)
+		return model.Post{}, err
 	}
 
 	return addedPost, nil
 }
 
-func (s *PostService) UpdatePost(id string, post model.Post) error {
-	// Convert the string ID to a primitive.ObjectID
-	objID, err := primitive.ObjectIDFromHex(id)
+func (s *PostService) UpdatePost(ctx context.Context, id string, post model.Post) (model.Post, error) {
+	objID, err := model.ParseID(id)
 	if err != nil {
-		return fmt.Errorf("invalid object ID format: %v", err)
+		return model.Post{}, err
 	}
 
-	// Update the post in the repository
-	err = s.postRepository.UpdatePost(post)
+	if err := validate.Struct(post); err != nil {
+		return model.Post{}, err
+	}
+	post.Tags, err = model.NormalizeTags(post.Tags)
+	if err != nil {
+		return model.Post{}, err
+	}
+
+	// Only the author or a moderator may change the post
+	existing, err := s.authorizeChange(ctx, objID, "posts:update")
+	if err != nil {
+		return model.Post{}, err
+	}
+
+	// Keep the identity and the author of the stored post
+	post.ID = objID
+	post.AuthorID = existing.AuthorID
+
+	// Update the post in the repository together with the message announcing it
+	var updatedPost model.Post
+	err = s.messaging.Transaction(ctx, func(ctx context.Context) error {
+		updatedPost, err = s.postRepository.UpdatePost(ctx, post)
+		if err != nil {
+			return err
+		}
+		fields := changedPostFields(existing, updatedPost)
+		if err := s.recordRevision(ctx, updatedPost, fields); err != nil {
+			return err
+		}
+		return s.publish(ctx, "post.updated", PostChange{
+			Before: &existing,
+			After:  &updatedPost,
+			Fields: fields,
+		})
+	})
+	if err != nil {
+		return model.Post{}, err
+	}
+
+	return updatedPost, nil
+}
+
+// PatchPost applies a merge patch or JSON patch to the post and stores the
+// changed fields in one atomic update. The patch is applied to the stored post
+// of the given version, or to the current one when the version is zero; in
+// that case it is reapplied should the post change in between.
+func (s *PostService) PatchPost(ctx context.Context, id string, version int64, p patch.Patch) (model.Post, error) {
+	objID, err := model.ParseID(id)
+	if err != nil {
+		return model.Post{}, err
+	}
+
+	var patchedPost model.Post
+	for attempt := 1; ; attempt++ {
+		// Only the author or a moderator may change the post
+		existing, err := s.authorizeChange(ctx, objID, "posts:update")
+		if err != nil {
+			return model.Post{}, err
+		}
+		if version != 0 && existing.Version != version {
+			return model.Post{}, repository.ErrVersionMismatch
+		}
+
+		post := existing
+		fields, err := p.Apply(&post, model.PostPatchFields)
+		if err != nil {
+			return model.Post{}, err
+		}
+		if len(fields) == 0 {
+			return existing, nil
+		}
+
+		if err := validate.Struct(post); err != nil {
+			return model.Post{}, err
+		}
+		post.Tags, err = model.NormalizeTags(post.Tags)
+		if err != nil {
+			return model.Post{}, err
+		}
+
+		// Only write if nobody changed the post since it was read
+		post.Version = existing.Version
+		err = s.messaging.Transaction(ctx, func(ctx context.Context) error {
+			patchedPost, err = s.postRepository.PatchPost(ctx, post, fields)
+			if err != nil {
+				return err
+			}
+			if err := s.recordRevision(ctx, patchedPost, fields); err != nil {
+				return err
+			}
+			return s.publish(ctx, "post.updated", PostChange{
+				Before: &existing,
+				After:  &patchedPost,
+				Fields: fields,
+			})
+		})
+		if errors.Is(err, repository.ErrVersionMismatch) && version == 0 && attempt < maxPatchAttempts {
+			continue
+		}
+		if err != nil {
+			return model.Post{}, err
+		}
+		break
+	}
+
+	return patchedPost, nil
+}
+
+// DeletePost deletes the post if it still has the version, or any version when it is zero
+func (s *PostService) DeletePost(ctx context.Context, id string, version int64) error {
+	objID, err := model.ParseID(id)
 	if err != nil {
 		return err
 	}
 
-	// Publish a message indicating a post has been updated
-	err = s.messaging.Publish("post.updated", []byte(objID.Hex()))
+	// Only the author or a moderator may delete the post
+	existing, err := s.authorizeChange(ctx, objID, "posts:delete")
 	if err != nil {
-		// Log the error if publishing fails
-		fmt.Printf("Failed to publish post.updated message: %v\n", err)
-	} else {
-		// Log success if publishing is successful
-		fmt.Printf("Successfully published post.updated message for post %s\n", objID.Hex())
+		return err
 	}
 
-	return nil
+	// Delete the post together with the message announcing it
+	return s.messaging.Transaction(ctx, func(ctx context.Context) error {
+		err := s.postRepository.DeletePost(ctx, objID, version)
+		if err != nil {
+			return err
+		}
+		if err := s.recordDeletion(ctx, objID); err != nil {
+			return err
+		}
+		return s.publish(ctx, "post.deleted", PostChange{
+			Before: &existing,
+			Fields: []string{"deleted_at"},
+		})
+	})
 }
 
-func (s *PostService) PatchPost(id string, post model.Post) (model.Post, error) {
-	// Convert the string ID to a primitive.ObjectID
-	objID, err := primitive.ObjectIDFromHex(id)
+// GetDeletedPosts returns a page of the posts in the trash. Callers who may
+// only restore their own posts only see those.
+func (s *PostService) GetDeletedPosts(ctx context.Context, filter model.PostFilter, page model.PageRequest) (model.PostPage, error) {
+	if !s.authorizer.Can(ctx, "posts:restore:any") {
+		if err := s.authorizer.Check(ctx, "posts:restore:own"); err != nil {
+			return model.PostPage{}, err
+		}
+		caller, _ := auth.UserFromContext(ctx)
+		filter.AuthorID = caller.ID
+	}
+	filter.Deleted = true
+
+	return s.postRepository.GetPosts(ctx, filter, page)
+}
+
+// RestorePost takes the post out of the trash
+func (s *PostService) RestorePost(ctx context.Context, id string) (model.Post, error) {
+	objID, err := model.ParseID(id)
 	if err != nil {
-		return model.Post{}, fmt.Errorf("invalid object ID format: %v", err)
+		return model.Post{}, err
 	}
 
-	// Patch the post in the repository
-	patchedPost, err := s.postRepository.PatchPost(post)
+	// Only the author or a moderator may restore the post
+	deleted, err := s.postRepository.GetDeletedPostByID(ctx, objID)
 	if err != nil {
 		return model.Post{}, err
 	}
+	if err := s.authorizer.CheckOwned(ctx, "posts:restore", deleted.AuthorID); err != nil {
+		return model.Post{}, err
+	}
 
-	// Publish a message indicating a post has been updated
-	err = s.messaging.Publish("post.updated", []byte(objID.Hex()))
+	// Restore the post together with the message announcing it
+	var restoredPost model.Post
+	err = s.messaging.Transaction(ctx, func(ctx context.Context) error {
+		restoredPost, err = s.postRepository.RestorePost(ctx, objID)
+		if err != nil {
+			return err
+		}
+		if err := s.recordRevision(ctx, restoredPost, []string{"deleted_at"}); err != nil {
+			return err
+		}
+		return s.publish(ctx, "post.restored", PostChange{
+			After:  &restoredPost,
+			Fields: []string{"deleted_at"},
+		})
+	})
 	if err != nil {
-		// Log the error if publishing fails
-		fmt.Printf("Failed to publish post.updated message: %v\n", err)
-	} else {
-		// Log success if publishing is successful
-		fmt.Printf("Successfully published post.updated message for post %s\n", objID.Hex())
+		return model.Post{}, err
 	}
 
-	return patchedPost, nil
+	return restoredPost, nil
+}
+
+// publish adds the event about the change of a post to the outbox
+func (s *PostService) publish(ctx context.Context, eventType string, change PostChange) error {
+	post := change.After
+	if post == nil {
+		post = change.Before
+	}
+
+	event, err := NewEvent(eventType, "/posts", post.ID.Hex(), change)
+	if err != nil {
+		return err
+	}
+
+	return s.messaging.PublishEvent(ctx, event)
+}
+
+// authorizeChange loads the post as stored and checks that the caller holds
+// the permission on it, either for any post or for the posts they wrote
+func (s *PostService) authorizeChange(ctx context.Context, id primitive.ObjectID, permission string) (model.Post, error) {
+	post, err := s.postRepository.GetPostByIDFromDB(ctx, id)
+	if err != nil {
+		return model.Post{}, err
+	}
+
+	if err := s.authorizer.CheckOwned(ctx, permission, post.AuthorID); err != nil {
+		return model.Post{}, err
+	}
+
+	return post, nil
 }
 
-func (s *PostService) DeletePost(id string) error {
-	// Convert the string ID to a primitive.ObjectID
-	objID, err := primitive.ObjectIDFromHex(id)
+// deleteAuthorPosts deletes every post of an author on behalf of UserService.DeleteUser,
+// so the caller's permission on the user covers the posts. It runs in the
+// transaction deleting the user.
+func (s *PostService) deleteAuthorPosts(ctx context.Context, authorID primitive.ObjectID) error {
+	posts, err := s.postRepository.GetAllPostsByAuthor(ctx, authorID)
 	if err != nil {
-		return fmt.Errorf("invalid object ID format: %v", err)
+		return err
 	}
 
-	// Directly call the repository method to delete post
-	err = s.postRepository.DeletePost(objID)
+	for _, post := range posts {
+		err := s.postRepository.DeletePost(ctx, post.ID, 0)
+		if err != nil {
+			return err
+		}
+		if err := s.recordDeletion(ctx, post.ID); err != nil {
+			return err
+		}
+
+		err = s.publish(ctx, "post.deleted", PostChange{
+			Before: &post,
+			Fields: []string{"deleted_at"},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reassignAuthorPosts moves the posts of an author to another user, or orphans
+// them when the new author is the zero ObjectID. It runs in the transaction
+// deleting the user.
+func (s *PostService) reassignAuthorPosts(ctx context.Context, from primitive.ObjectID, to primitive.ObjectID) error {
+	posts, err := s.postRepository.GetAllPostsByAuthor(ctx, from)
 	if err != nil {
 		return err
 	}
 
-	// Publish a message indicating a post has been deleted
-	err = s.messaging.Publish("post.deleted", []byte(objID.Hex()))
+	err = s.postRepository.ReassignPosts(ctx, from, to)
 	if err != nil {
-		// Log the error if publishing fails
-		fmt.Printf("Failed to publish post.deleted message: %v\n", err)
-	} else {
-		// Log success if publishing is successful
-		fmt.Printf("Successfully published post.deleted message for post %s\n", objID.Hex())
+		return err
+	}
+
+	for _, post := range posts {
+		after, err := s.postRepository.GetPostByIDFromDB(ctx, post.ID)
+		if err != nil {
+			return err
+		}
+
+		// Reassigning bumped the version, which gets its revision like any other
+		if err := s.recordRevision(ctx, after, []string{"author_id"}); err != nil {
+			return err
+		}
+
+		err = s.publish(ctx, "post.updated", PostChange{
+			Before: &post,
+			After:  &after,
+			Fields: []string{"author_id"},
+		})
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func (s *PostService) SearchPost(query string) ([]search.SearchResult, error) {
-	// Directly call the repository method to search for posts
-	results, err := s.postRepository.SearchPosts(query)
+// SearchPost returns a page of the posts matching the query and the filter,
+// with the facets to narrow them down further
+func (s *PostService) SearchPost(ctx context.Context, request model.SearchRequest, filter model.PostSearchFilter) (model.PostSearchPage, error) {
+	if err := s.authorizer.Check(ctx, "posts:read"); err != nil {
+		return model.PostSearchPage{}, err
+	}
+
+	return s.postRepository.SearchPosts(ctx, request, filter)
+}
+
+// SuggestPosts returns the titles of posts starting with the prefix
+func (s *PostService) SuggestPosts(ctx context.Context, request model.SuggestRequest) ([]model.Suggestion, error) {
+	if err := s.authorizer.Check(ctx, "posts:read"); err != nil {
+		return nil, err
+	}
+
+	return s.suggestions.get(ctx, "posts", request, func() ([]model.Suggestion, error) {
+		return s.postRepository.SuggestPosts(ctx, request.Prefix, request.SuggestLimit())
+	})
+}
+
+// GetRelatedPosts returns the posts most like the post, best first. Posts
+// deleted since they were found are left out.
+func (s *PostService) GetRelatedPosts(ctx context.Context, id string, request model.RelatedRequest) ([]model.Post, error) {
+	objID, err := model.ParseID(id)
 	if err != nil {
 		return nil, err
 	}
 
-	return results, nil
+	if err := s.authorizer.Check(ctx, "posts:read"); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.postRepository.GetPostByID(ctx, objID); err != nil {
+		return nil, err
+	}
+
+	// The most related posts are cached whatever the limit, leaving some to
+	// spare for those deleted since
+	ids, err := s.related.get(ctx, objID, func() ([]primitive.ObjectID, error) {
+		return s.postRepository.RelatedPostIDs(ctx, objID, model.MaxRelatedLimit)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	limit := request.RelatedLimit()
+	posts := make([]model.Post, 0, limit)
+	for _, relatedID := range ids {
+		if len(posts) == limit {
+			break
+		}
+
+		post, err := s.postRepository.GetPostByID(ctx, relatedID)
+		if errors.Is(err, repository.ErrPostNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, post)
+	}
+
+	return posts, nil
 }
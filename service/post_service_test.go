@@ -0,0 +1,141 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"main.go/auth"
+	"main.go/authz"
+	cache "main.go/cache/implementation"
+	"main.go/database/implementations/memory"
+	"main.go/messaging"
+	"main.go/model"
+	"main.go/repository"
+	"main.go/search"
+)
+
+// testPostService wires a PostService on the in-memory backends
+type testPostService struct {
+	*PostService
+	revisions *memory.PostRevisionMemoryDB
+	outbox    *memory.OutboxMemoryDB
+}
+
+func newTestPostService(t *testing.T) testPostService {
+	t.Helper()
+
+	policy, err := authz.DefaultPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	revisions := memory.NewPostRevisionMemoryDB()
+	outbox := memory.NewOutboxMemoryDB()
+	bus := messaging.NewMemoryMessaging()
+	t.Cleanup(func() { bus.Close() })
+	cacher := cache.NewMemoryCache()
+
+	s := NewPostService(
+		repository.NewPostRepository(memory.NewPostMemoryDB(), search.NewMemorySearchEngine()),
+		repository.NewPostRevisionRepository(revisions),
+		NewMessagingService(bus, repository.NewOutboxRepository(outbox, memory.NewTransactor())),
+		authz.NewAuthorizer(policy),
+		NewSuggestionCache(cacher, 0),
+		NewRelatedPostCache(cacher, 0),
+	)
+
+	return testPostService{PostService: s, revisions: revisions, outbox: outbox}
+}
+
+// asAuthor returns a context authenticated as a user without a role
+func asAuthor() context.Context {
+	return auth.WithUser(context.Background(), model.User{ID: primitive.NewObjectID(), UserName: "alice"})
+}
+
+// subjects returns the subjects of the pending events in the outbox
+func (s testPostService) subjects(t *testing.T) []string {
+	t.Helper()
+
+	events, err := s.outbox.GetPendingEvents(context.Background(), nil, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var subjects []string
+	for _, event := range events {
+		subjects = append(subjects, event.Subject)
+	}
+	return subjects
+}
+
+func TestAddPostRecordsRevisionAndEvent(t *testing.T) {
+	s := newTestPostService(t)
+	ctx := asAuthor()
+
+	post, err := s.AddPost(ctx, model.Post{Title: "Hello", Body: "World"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	revisions, err := s.GetPostRevisions(ctx, post.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 1 || revisions[0].Number != 1 || revisions[0].Title != "Hello" {
+		t.Fatalf("expected revision 1 of the post, got %+v", revisions)
+	}
+
+	if subjects := s.subjects(t); len(subjects) != 1 || subjects[0] != "post.added" {
+		t.Fatalf("expected a post.added event, got %v", subjects)
+	}
+}
+
+func TestUpdatePostRejectsStaleVersion(t *testing.T) {
+	s := newTestPostService(t)
+	ctx := asAuthor()
+
+	post, err := s.AddPost(ctx, model.Post{Title: "Hello", Body: "World"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.UpdatePost(ctx, post.ID.Hex(), model.Post{Title: "Changed", Body: "World", Version: post.Version + 1})
+	if !errors.Is(err, repository.ErrVersionMismatch) {
+		t.Fatalf("expected a version mismatch, got %v", err)
+	}
+}
+
+func TestUpdatePostRollsBackWhenRevisionFails(t *testing.T) {
+	s := newTestPostService(t)
+	ctx := asAuthor()
+
+	post, err := s.AddPost(ctx, model.Post{Title: "Hello", Body: "World"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Take the number of the next revision, so recording it fails
+	err = s.revisions.AddPostRevision(context.Background(), model.PostRevision{PostID: post.ID, Number: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.UpdatePost(ctx, post.ID.Hex(), model.Post{Title: "Changed", Body: "World"})
+	if err == nil {
+		t.Fatal("expected the update to fail")
+	}
+
+	stored, err := s.GetPostByID(ctx, post.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Title != "Hello" || stored.Version != 1 {
+		t.Fatalf("expected the update to be rolled back, got %+v", stored)
+	}
+
+	if subjects := s.subjects(t); len(subjects) != 1 || subjects[0] != "post.added" {
+		t.Fatalf("expected only the post.added event, got %v", subjects)
+	}
+}
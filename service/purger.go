@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"main.go/repository"
+)
+
+// Purger permanently removes the posts and users that stayed in the trash
+// for longer than the retention
+type Purger struct {
+	postRepository *repository.PostRepository
+	userRepository *repository.UserRepository
+	retention      time.Duration
+	interval       time.Duration
+}
+
+// NewPurger creates a new Purger checking the trash once per interval
+func NewPurger(postRepository *repository.PostRepository, userRepository *repository.UserRepository, retention time.Duration, interval time.Duration) *Purger {
+	return &Purger{
+		postRepository: postRepository,
+		userRepository: userRepository,
+		retention:      retention,
+		interval:       interval,
+	}
+}
+
+// Run purges the trash once per interval until the context is cancelled
+func (p *Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := p.Purge(ctx); err != nil {
+			log.Println("Failed to purge the trash:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Purge removes everything deleted longer than the retention ago
+func (p *Purger) Purge(ctx context.Context) error {
+	deletedBefore := time.Now().Add(-p.retention)
+
+	posts, err := p.postRepository.PurgePosts(ctx, deletedBefore)
+	if err != nil {
+		return err
+	}
+
+	users, err := p.userRepository.PurgeUsers(ctx, deletedBefore)
+	if err != nil {
+		return err
+	}
+
+	if posts > 0 || users > 0 {
+		log.Printf("Purged %d posts and %d users from the trash", posts, users)
+	}
+
+	return nil
+}
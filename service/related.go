@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"main.go/cache"
+)
+
+// relatedPrefix prefixes the cache keys of related posts
+const relatedPrefix = "related:posts:"
+
+// RelatedPostCache keeps the IDs of the posts related to a post, since
+// finding them takes one of the costlier queries of the search engine. The
+// worker drops the entry of a post once its change is indexed, see
+// Worker.syncPost. The posts are read when served, so changes to the related
+// posts show right away, while their effect on which posts are related shows
+// once the entry expires.
+type RelatedPostCache struct {
+	cache cache.Cacher
+	ttl   time.Duration
+}
+
+// NewRelatedPostCache creates a new RelatedPostCache. A TTL of 0 disables it.
+func NewRelatedPostCache(cache cache.Cacher, ttl time.Duration) *RelatedPostCache {
+	return &RelatedPostCache{
+		cache: cache,
+		ttl:   ttl,
+	}
+}
+
+// get returns the cached IDs of the posts related to the post, or loads and
+// caches them. The cache failing is logged and only costs the caching.
+func (c *RelatedPostCache) get(ctx context.Context, id primitive.ObjectID, load func() ([]primitive.ObjectID, error)) ([]primitive.ObjectID, error) {
+	if c.ttl <= 0 {
+		return load()
+	}
+
+	key := relatedPrefix + id.Hex()
+
+	var ids []primitive.ObjectID
+	if err := c.cache.Get(ctx, key, &ids); err == nil {
+		return ids, nil
+	}
+
+	ids, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.cache.Set(ctx, key, ids, c.ttl); err != nil {
+		log.Println("Failed to cache the related posts:", err)
+	}
+
+	return ids, nil
+}
+
+// invalidate drops the cached IDs of the posts related to the post. It does
+// so even with the cache disabled, as the servers sharing it may not be.
+func (c *RelatedPostCache) invalidate(ctx context.Context, id primitive.ObjectID) error {
+	return c.cache.Delete(ctx, relatedPrefix+id.Hex())
+}
@@ -0,0 +1,199 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"main.go/messaging"
+	"main.go/model"
+	"main.go/repository"
+)
+
+// cleanupInterval is how often the relay removes the delivered events past the retention
+const cleanupInterval = time.Hour
+
+// relayLease names the lease held by the relay publishing the outbox
+const relayLease = "outbox-relay"
+
+// RelayOptions configures the OutboxRelay
+type RelayOptions struct {
+	PollInterval time.Duration // How often the outbox is checked for pending events
+	BatchSize    int           // Most events read at once
+	RetryBackoff time.Duration // Delay before the first retry, doubled on every further failure
+	MaxBackoff   time.Duration // Longest delay between two attempts
+	Retention    time.Duration // How long delivered events are kept; 0 keeps them forever
+	LeaseTTL     time.Duration // How long the relay stays the only one publishing without renewing its lease
+}
+
+// OutboxRelay publishes the events stored in the outbox and marks them as
+// delivered. Delivery is at least once: an event published right before the
+// relay stops may be published again. The events of one key are published in
+// the order they were added; a failing event holds back the later events of
+// its key until it is published, while other keys carry on.
+//
+// Of the relays of all replicas, only the one holding the lease publishes, so
+// they never publish the events of a key side by side. It renews the lease
+// once half of it passed; another relay takes over once it expires.
+type OutboxRelay struct {
+	outbox      *repository.OutboxRepository
+	messaging   messaging.Messaging
+	options     RelayOptions
+	owner       string    // Identifies the relay as the holder of the lease
+	renewedAt   time.Time // When the lease was last acquired, before asking for it
+	lastCleanup time.Time
+}
+
+// NewOutboxRelay creates a new OutboxRelay publishing through the messaging system
+func NewOutboxRelay(outbox *repository.OutboxRepository, messaging messaging.Messaging, options RelayOptions) *OutboxRelay {
+	return &OutboxRelay{
+		outbox:    outbox,
+		messaging: messaging,
+		options:   options,
+		owner:     primitive.NewObjectID().Hex(),
+	}
+}
+
+// Run relays the pending events once per poll interval until the context is cancelled
+func (r *OutboxRelay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.options.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.Relay(ctx); err != nil {
+			log.Println("Failed to relay the outbox:", err)
+		}
+		r.cleanup(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Relay publishes the pending events that are due, batch by batch, while the
+// relay holds the lease. It goes through all pending events, so the events of
+// other keys are published while some keys wait for a retry.
+func (r *OutboxRelay) Relay(ctx context.Context) error {
+	// Keys whose earliest pending event waits for a retry or just failed
+	held := make(map[string]bool)
+
+	var after *model.OutboxEvent
+	for {
+		events, err := r.outbox.GetPendingEvents(ctx, after, r.options.BatchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, event := range events {
+			if held[event.Key] {
+				continue
+			}
+
+			now := time.Now()
+			if event.NextAttemptAt.After(now) {
+				held[event.Key] = true
+				continue
+			}
+
+			// Only the holder of the lease publishes
+			leader, err := r.renewLease(ctx)
+			if err != nil || !leader {
+				return err
+			}
+
+			err = r.messaging.Publish(ctx, outboxMessage(event))
+			if err != nil {
+				held[event.Key] = true
+
+				attempts := event.Attempts + 1
+				next := now.Add(r.backoff(attempts))
+				log.Printf("Failed to publish %s message %s (attempt %d), retrying at %s: %v", event.Subject, event.ID.Hex(), attempts, next.Format(time.RFC3339), err)
+
+				err = r.outbox.MarkFailed(ctx, event.ID, attempts, next, err.Error())
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			err = r.outbox.MarkDelivered(ctx, event.ID, now)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(events) < r.options.BatchSize {
+			return nil
+		}
+		after = &events[len(events)-1]
+	}
+}
+
+// renewLease acquires the lease once half of it passed, and reports whether
+// the relay holds it
+func (r *OutboxRelay) renewLease(ctx context.Context) (bool, error) {
+	if time.Since(r.renewedAt) < r.options.LeaseTTL/2 {
+		return true, nil
+	}
+
+	start := time.Now()
+	leader, err := r.outbox.AcquireLease(ctx, relayLease, r.owner, r.options.LeaseTTL)
+	if err != nil || !leader {
+		return false, err
+	}
+	r.renewedAt = start
+
+	return true, nil
+}
+
+// outboxMessage builds the message of an outbox event. Its ID goes along as
+// the Message-Id header, so an event published again after a crash of the
+// relay is dropped where the messaging detects duplicates.
+func outboxMessage(event model.OutboxEvent) *messaging.Message {
+	header := messaging.Header{}
+	for key, value := range event.Header {
+		header[key] = value
+	}
+	header[messaging.MessageIDHeader] = event.ID.Hex()
+
+	return &messaging.Message{
+		Subject: event.Subject,
+		Header:  header,
+		Data:    event.Data,
+	}
+}
+
+// backoff returns the delay after the given number of failed attempts
+func (r *OutboxRelay) backoff(attempts int) time.Duration {
+	delay := r.options.RetryBackoff
+	for i := 1; i < attempts && delay < r.options.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > r.options.MaxBackoff {
+		delay = r.options.MaxBackoff
+	}
+	return delay
+}
+
+// cleanup removes the events delivered longer than the retention ago, at most
+// once per cleanup interval
+func (r *OutboxRelay) cleanup(ctx context.Context) {
+	if r.options.Retention <= 0 || time.Since(r.lastCleanup) < cleanupInterval {
+		return
+	}
+	r.lastCleanup = time.Now()
+
+	deleted, err := r.outbox.DeleteDelivered(ctx, time.Now().Add(-r.options.Retention))
+	if err != nil {
+		log.Println("Failed to remove delivered events from the outbox:", err)
+		return
+	}
+
+	if deleted > 0 {
+		log.Printf("Removed %d delivered events from the outbox", deleted)
+	}
+}
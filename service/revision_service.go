@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"main.go/auth"
+	"main.go/diff"
+	"main.go/errs"
+	"main.go/model"
+)
+
+// GetPostRevisions returns the revisions of a post, oldest first
+func (s *PostService) GetPostRevisions(ctx context.Context, id string) ([]model.PostRevision, error) {
+	post, err := s.GetPostByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.revisionRepository.GetPostRevisions(ctx, post.ID)
+}
+
+// GetPostRevision returns the revision of a post with the number
+func (s *PostService) GetPostRevision(ctx context.Context, id string, number int64) (model.PostRevision, error) {
+	if number <= 0 {
+		return model.PostRevision{}, invalidRevision("revision", number)
+	}
+
+	post, err := s.GetPostByID(ctx, id)
+	if err != nil {
+		return model.PostRevision{}, err
+	}
+
+	return s.revisionRepository.GetPostRevision(ctx, post.ID, number)
+}
+
+// DiffPostRevisions compares the revisions from and to of a post
+func (s *PostService) DiffPostRevisions(ctx context.Context, id string, from int64, to int64) (model.PostRevisionDiff, error) {
+	if from <= 0 {
+		return model.PostRevisionDiff{}, invalidRevision("from", from)
+	}
+	if to <= 0 {
+		return model.PostRevisionDiff{}, invalidRevision("to", to)
+	}
+
+	older, err := s.GetPostRevision(ctx, id, from)
+	if err != nil {
+		return model.PostRevisionDiff{}, err
+	}
+	newer, err := s.GetPostRevision(ctx, id, to)
+	if err != nil {
+		return model.PostRevisionDiff{}, err
+	}
+
+	fromName := fmt.Sprintf("revision %d", from)
+	toName := fmt.Sprintf("revision %d", to)
+
+	return model.PostRevisionDiff{
+		PostID: older.PostID,
+		From:   from,
+		To:     to,
+		Title:  diff.Unified(fromName, toName, older.Title, newer.Title),
+		Body:   diff.Unified(fromName, toName, older.Body, newer.Body),
+		Tags:   diff.Unified(fromName, toName, tagLines(older.Tags), tagLines(newer.Tags)),
+	}, nil
+}
+
+// RestorePostRevision brings the title, body and tags of a revision back through
+// UpdatePost, so the restore is checked, indexed, published and itself
+// recorded like any other update. A non-zero version must match the post.
+func (s *PostService) RestorePostRevision(ctx context.Context, id string, number int64, version int64) (model.Post, error) {
+	revision, err := s.GetPostRevision(ctx, id, number)
+	if err != nil {
+		return model.Post{}, err
+	}
+
+	post := model.Post{
+		Title:   revision.Title,
+		Body:    revision.Body,
+		Tags:    revision.Tags,
+		Version: version,
+	}
+
+	return s.UpdatePost(ctx, id, post)
+}
+
+// recordRevision stores the post as the revision of its version. It runs in
+// the transaction writing the post, so every version has its revision.
+func (s *PostService) recordRevision(ctx context.Context, post model.Post, fields []string) error {
+	revision := model.PostRevision{
+		PostID:    post.ID,
+		Number:    post.Version,
+		Title:     post.Title,
+		Body:      post.Body,
+		Tags:      post.Tags,
+		Fields:    fields,
+		CreatedAt: post.UpdatedAt,
+	}
+	if revision.Fields == nil {
+		revision.Fields = []string{}
+	}
+	if editor, ok := auth.UserFromContext(ctx); ok {
+		revision.EditorID = editor.ID
+	}
+
+	err := s.revisionRepository.AddPostRevision(ctx, revision)
+	if err != nil {
+		return fmt.Errorf("failed to record revision %d of post %s: %v", revision.Number, post.ID.Hex(), err)
+	}
+	return nil
+}
+
+// recordDeletion records the revision of a post just moved to the trash,
+// whose version the deletion bumped
+func (s *PostService) recordDeletion(ctx context.Context, id primitive.ObjectID) error {
+	deleted, err := s.postRepository.GetDeletedPostByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	return s.recordRevision(ctx, deleted, []string{"deleted_at"})
+}
+
+// changedPostFields returns the revisioned fields that differ between the posts
+func changedPostFields(before model.Post, after model.Post) []string {
+	fields := []string{}
+	if before.Title != after.Title {
+		fields = append(fields, "title")
+	}
+	if before.Body != after.Body {
+		fields = append(fields, "body")
+	}
+	if tagLines(before.Tags) != tagLines(after.Tags) {
+		fields = append(fields, "tags")
+	}
+	return fields
+}
+
+// tagLines puts every tag on a line of its own, for comparing and diffing them
+func tagLines(tags []string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	return strings.Join(tags, "\n") + "\n"
+}
+
+// invalidRevision reports a revision number that cannot exist
+func invalidRevision(field string, number int64) error {
+	return errs.Validation(errs.CodeInvalidRevision, fmt.Sprintf("invalid revision %d", number), errs.FieldError{
+		Field:   field,
+		Message: "must be a positive integer",
+	})
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"main.go/repository"
+)
+
+// SearchIndices are the search indices SearchIndexService maintains
+var SearchIndices = []string{"posts", "users"}
+
+// SearchIndexService rebuilds the search indices from the database and
+// checks them against it
+type SearchIndexService struct {
+	postRepository *repository.PostRepository
+	userRepository *repository.UserRepository
+}
+
+// NewSearchIndexService creates a new SearchIndexService
+func NewSearchIndexService(postRepository *repository.PostRepository, userRepository *repository.UserRepository) *SearchIndexService {
+	return &SearchIndexService{
+		postRepository: postRepository,
+		userRepository: userRepository,
+	}
+}
+
+// Reindex copies every post or user into a new versioned index and points
+// the alias of the index at it
+func (s *SearchIndexService) Reindex(ctx context.Context, index string) (repository.IndexRebuild, error) {
+	switch index {
+	case "posts":
+		return s.postRepository.RebuildIndex(ctx)
+	case "users":
+		return s.userRepository.RebuildIndex(ctx)
+	}
+	return repository.IndexRebuild{}, unknownIndex(index)
+}
+
+// Verify reports the documents of the index that are missing, stale or
+// orphaned compared to the database and syncs them when repairing
+func (s *SearchIndexService) Verify(ctx context.Context, index string, repair bool) (repository.IndexReport, error) {
+	switch index {
+	case "posts":
+		return s.postRepository.VerifyIndex(ctx, repair)
+	case "users":
+		return s.userRepository.VerifyIndex(ctx, repair)
+	}
+	return repository.IndexReport{}, unknownIndex(index)
+}
+
+// EnsureIndices rebuilds the indices created with older mappings than the
+// current ones, or that do not exist yet
+func (s *SearchIndexService) EnsureIndices(ctx context.Context) error {
+	outdated := map[string]func(ctx context.Context) (bool, error){
+		"posts": s.postRepository.IndexOutdated,
+		"users": s.userRepository.IndexOutdated,
+	}
+
+	for _, index := range SearchIndices {
+		ok, err := outdated[index](ctx)
+		if err != nil {
+			return fmt.Errorf("failed to check the mappings of %s: %v", index, err)
+		}
+		if !ok {
+			continue
+		}
+
+		log.Printf("The %s index is missing or predates its current mappings, reindexing", index)
+		rebuild, err := s.Reindex(ctx, index)
+		if err != nil {
+			return fmt.Errorf("failed to reindex %s: %v", index, err)
+		}
+		log.Printf("Indexed %d documents into %s", rebuild.Documents, rebuild.Index)
+	}
+
+	return nil
+}
+
+func unknownIndex(index string) error {
+	return fmt.Errorf("unknown index %q, expected one of %v", index, SearchIndices)
+}
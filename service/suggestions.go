@@ -0,0 +1,58 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"main.go/cache"
+	"main.go/model"
+)
+
+// suggestPrefix prefixes the cache keys of suggestions
+const suggestPrefix = "suggest:"
+
+// SuggestionCache keeps the suggestions made for a prefix for a short while,
+// so the many users typing the same prefixes do not each query the search
+// engine. Suggestions therefore lag behind changes by up to the TTL.
+type SuggestionCache struct {
+	cache cache.Cacher
+	ttl   time.Duration
+}
+
+// NewSuggestionCache creates a new SuggestionCache. A TTL of 0 disables it.
+func NewSuggestionCache(cache cache.Cacher, ttl time.Duration) *SuggestionCache {
+	return &SuggestionCache{
+		cache: cache,
+		ttl:   ttl,
+	}
+}
+
+// get returns the cached suggestions for the prefix, or loads and caches
+// them. Prefixes differing only in case share their suggestions. The cache
+// failing is logged and only costs the caching.
+func (c *SuggestionCache) get(ctx context.Context, index string, request model.SuggestRequest, load func() ([]model.Suggestion, error)) ([]model.Suggestion, error) {
+	if c.ttl <= 0 {
+		return load()
+	}
+
+	key := fmt.Sprintf("%s%s:%d:%s", suggestPrefix, index, request.SuggestLimit(), strings.ToLower(request.Prefix))
+
+	var suggestions []model.Suggestion
+	if err := c.cache.Get(ctx, key, &suggestions); err == nil {
+		return suggestions, nil
+	}
+
+	suggestions, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.cache.Set(ctx, key, suggestions, c.ttl); err != nil {
+		log.Println("Failed to cache the suggestions:", err)
+	}
+
+	return suggestions, nil
+}
@@ -1,138 +1,426 @@
 package service
 
 import (
-	"fmt"
-	"log"
+	"context"
+	"errors"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"main.go/auth"
+	"main.go/authz"
+	"main.go/errs"
 	"main.go/model"
+	"main.go/patch"
 	"main.go/repository"
-	"main.go/search"
+	"main.go/validate"
 )
 
+// What happens to the posts of a user when the user is deleted
+const (
+	CascadeDelete   = "delete"   // The posts are deleted too
+	CascadeOrphan   = "orphan"   // The posts are kept without an author
+	CascadeReassign = "reassign" // The posts are moved to another user
+)
+
+// PostCascade configures how UserService.DeleteUser treats the posts of the deleted user
+type PostCascade struct {
+	Mode       string             // CascadeDelete, CascadeOrphan or CascadeReassign
+	ReassignTo primitive.ObjectID // New author when Mode is CascadeReassign
+}
+
 type UserService struct {
 	userRepository *repository.UserRepository
+	postService    *PostService
 	messaging      *MessagingService
+	authorizer     *authz.Authorizer
+	postCascade    PostCascade
+	suggestions    *SuggestionCache
 }
 
-func NewUserService(userRepository *repository.UserRepository, messaging *MessagingService) *UserService {
+func NewUserService(userRepository *repository.UserRepository, postService *PostService, messaging *MessagingService, authorizer *authz.Authorizer, postCascade PostCascade, suggestions *SuggestionCache) *UserService {
 	return &UserService{
 		userRepository: userRepository,
+		postService:    postService,
 		messaging:      messaging,
+		authorizer:     authorizer,
+		postCascade:    postCascade,
+		suggestions:    suggestions,
 	}
 }
 
-func (s *UserService) GetUsers() ([]model.User, error) {
-	return s.userRepository.GetUsers()
+func (s *UserService) GetUsers(ctx context.Context, filter model.UserFilter, page model.PageRequest) (model.UserPage, error) {
+	if err := s.authorizer.Check(ctx, "users:read"); err != nil {
+		return model.UserPage{}, err
+	}
+
+	if err := s.checkEmailQuery(ctx, filter, page); err != nil {
+		return model.UserPage{}, err
+	}
+	filter.Email = model.NormalizeEmail(filter.Email)
+
+	users, err := s.userRepository.GetUsers(ctx, filter, page)
+	if err != nil {
+		return model.UserPage{}, err
+	}
+
+	for i := range users.Data {
+		users.Data[i] = s.redact(ctx, users.Data[i])
+	}
+
+	return users, nil
 }
 
-func (s *UserService) GetUserByID(id string) (model.User, error) {
+func (s *UserService) GetUserByID(ctx context.Context, id string) (model.User, error) {
+	if err := s.authorizer.Check(ctx, "users:read"); err != nil {
+		return model.User{}, err
+	}
+
 	// Directly call the repository method to get user by ID
-	return s.userRepository.GetUserByID(id)
+	user, err := s.userRepository.GetUserByID(ctx, id)
+	if err != nil {
+		return model.User{}, err
+	}
+
+	return s.redact(ctx, user), nil
+}
+
+// checkEmailQuery refuses to filter or sort users by email to callers not
+// allowed to read the addresses, which the results or the cursors, holding
+// the value sorted by, would reveal
+func (s *UserService) checkEmailQuery(ctx context.Context, filter model.UserFilter, page model.PageRequest) error {
+	field, _ := page.SortField()
+	if filter.Email == "" && field != "email" {
+		return nil
+	}
+
+	return s.authorizer.Check(ctx, "users:read:email")
+}
+
+// redact hides the email address unless the caller may read it or it is their own
+func (s *UserService) redact(ctx context.Context, user model.User) model.User {
+	if caller, ok := auth.UserFromContext(ctx); ok && caller.ID == user.ID {
+		return user
+	}
+
+	if !s.authorizer.Can(ctx, "users:read:email") {
+		user.Email = ""
+	}
+
+	return user
 }
 
-func (s *UserService) AddUser(user model.User) (model.User, error) {
-	addedUser, err := s.userRepository.AddUser(user)
+func (s *UserService) AddUser(ctx context.Context, user model.User) (model.User, error) {
+	if err := s.authorizer.Check(ctx, "users:create"); err != nil {
+		return model.User{}, err
+	}
+
+	// Handing out roles is a permission of its own
+	if user.Role != "" {
+		if err := s.authorizer.Check(ctx, "users:assign-role"); err != nil {
+			return model.User{}, err
+		}
+	}
+
+	return s.addUser(ctx, user)
+}
+
+// addUser stores the user without checking the caller, as used by registration
+func (s *UserService) addUser(ctx context.Context, user model.User) (model.User, error) {
+	user.Email = model.NormalizeEmail(user.Email)
+	if err := validate.Struct(user); err != nil {
+		return model.User{}, err
+	}
+
+	// Store the user together with the message announcing it
+	var addedUser model.User
+	err := s.messaging.Transaction(ctx, func(ctx context.Context) error {
+		var err error
+		addedUser, err = s.userRepository.AddUser(ctx, user)
+		if err != nil {
+			return err
+		}
+		return s.publish(ctx, "user.added", UserChange{
+			After:  &addedUser,
+			Fields: changedUserFields(model.User{}, addedUser),
+		})
+	})
 	if err != nil {
 		return model.User{}, err
 	}
 
-	// Get the latest inserted user from the database
-	latestUser, err := s.userRepository.GetLatestInsertedUser()
+	return addedUser, nil
+}
+
+func (s *UserService) UpdateUser(ctx context.Context, id string, user model.User) (model.User, error) {
+	objID, err := model.ParseID(id)
 	if err != nil {
-		// Handle the error if necessary
-		log.Println("Failed to get the latest inserted user from the database:", err)
-		return latestUser, nil
+		return model.User{}, err
 	}
 
-	// Convert the primitive.ObjectID to a string
-	userID := latestUser.ID.Hex()
+	user.Email = model.NormalizeEmail(user.Email)
+	if err := validate.Struct(user); err != nil {
+		return model.User{}, err
+	}
 
-	// Publish a message indicating a new user has been added
-	err = s.messaging.Publish("user.added", []byte(userID))
+	// Users may only change themselves, unless they may change anybody
+	if err := s.authorizer.CheckOwned(ctx, "users:update", objID); err != nil {
+		return model.User{}, err
+	}
+	existing, err := s.userRepository.GetUserByIDFromDB(ctx, id)
 	if err != nil {
-		// Log the error if publishing fails
-		fmt.Printf("Failed to publish user.added message: %v\n", err)
-	} else {
-		// Log success if publishing is successful
-		fmt.Printf("Successfully published user.added message for user %s\n", userID)
+		return model.User{}, err
 	}
+	user.ID = objID
 
-	return addedUser, nil
+	// Update the user in the repository together with the message announcing it
+	var updatedUser model.User
+	err = s.messaging.Transaction(ctx, func(ctx context.Context) error {
+		updatedUser, err = s.userRepository.UpdateUser(ctx, user)
+		if err != nil {
+			return err
+		}
+		return s.publish(ctx, "user.updated", UserChange{
+			Before: &existing,
+			After:  &updatedUser,
+			Fields: changedUserFields(existing, updatedUser),
+		})
+	})
+	if err != nil {
+		return model.User{}, err
+	}
+
+	return s.redact(ctx, updatedUser), nil
 }
 
-func (s *UserService) UpdateUser(id string, user model.User) error {
-	// Convert the string ID to a primitive.ObjectID
-	objID, err := primitive.ObjectIDFromHex(id)
+// PatchUser applies a merge patch or JSON patch to the user and stores the
+// changed fields in one atomic update, like PostService.PatchPost
+func (s *UserService) PatchUser(ctx context.Context, id string, version int64, p patch.Patch) (model.User, error) {
+	objID, err := model.ParseID(id)
 	if err != nil {
-		return fmt.Errorf("invalid object ID format: %v", err)
+		return model.User{}, err
 	}
 
-	// Update the user in the repository
-	err = s.userRepository.UpdateUser(user)
+	// Users may only change themselves, unless they may change anybody
+	if err := s.authorizer.CheckOwned(ctx, "users:update", objID); err != nil {
+		return model.User{}, err
+	}
+
+	var patchedUser model.User
+	for attempt := 1; ; attempt++ {
+		existing, err := s.userRepository.GetUserByIDFromDB(ctx, id)
+		if err != nil {
+			return model.User{}, err
+		}
+		if version != 0 && existing.Version != version {
+			return model.User{}, repository.ErrVersionMismatch
+		}
+
+		user := existing
+		fields, err := p.Apply(&user, model.UserPatchFields)
+		if err != nil {
+			return model.User{}, err
+		}
+		if len(fields) == 0 {
+			return s.redact(ctx, existing), nil
+		}
+
+		user.Email = model.NormalizeEmail(user.Email)
+		if err := validate.Struct(user); err != nil {
+			return model.User{}, err
+		}
+
+		// Only write if nobody changed the user since it was read
+		user.Version = existing.Version
+		err = s.messaging.Transaction(ctx, func(ctx context.Context) error {
+			patchedUser, err = s.userRepository.PatchUser(ctx, user, fields)
+			if err != nil {
+				return err
+			}
+			return s.publish(ctx, "user.updated", UserChange{
+				Before: &existing,
+				After:  &patchedUser,
+				Fields: fields,
+			})
+		})
+		if errors.Is(err, repository.ErrVersionMismatch) && version == 0 && attempt < maxPatchAttempts {
+			continue
+		}
+		if err != nil {
+			return model.User{}, err
+		}
+		break
+	}
+
+	return s.redact(ctx, patchedUser), nil
+}
+
+// DeleteUser deletes the user if it still has the version, or any version when
+// it is zero, after dealing with their posts as configured
+func (s *UserService) DeleteUser(ctx context.Context, id string, version int64) error {
+	objID, err := model.ParseID(id)
 	if err != nil {
 		return err
 	}
 
-	// Publish a message indicating a user has been updated
-	err = s.messaging.Publish("user.updated", []byte(objID.Hex()))
+	// Users may only delete themselves, unless they may delete anybody
+	if err := s.authorizer.CheckOwned(ctx, "users:delete", objID); err != nil {
+		return err
+	}
+	existing, err := s.userRepository.GetUserByIDFromDB(ctx, id)
 	if err != nil {
-		// Log the error if publishing fails
-		fmt.Printf("Failed to publish user.updated message: %v\n", err)
-	} else {
-		// Log success if publishing is successful
-		fmt.Printf("Successfully published user.updated message for user %s\n", objID.Hex())
+		return err
 	}
 
-	return nil
+	// Fail before the posts are touched; the delete checks the version again
+	if version != 0 && existing.Version != version {
+		return repository.ErrVersionMismatch
+	}
+
+	// The posts, the user and the messages about them change together
+	return s.messaging.Transaction(ctx, func(ctx context.Context) error {
+		// Deal with the posts of the user before the user disappears
+		err := s.cascadePosts(ctx, objID)
+		if err != nil {
+			return err
+		}
+
+		err = s.userRepository.DeleteUser(ctx, id, version)
+		if err != nil {
+			return err
+		}
+		return s.publish(ctx, "user.deleted", UserChange{
+			Before: &existing,
+			Fields: []string{"deleted_at"},
+		})
+	})
 }
 
-func (s *UserService) PatchUser(id string, user model.User) (model.User, error) {
-	// Patch the user in the repository
-	patchedUser, err := s.userRepository.PatchUser(user)
+// GetDeletedUsers returns a page of the users in the trash
+func (s *UserService) GetDeletedUsers(ctx context.Context, filter model.UserFilter, page model.PageRequest) (model.UserPage, error) {
+	if err := s.authorizer.Check(ctx, "users:restore:any"); err != nil {
+		return model.UserPage{}, err
+	}
+	if err := s.checkEmailQuery(ctx, filter, page); err != nil {
+		return model.UserPage{}, err
+	}
+	filter.Deleted = true
+
+	users, err := s.userRepository.GetUsers(ctx, filter, page)
+	if err != nil {
+		return model.UserPage{}, err
+	}
+
+	for i := range users.Data {
+		users.Data[i] = s.redact(ctx, users.Data[i])
+	}
+
+	return users, nil
+}
+
+// RestoreUser takes the user out of the trash. Their posts are not restored,
+// as they may have been orphaned or reassigned in the meantime.
+func (s *UserService) RestoreUser(ctx context.Context, id string) (model.User, error) {
+	objID, err := model.ParseID(id)
 	if err != nil {
 		return model.User{}, err
 	}
 
-	// Publish a message indicating a user has been updated
-	err = s.messaging.Publish("user.updated", []byte(patchedUser.ID.Hex()))
+	if err := s.authorizer.CheckOwned(ctx, "users:restore", objID); err != nil {
+		return model.User{}, err
+	}
+
+	// Restore the user together with the message announcing it
+	var restoredUser model.User
+	err = s.messaging.Transaction(ctx, func(ctx context.Context) error {
+		restoredUser, err = s.userRepository.RestoreUser(ctx, objID)
+		if err != nil {
+			return err
+		}
+		return s.publish(ctx, "user.restored", UserChange{
+			After:  &restoredUser,
+			Fields: []string{"deleted_at"},
+		})
+	})
 	if err != nil {
-		// Log the error if publishing fails
-		fmt.Printf("Failed to publish user.updated message: %v\n", err)
-	} else {
-		// Log success if publishing is successful
-		fmt.Printf("Successfully published user.updated message for user %s\n", patchedUser.ID.Hex())
+		return model.User{}, err
 	}
 
-	return patchedUser, nil
+	return s.redact(ctx, restoredUser), nil
 }
 
-func (s *UserService) DeleteUser(id string) error {
-	// Directly call the repository method to delete user
-	err := s.userRepository.DeleteUser(id)
+// publish adds the event about the change of a user to the outbox
+func (s *UserService) publish(ctx context.Context, eventType string, change UserChange) error {
+	user := change.After
+	if user == nil {
+		user = change.Before
+	}
+
+	event, err := NewEvent(eventType, "/users", user.ID.Hex(), change)
 	if err != nil {
 		return err
 	}
 
-	// Publish a message indicating a user has been deleted
-	err = s.messaging.Publish("user.deleted", []byte(id))
-	if err != nil {
-		// Log the error if publishing fails
-		fmt.Printf("Failed to publish user.deleted message: %v\n", err)
-	} else {
-		// Log success if publishing is successful
-		fmt.Printf("Successfully published user.deleted message for user %s\n", id)
+	return s.messaging.PublishEvent(ctx, event)
+}
+
+// changedUserFields returns the fields that differ between the users
+func changedUserFields(before model.User, after model.User) []string {
+	fields := []string{}
+	if before.FullName != after.FullName {
+		fields = append(fields, "fullname")
+	}
+	if before.UserName != after.UserName {
+		fields = append(fields, "username")
 	}
+	if before.Email != after.Email {
+		fields = append(fields, "email")
+	}
+	if before.Role != after.Role {
+		fields = append(fields, "role")
+	}
+	return fields
+}
 
-	return nil
+// cascadePosts deletes, orphans or reassigns the posts of a user that is being deleted
+func (s *UserService) cascadePosts(ctx context.Context, authorID primitive.ObjectID) error {
+	switch s.postCascade.Mode {
+	case CascadeDelete:
+		return s.postService.deleteAuthorPosts(ctx, authorID)
+	case CascadeReassign:
+		if s.postCascade.ReassignTo == authorID {
+			return errs.Conflict(errs.CodeCascadeInvalid, "cannot delete the user that posts are reassigned to")
+		}
+		return s.postService.reassignAuthorPosts(ctx, authorID, s.postCascade.ReassignTo)
+	default:
+		return s.postService.reassignAuthorPosts(ctx, authorID, primitive.NilObjectID)
+	}
 }
 
-func (s *UserService) SearchUser(query string) ([]search.SearchResult, error) {
-	// Directly call the repository method to search for users
-	results, err := s.userRepository.SearchUsers(query)
+// SearchUser returns a page of the users matching the query. Like their
+// email addresses, callers not allowed to read them cannot search them.
+func (s *UserService) SearchUser(ctx context.Context, request model.SearchRequest) (model.UserSearchPage, error) {
+	if err := s.authorizer.Check(ctx, "users:read"); err != nil {
+		return model.UserSearchPage{}, err
+	}
+
+	page, err := s.userRepository.SearchUsers(ctx, request, s.authorizer.Can(ctx, "users:read:email"))
 	if err != nil {
+		return model.UserSearchPage{}, err
+	}
+
+	for i := range page.Data {
+		page.Data[i].User = s.redact(ctx, page.Data[i].User)
+	}
+
+	return page, nil
+}
+
+// SuggestUsers returns the usernames starting with the prefix
+func (s *UserService) SuggestUsers(ctx context.Context, request model.SuggestRequest) ([]model.Suggestion, error) {
+	if err := s.authorizer.Check(ctx, "users:read"); err != nil {
 		return nil, err
 	}
 
-	return results, nil
+	return s.suggestions.get(ctx, "users", request, func() ([]model.Suggestion, error) {
+		return s.userRepository.SuggestUsers(ctx, request.Prefix, request.SuggestLimit())
+	})
 }
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"main.go/messaging"
+	"main.go/repository"
+)
+
+// WorkerOptions configures the Worker
+type WorkerOptions struct {
+	Queue        string        // Queue group shared by the workers
+	Retries      int           // Further attempts after a failed reindex before the event fails
+	RetryBackoff time.Duration // Delay before the first retry, doubled on every further failure
+	MaxBackoff   time.Duration // Longest delay between two attempts
+}
+
+// Worker keeps the cache and the search index in sync with the database by
+// reindexing the post or user of every post.* and user.* event. Reindexing
+// reads the stored state instead of the event data, so handling an event
+// twice or out of order does no harm. A reindex that still fails after the
+// retries fails the event, which JetStream delivers again later.
+type Worker struct {
+	messaging      *MessagingService
+	postRepository *repository.PostRepository
+	userRepository *repository.UserRepository
+	related        *RelatedPostCache
+	options        WorkerOptions
+}
+
+// NewWorker creates a new Worker
+func NewWorker(messaging *MessagingService, postRepository *repository.PostRepository, userRepository *repository.UserRepository, related *RelatedPostCache, options WorkerOptions) *Worker {
+	return &Worker{
+		messaging:      messaging,
+		postRepository: postRepository,
+		userRepository: userRepository,
+		related:        related,
+		options:        options,
+	}
+}
+
+// Run handles the events until the context is cancelled and then waits for
+// the events at hand. The workers of a queue group share the events.
+func (w *Worker) Run(ctx context.Context) error {
+	subscriptions := map[string]func(ctx context.Context, id primitive.ObjectID) error{
+		"post.*": w.syncPost,
+		"user.*": w.userRepository.ReindexUser,
+	}
+
+	var subs []messaging.Subscription
+	defer func() {
+		for _, sub := range subs {
+			if err := sub.Drain(); err != nil {
+				log.Println("Failed to drain the worker subscription:", err)
+			}
+		}
+	}()
+
+	for topic, reindex := range subscriptions {
+		sub, err := w.messaging.QueueSubscribe(topic, w.options.Queue, w.handler(reindex))
+		if err != nil {
+			return fmt.Errorf("failed to subscribe to %s: %v", topic, err)
+		}
+		subs = append(subs, sub)
+	}
+
+	<-ctx.Done()
+	return nil
+}
+
+// syncPost reindexes the post and then drops the posts related to it from
+// the cache, so they are found again from its indexed title and body
+func (w *Worker) syncPost(ctx context.Context, id primitive.ObjectID) error {
+	if err := w.postRepository.ReindexPost(ctx, id); err != nil {
+		return err
+	}
+	return w.related.invalidate(ctx, id)
+}
+
+// handler reindexes the entity the event is about, retrying failures
+func (w *Worker) handler(reindex func(ctx context.Context, id primitive.ObjectID) error) messaging.Handler {
+	return func(ctx context.Context, msg *messaging.Message) error {
+		event, err := DecodeEvent(msg.Data)
+		if err != nil {
+			return err
+		}
+
+		id, err := primitive.ObjectIDFromHex(event.Subject)
+		if err != nil {
+			return fmt.Errorf("invalid subject %q of %s event %s", event.Subject, event.Type, event.ID)
+		}
+
+		for attempt := 1; ; attempt++ {
+			err = reindex(ctx, id)
+			if err == nil || attempt > w.options.Retries {
+				return err
+			}
+
+			delay := w.backoff(attempt)
+			log.Printf("Failed to sync %s of %s event %s (attempt %d, correlation ID %q), retrying in %s: %v", id.Hex(), event.Type, event.ID, attempt, messaging.CorrelationID(ctx), delay, err)
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+	}
+}
+
+// backoff returns the delay after the given number of failed attempts
+func (w *Worker) backoff(attempts int) time.Duration {
+	delay := w.options.RetryBackoff
+	for i := 1; i < attempts && delay < w.options.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > w.options.MaxBackoff {
+		delay = w.options.MaxBackoff
+	}
+	return delay
+}
@@ -0,0 +1,116 @@
+// Package validate checks structs against the rules declared in their
+// validate tags, for example:
+//
+//	Title string `json:"title" validate:"required,max=200"`
+//
+// Rules are separated by commas and take their parameter after "=". Only
+// string fields are checked. Errors name the field by its JSON name.
+package validate
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"main.go/errs"
+)
+
+// rule checks a value against the rule parameter and returns what is wrong
+// with it, or an empty string when the value is fine
+type rule func(value, param string) string
+
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// rules holds every rule that may be used in a validate tag
+var rules = map[string]rule{
+	"required": func(value, _ string) string {
+		if strings.TrimSpace(value) == "" {
+			return "is required"
+		}
+		return ""
+	},
+	"min": func(value, param string) string {
+		if n, _ := strconv.Atoi(param); utf8.RuneCountInString(value) < n {
+			return fmt.Sprintf("must be at least %s characters long", param)
+		}
+		return ""
+	},
+	"max": func(value, param string) string {
+		if n, _ := strconv.Atoi(param); utf8.RuneCountInString(value) > n {
+			return fmt.Sprintf("must be at most %s characters long", param)
+		}
+		return ""
+	},
+	"email": func(value, _ string) string {
+		if addr, err := mail.ParseAddress(value); err != nil || addr.Address != value {
+			return "must be a valid email address"
+		}
+		return ""
+	},
+	"username": func(value, _ string) string {
+		if !usernamePattern.MatchString(value) {
+			return "may only contain letters, digits, '_', '.' and '-'"
+		}
+		return ""
+	},
+}
+
+// Struct checks every field of v, which must be a struct or a pointer to one
+func Struct(v interface{}) error {
+	value := reflect.Indirect(reflect.ValueOf(v))
+	typ := value.Type()
+
+	var fields []errs.FieldError
+	for i := 0; i < typ.NumField(); i++ {
+		tag := typ.Field(i).Tag.Get("validate")
+		if tag == "" || value.Field(i).Kind() != reflect.String {
+			continue
+		}
+
+		if message := checkField(value.Field(i).String(), tag); message != "" {
+			fields = append(fields, errs.FieldError{Field: jsonName(typ.Field(i)), Message: message})
+		}
+	}
+
+	if len(fields) > 0 {
+		return errs.Validation(errs.CodeValidationFailed, "the request contains invalid fields", fields...)
+	}
+
+	return nil
+}
+
+// checkField applies the rules of the tag in order and reports the first failure.
+// Empty values only have to pass the required rule.
+func checkField(value, tag string) string {
+	for _, spec := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(spec, "=")
+
+		check, ok := rules[name]
+		if !ok {
+			panic(fmt.Sprintf("validate: unknown rule %q", name))
+		}
+
+		if value == "" && name != "required" {
+			continue
+		}
+
+		if message := check(value, param); message != "" {
+			return message
+		}
+	}
+
+	return ""
+}
+
+// jsonName returns the name the field has in JSON
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}